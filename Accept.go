@@ -0,0 +1,204 @@
+package bufconn
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxProxyHeaderSize is the longest PROXY protocol v1 header allowed by the spec, including the line ending
+const maxProxyHeaderSize = 107
+
+// AcceptSetup is what an Acceptor does with each connection, in order, before returning it
+type AcceptSetup struct {
+	// ProxyHeader reads a PROXY protocol v1 header (as sent by load balancers such as HAProxy) from the start of the connection. The address in it is then returned by RemoteAddr
+	ProxyHeader bool
+	// TLS, if not nil, is used to serve TLS over each connection, after the PROXY header if there is one. Connections from a listener made with tls.NewListener are also handshaken during setup, so their server name is known
+	TLS *tls.Config
+	// Routes picks the handler configuration for each connection by the server name the remote asked for with SNI, so one listener can host several services on one port.
+	// Connections whose server name is not in Routes, or which are not TLS, use the Acceptor's handler, delimiter, Auth and Handshake
+	Routes map[string]AcceptRoute
+	// Auth is passed the first message from the remote, and returns who the remote is (see Conn.Identity). Returning an error rejects the remote. If nil, no auth message is read
+	Auth func(remote net.Addr, msg string) (string, error)
+	// Handshake is performed after auth, as with NewConnHandshake. If nil, no handshake is done
+	Handshake *Handshake
+	// Timeout is how long the remote has to complete every step. If zero, then no timeout will be used, apart from the handshake's own
+	Timeout time.Duration
+	// OnReject is called with the connection and error when a remote fails a step, just before it is closed. It may be nil
+	OnReject func(c net.Conn, err error)
+}
+
+// AcceptRoute is the handler configuration for the connections to one server name (see AcceptSetup.Routes)
+type AcceptRoute struct {
+	Handler func(*C)
+	Delim   byte
+	// Auth and Handshake are used instead of the AcceptSetup's, and are the same apart from that
+	Auth      func(remote net.Addr, msg string) (string, error)
+	Handshake *Handshake
+}
+
+// Acceptor accepts connections from a listener and sets each one up, only returning it once it is ready to use. Remotes are set up concurrently, so a slow one does not hold up the others
+type Acceptor struct {
+	listener net.Listener
+	handler  func(*C)
+	delim    byte
+	setup    AcceptSetup
+	ready    chan *Conn
+	stopped  chan struct{}
+	err      error
+}
+
+// NewAcceptor starts accepting connections from l, which will be given handler and delim once they are set up. Call Accept to get them
+func NewAcceptor(l net.Listener, handler func(*C), delim byte, setup AcceptSetup) *Acceptor {
+	a := &Acceptor{
+		listener: l,
+		handler:  handler,
+		delim:    delim,
+		setup:    setup,
+		ready:    make(chan *Conn),
+		stopped:  make(chan struct{}),
+	}
+	go a.acceptLoop()
+	return a
+}
+
+// Accept waits for the next connection to finish being set up, and returns it already running. Remotes which fail any step are closed and never returned.
+// It returns the listener's error once the listener fails or is closed
+func (a *Acceptor) Accept() (*Conn, error) {
+	select {
+	case c := <-a.ready:
+		c.start()
+		return c, nil
+	case <-a.stopped:
+		return nil, a.err
+	}
+}
+
+// Close closes the listener. Connections which are still being set up are closed rather than returned
+func (a *Acceptor) Close() error {
+	return a.listener.Close()
+}
+
+// Addr returns the address the listener is listening on
+func (a *Acceptor) Addr() net.Addr {
+	return a.listener.Addr()
+}
+
+func (a *Acceptor) acceptLoop() {
+	for {
+		c, err := a.listener.Accept()
+		if err != nil {
+			a.err = err
+			close(a.stopped)
+			return
+		}
+		go a.handle(c)
+	}
+}
+
+// handle sets up the connection, and waits for it to be taken by Accept
+func (a *Acceptor) handle(c net.Conn) {
+	conn, err := a.establish(c)
+	if err != nil {
+		if a.setup.OnReject != nil {
+			a.setup.OnReject(c, err)
+		}
+		c.Close()
+		return
+	}
+	select {
+	case a.ready <- conn:
+	case <-a.stopped:
+		c.Close()
+	}
+}
+
+// establish performs every step of the setup over c, and creates a Conn without starting it
+func (a *Acceptor) establish(c net.Conn) (*Conn, error) {
+	s := a.setup
+	var deadline time.Time
+	if s.Timeout != 0 {
+		deadline = time.Now().Add(s.Timeout)
+		c.SetDeadline(deadline)
+		defer c.SetDeadline(time.Time{})
+	}
+	var remote net.Addr
+	if s.ProxyHeader {
+		line, err := readRawMsg(c, '\n', maxProxyHeaderSize)
+		if err != nil {
+			return nil, err
+		}
+		if remote, err = parseProxyHeader(strings.TrimSuffix(line, "\r")); err != nil {
+			return nil, err
+		}
+	}
+	if s.TLS != nil {
+		c = tls.Server(c, s.TLS)
+	}
+	handler, delim := a.handler, a.delim
+	if tc, ok := c.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			return nil, err
+		}
+		if route, ok := s.Routes[tc.ConnectionState().ServerName]; ok {
+			handler, delim = route.Handler, route.Delim
+			s.Auth, s.Handshake = route.Auth, route.Handshake
+		}
+	}
+	identity := ""
+	if s.Auth != nil {
+		msg, err := readRawMsg(c, delim, maxHandshakeSize)
+		if err != nil {
+			return nil, err
+		}
+		from := remote
+		if from == nil {
+			from = c.RemoteAddr()
+		}
+		if identity, err = s.Auth(from, msg); err != nil {
+			return nil, err
+		}
+	}
+	var conn *Conn
+	if s.Handshake != nil {
+		h := *s.Handshake
+		if left := time.Until(deadline); !deadline.IsZero() && (h.Timeout == 0 || left < h.Timeout) {
+			// The handshake sets its own deadline, which must not go past the one for the whole setup
+			h.Timeout = left
+		}
+		var err error
+		if conn, err = newConnHandshake(c, handler, delim, h); err != nil {
+			return nil, err
+		}
+	} else {
+		conn = newConn(c, handler, delim)
+	}
+	conn.sock.Store(&socket{Conn: conn.socket().Conn, proxyAddr: remote})
+	conn.SetIdentity(identity)
+	return conn, nil
+}
+
+// parseProxyHeader reads the source address out of a PROXY protocol v1 header line, such as "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443".
+// It returns nil for "PROXY UNKNOWN", which means the real address should be used
+func parseProxyHeader(line string) (net.Addr, error) {
+	parts := strings.Split(line, " ")
+	if len(parts) < 2 || parts[0] != "PROXY" {
+		return nil, errors.New("remote did not send a PROXY header")
+	}
+	if parts[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY header %q", line)
+	}
+	ip := net.ParseIP(parts[2])
+	port, err := strconv.Atoi(parts[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("malformed PROXY header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
@@ -0,0 +1,234 @@
+package bufconn_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// acceptor creates an Acceptor on a local TCP port, closed when the test ends. Received messages are sent on the returned channel
+func acceptor(t *testing.T, setup bufconn.AcceptSetup) (*bufconn.Acceptor, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan string, 10)
+	a := bufconn.NewAcceptor(l, func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		got <- msg
+	}, '\n', setup)
+	t.Cleanup(func() { a.Close() })
+	return a, got
+}
+
+// dialAcceptor connects to the acceptor and writes data, returning the raw connection
+func dialAcceptor(t *testing.T, a *bufconn.Acceptor, data string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", a.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if _, err := c.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// acceptNow calls Accept, failing the test if it takes more than a second
+func acceptNow(t *testing.T, a *bufconn.Acceptor) *bufconn.Conn {
+	t.Helper()
+	type result struct {
+		c   *bufconn.Conn
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := a.Accept()
+		done <- result{c, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		t.Cleanup(r.c.Stop)
+		return r.c
+	case <-time.After(time.Second):
+		t.Fatal("expected a connection to be accepted")
+		return nil
+	}
+}
+
+func TestAcceptorReadsProxyHeader(t *testing.T) {
+	a, got := acceptor(t, bufconn.AcceptSetup{ProxyHeader: true})
+	dialAcceptor(t, a, "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nhello\n")
+	c := acceptNow(t, a)
+	if addr := c.RemoteAddr().String(); addr != "192.168.0.1:56324" {
+		t.Fatalf("expected the address from the header, got %s", addr)
+	}
+	expectLines(t, got, "hello")
+}
+
+func TestAcceptorProxyHeaderUnknown(t *testing.T) {
+	a, _ := acceptor(t, bufconn.AcceptSetup{ProxyHeader: true})
+	raw := dialAcceptor(t, a, "PROXY UNKNOWN\r\n")
+	c := acceptNow(t, a)
+	if c.RemoteAddr().String() != raw.LocalAddr().String() {
+		t.Fatalf("expected the real address, got %s", c.RemoteAddr())
+	}
+}
+
+func TestAcceptorAuth(t *testing.T) {
+	rejected := make(chan error, 1)
+	a, got := acceptor(t, bufconn.AcceptSetup{
+		Auth: func(remote net.Addr, msg string) (string, error) {
+			if msg != "token" {
+				return "", errors.New("bad token")
+			}
+			return "alice", nil
+		},
+		OnReject: func(c net.Conn, err error) { rejected <- err },
+	})
+	dialAcceptor(t, a, "wrong\n")
+	select {
+	case err := <-rejected:
+		if err.Error() != "bad token" {
+			t.Fatalf("expected the auth error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the remote to be rejected")
+	}
+	dialAcceptor(t, a, "token\nhello\n")
+	c := acceptNow(t, a)
+	if c.Identity() != "alice" {
+		t.Fatalf("expected the identity from auth, got %q", c.Identity())
+	}
+	// The auth message is not passed to the handler
+	expectLines(t, got, "hello")
+}
+
+func TestAcceptorSlowRemoteDoesNotHoldUpOthers(t *testing.T) {
+	rejected := make(chan error, 1)
+	a, got := acceptor(t, bufconn.AcceptSetup{
+		Auth:     func(remote net.Addr, msg string) (string, error) { return msg, nil },
+		Timeout:  200 * time.Millisecond,
+		OnReject: func(c net.Conn, err error) { rejected <- err },
+	})
+	dialAcceptor(t, a, "slo")
+	dialAcceptor(t, a, "fast\nhello\n")
+	c := acceptNow(t, a)
+	if c.Identity() != "fast" {
+		t.Fatalf("expected the fast remote, got %q", c.Identity())
+	}
+	expectLines(t, got, "hello")
+	select {
+	case err := <-rejected:
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Fatalf("expected the slow remote to time out, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the slow remote to be rejected")
+	}
+}
+
+func TestAcceptorHandshake(t *testing.T) {
+	a, _ := acceptor(t, bufconn.AcceptSetup{Handshake: &bufconn.Handshake{Versions: []int{1, 2}}})
+	dialAcceptor(t, a, "BUFCONN v2\n")
+	c := acceptNow(t, a)
+	if c.ProtocolVersion() != 2 {
+		t.Fatalf("expected version 2, got %d", c.ProtocolVersion())
+	}
+}
+
+func TestAcceptorClose(t *testing.T) {
+	a, _ := acceptor(t, bufconn.AcceptSetup{})
+	a.Close()
+	if _, err := a.Accept(); err == nil {
+		t.Fatal("expected Accept to fail once the listener is closed")
+	}
+}
+
+// selfSignedTLS returns a server config with a self signed certificate
+func selfSignedTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		DNSNames:     []string{"a.example", "b.example", "c.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// dialTLS connects to the acceptor over TLS asking for the server name, and writes data
+func dialTLS(t *testing.T, a *bufconn.Acceptor, serverName, data string) {
+	t.Helper()
+	c, err := tls.Dial("tcp", a.Addr().String(), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if _, err := c.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcceptorRoutesByServerName(t *testing.T) {
+	routed := make(chan string, 10)
+	a, got := acceptor(t, bufconn.AcceptSetup{
+		TLS: selfSignedTLS(t),
+		Routes: map[string]bufconn.AcceptRoute{
+			"a.example": {Handler: func(c *bufconn.C) {
+				msg, _ := c.ReadMsg(0)
+				routed <- "a " + msg
+			}, Delim: '\n'},
+			"b.example": {Handler: func(c *bufconn.C) {
+				msg, _ := c.ReadMsg(0)
+				routed <- "b " + msg + " from " + c.Conn.Identity()
+			}, Delim: 0, Auth: func(remote net.Addr, msg string) (string, error) { return msg, nil }},
+		},
+	})
+	dialTLS(t, a, "a.example", "hello\n")
+	c := acceptNow(t, a)
+	if c.ServerName() != "a.example" {
+		t.Fatalf("expected the server name a.example, got %q", c.ServerName())
+	}
+	expectLines(t, routed, "a hello")
+	dialTLS(t, a, "b.example", "bob\x00hi\x00")
+	acceptNow(t, a)
+	expectLines(t, routed, "b hi from bob")
+	// Server names without a route use the acceptor's own handler
+	dialTLS(t, a, "c.example", "default\n")
+	acceptNow(t, a)
+	expectLines(t, got, "default")
+}
+
+func TestServerNameWithoutTLS(t *testing.T) {
+	a, _ := acceptor(t, bufconn.AcceptSetup{})
+	dialAcceptor(t, a, "")
+	if c := acceptNow(t, a); c.ServerName() != "" {
+		t.Fatalf("expected no server name, got %q", c.ServerName())
+	}
+}
@@ -0,0 +1,133 @@
+package bufconn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConnInfo is a summary of one managed connection, as listed by the admin interface
+type ConnInfo struct {
+	ID         uint64     `json:"id"`
+	Remote     string     `json:"remote"`
+	Identity   string     `json:"identity,omitempty"`
+	Groups     []string   `json:"groups,omitempty"`
+	Buffered   int        `json:"buffered"`
+	Prefetch   int        `json:"prefetch,omitempty"`
+	QueuedOps  int        `json:"queued_ops"`
+	Debug      bool       `json:"debug"`
+	Throughput Throughput `json:"throughput"`
+}
+
+// List returns a summary of every managed connection, in order of ID
+func (m *Manager) List() []ConnInfo {
+	conns := m.Conns()
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, c := range conns {
+		infos = append(infos, ConnInfo{
+			ID:         c.ID(),
+			Remote:     c.RemoteAddr().String(),
+			Identity:   c.Identity(),
+			Groups:     m.GroupsOf(c),
+			Buffered:   c.Buffered(),
+			Prefetch:   c.Prefetch(),
+			QueuedOps:  len(c.opSignal),
+			Debug:      c.debugLogger() != nil,
+			Throughput: c.Throughput(),
+		})
+	}
+	sort.Slice(infos, func(a, b int) bool { return infos[a].ID < infos[b].ID })
+	return infos
+}
+
+// SetDebugLog makes the connection log every message it sends and receives to l, after redaction (see SetRedactor). If l is nil, logging is turned off. It is safe to call at any time from any goroutine
+func (c *Conn) SetDebugLog(l *log.Logger) {
+	c.debugLog.Store(l)
+}
+
+// debugLogger returns the debug logger, or nil if debug logging is off
+func (c *Conn) debugLogger() *log.Logger {
+	l, _ := c.debugLog.Load().(*log.Logger)
+	return l
+}
+
+// ServeAdmin accepts admin connections from l until it is closed, and answers commands about the manager's connections. Each command and reply is one line, and replies are JSON.
+// The commands are "list", "stats", "close <id>" and "debug <id> on|off", where debug logging goes to debugLog (or the standard logger if nil).
+// Anyone who can connect to l can close connections and read their traffic, so it should only listen somewhere private, such as on localhost
+func (m *Manager) ServeAdmin(l net.Listener, debugLog *log.Logger) error {
+	if debugLog == nil {
+		debugLog = log.Default()
+	}
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		NewConn(c, func(c *C) {
+			for _, cmd := range c.readBurst() {
+				reply, err := m.adminCommand(strings.TrimSpace(cmd), debugLog)
+				if err != nil {
+					reply = map[string]string{"error": err.Error()}
+				}
+				bs, _ := json.Marshal(reply)
+				c.WriteMsg(string(bs))
+			}
+		}, '\n')
+	}
+}
+
+// adminCommand runs one admin command and returns the reply
+func (m *Manager) adminCommand(cmd string, debugLog *log.Logger) (any, error) {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return nil, errors.New("empty command")
+	}
+	connArg := func() (*Conn, error) {
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s needs a connection ID", fields[0])
+		}
+		id, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		c, ok := m.Get(id)
+		if !ok {
+			return nil, fmt.Errorf("no connection %d", id)
+		}
+		return c, nil
+	}
+	switch fields[0] {
+	case "list":
+		return m.List(), nil
+	case "stats":
+		return m.Stats(), nil
+	case "close":
+		c, err := connArg()
+		if err != nil {
+			return nil, err
+		}
+		c.Stop()
+		return map[string]bool{"ok": true}, nil
+	case "debug":
+		c, err := connArg()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 3 || (fields[2] != "on" && fields[2] != "off") {
+			return nil, errors.New("debug needs on or off")
+		}
+		if fields[2] == "on" {
+			c.SetDebugLog(debugLog)
+		} else {
+			c.SetDebugLog(nil)
+		}
+		return map[string]bool{"ok": true}, nil
+	default:
+		return nil, fmt.Errorf("unknown command %q", fields[0])
+	}
+}
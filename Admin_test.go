@@ -0,0 +1,114 @@
+package bufconn_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// syncBuffer is a bytes.Buffer which is safe for concurrent use
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.String()
+}
+
+// adminClient starts serving the manager's admin interface on a local port, and returns a function which sends a command and returns the reply
+func adminClient(t *testing.T, m *bufconn.Manager, debugLog *log.Logger) func(cmd string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go m.ServeAdmin(l, debugLog)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	r := bufio.NewReader(c)
+	return func(cmd string) string {
+		fmt.Fprintln(c, cmd)
+		reply, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(reply)
+	}
+}
+
+func TestAdminListAndClose(t *testing.T) {
+	m := bufconn.NewManager()
+	c1, _ := managedConn(t, m)
+	c2, _ := managedConn(t, m)
+	c1.SetIdentity("alice")
+	m.Join(c1, "admins")
+	send := adminClient(t, m, nil)
+	var infos []bufconn.ConnInfo
+	if err := json.Unmarshal([]byte(send("list")), &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].ID != c1.ID() || infos[1].ID != c2.ID() {
+		t.Fatalf("expected both conns in order of ID, got %+v", infos)
+	}
+	if infos[0].Identity != "alice" || len(infos[0].Groups) != 1 || infos[0].Groups[0] != "admins" {
+		t.Fatalf("unexpected info %+v", infos[0])
+	}
+	if reply := send(fmt.Sprint("close ", c2.ID())); reply != `{"ok":true}` {
+		t.Fatalf("unexpected reply %s", reply)
+	}
+	waitFor(t, "the conn to stop", c2.IsStopped)
+	var stats bufconn.ManagerStats
+	waitFor(t, "the conn to be forgotten", func() bool {
+		json.Unmarshal([]byte(send("stats")), &stats)
+		return stats.Conns == 1
+	})
+}
+
+func TestAdminDebugLogging(t *testing.T) {
+	m := bufconn.NewManager()
+	c, remote := managedConn(t, m)
+	buf := &syncBuffer{}
+	send := adminClient(t, m, log.New(buf, "", 0))
+	if reply := send(fmt.Sprint("debug ", c.ID(), " on")); reply != `{"ok":true}` {
+		t.Fatalf("unexpected reply %s", reply)
+	}
+	remote.Write([]byte("hello\n"))
+	waitFor(t, "the message to be logged", func() bool { return strings.Contains(buf.String(), `"hello"`) })
+	send(fmt.Sprint("debug ", c.ID(), " off"))
+	remote.Write([]byte("quiet\n"))
+	waitFor(t, "the message to be read", func() bool { return c.Buffered() == 0 })
+	if strings.Contains(buf.String(), "quiet") {
+		t.Fatal("expected nothing to be logged once debug is off")
+	}
+}
+
+func TestAdminErrors(t *testing.T) {
+	m := bufconn.NewManager()
+	send := adminClient(t, m, nil)
+	for _, cmd := range []string{"bogus", "close", "close 999", "debug 1 maybe"} {
+		var reply map[string]string
+		if err := json.Unmarshal([]byte(send(cmd)), &reply); err != nil || reply["error"] == "" {
+			t.Fatalf("%s: expected an error reply, got %v", cmd, reply)
+		}
+	}
+}
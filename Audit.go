@@ -0,0 +1,137 @@
+package bufconn
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction is which way a message travelled
+type Direction string
+
+const (
+	// Inbound messages were received from the remote
+	Inbound Direction = "in"
+	// Outbound messages were sent to the remote
+	Outbound Direction = "out"
+)
+
+// Outcomes of audited messages
+const (
+	AuditOK     = "ok"
+	AuditDenied = "denied"
+	AuditError  = "error"
+)
+
+// AuditRecord describes one message sent or received on a connection
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	ConnID    uint64    `json:"conn_id"`
+	Identity  string    `json:"identity,omitempty"`
+	Direction Direction `json:"direction"`
+	// Command is the command of the message, or empty for raw writes
+	Command string `json:"command,omitempty"`
+	// Size is the size of the message in bytes, not including the delimiter
+	Size    int    `json:"size"`
+	Outcome string `json:"outcome"`
+	// Error is the error text when Outcome is AuditError
+	Error string `json:"error,omitempty"`
+	// Trace is the trace ID of the message, for messages handled or written by a Registry
+	Trace string `json:"trace,omitempty"`
+}
+
+// Auditor receives a record of every message read or written on a connection. It is called from the connection's processing loop, so it should return quickly.
+// One Auditor can be shared between many connections, so implementations must be safe for concurrent use
+type Auditor interface {
+	Audit(AuditRecord)
+}
+
+// SetAuditor sets where records of messages on this connection are sent. command extracts the command from a message (after it has been redacted, see SetRedactor), and if nil, the first word of the message is used. If a is nil, nothing is audited
+func (c *Conn) SetAuditor(a Auditor, command func(msg string) string) {
+	if command == nil {
+		command = firstWord
+	}
+	c.updateSettings(func(s *settings) {
+		s.auditor, s.auditCommand = a, command
+	})
+}
+
+// firstWord returns the first whitespace seperated word of a message
+func firstWord(msg string) string {
+	if fields := strings.Fields(msg); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// audit sends a record to the auditor, if there is one, and logs the message if debug logging is on. msg can be nil for raw reads and writes
+func (c *Conn) audit(dir Direction, msg []byte, size int, outcome string, err error) {
+	if l := c.debugLogger(); l != nil {
+		prefix := fmt.Sprintf("conn %d %s", c.id, dir)
+		if c.trace != "" {
+			prefix += " trace " + c.trace
+		}
+		switch {
+		case err != nil:
+			l.Printf("%s error: %v", prefix, err)
+		case msg != nil:
+			l.Printf("%s %s: %q", prefix, outcome, c.redact(string(msg)))
+		default:
+			l.Printf("%s %s: %d raw bytes", prefix, outcome, size)
+		}
+	}
+	s := c.settings()
+	if s.auditor == nil {
+		return
+	}
+	r := AuditRecord{
+		Time:      time.Now(),
+		ConnID:    c.id,
+		Identity:  c.Identity(),
+		Direction: dir,
+		Size:      size,
+		Outcome:   outcome,
+		Trace:     c.trace,
+	}
+	if msg != nil {
+		r.Command = s.auditCommand(c.redact(string(msg)))
+	}
+	if err != nil {
+		r.Outcome = AuditError
+		r.Error = err.Error()
+	}
+	s.auditor.Audit(r)
+}
+
+// FileAuditor is an Auditor which appends each record to a file as a line of JSON
+type FileAuditor struct {
+	lock sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewFileAuditor opens (or creates) the file at path for appending audit records to
+func NewFileAuditor(path string) (*FileAuditor, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditor{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Audit implements Auditor. Errors writing to the file are ignored
+func (a *FileAuditor) Audit(r AuditRecord) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.enc.Encode(r)
+}
+
+// Close closes the file
+func (a *FileAuditor) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.file.Close()
+}
@@ -0,0 +1,85 @@
+package bufconn_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestAuditRecordsBothDirections(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	log := &auditLog{}
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		if msg, ok := c.TryReadMsg(); ok {
+			c.WriteMsg("re " + msg)
+		}
+	}, '\n')
+	defer c.Stop()
+	c.SetAuditor(log, nil)
+	got := lines(b)
+	b.Write([]byte("get thing\n"))
+	expectLines(t, got, "re get thing")
+	waitFor(t, "both records", func() bool {
+		log.lock.Lock()
+		defer log.lock.Unlock()
+		return len(log.records) == 2
+	})
+	in, out := log.records[0], log.records[1]
+	if in.Direction != bufconn.Inbound || in.Command != "get" || in.Size != 9 || in.Outcome != bufconn.AuditOK {
+		t.Fatalf("unexpected inbound record %+v", in)
+	}
+	if out.Direction != bufconn.Outbound || out.Command != "re" || out.Size != 12 || out.ConnID != in.ConnID {
+		t.Fatalf("unexpected outbound record %+v", out)
+	}
+}
+
+func TestAuditCustomCommand(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	log := &auditLog{}
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	c.SetAuditor(log, func(msg string) string { return strings.SplitN(msg, ":", 2)[0] })
+	b.Write([]byte("ping:now\n"))
+	waitFor(t, "a record", func() bool { return len(log.outcomes()) == 1 })
+	if got := log.outcomes()[0]; got != "ping:ok" {
+		t.Fatalf("expected ping:ok, got %q", got)
+	}
+}
+
+func TestFileAuditorWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	fa, err := bufconn.NewFileAuditor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fa.Audit(bufconn.AuditRecord{ConnID: 1, Direction: bufconn.Inbound, Command: "a", Outcome: bufconn.AuditOK})
+	fa.Audit(bufconn.AuditRecord{ConnID: 1, Direction: bufconn.Outbound, Command: "b", Outcome: bufconn.AuditError, Error: "boom"})
+	if err := fa.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []bufconn.AuditRecord
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var r bufconn.AuditRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 || records[0].Command != "a" || records[1].Error != "boom" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+}
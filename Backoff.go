@@ -0,0 +1,54 @@
+package bufconn
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff decides how long to wait before each retry of something which keeps failing
+type Backoff interface {
+	// Delay returns how long to wait before the given attempt, where the first retry is attempt 1
+	Delay(attempt int) time.Duration
+}
+
+// BackoffFunc turns a function into a Backoff
+type BackoffFunc func(attempt int) time.Duration
+
+// Delay implements Backoff
+func (f BackoffFunc) Delay(attempt int) time.Duration {
+	return f(attempt)
+}
+
+// ConstantBackoff waits the same time before every attempt
+func ConstantBackoff(d time.Duration) Backoff {
+	return BackoffFunc(func(int) time.Duration {
+		return d
+	})
+}
+
+// ExponentialBackoff waits base before the first attempt, doubling each time after that up to max. If max is zero, there is no limit
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return BackoffFunc(func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < math.MaxInt64/2; i++ {
+			if max > 0 && d >= max {
+				break
+			}
+			d *= 2
+		}
+		if max > 0 && d > max {
+			return max
+		}
+		return d
+	})
+}
+
+// JitteredBackoff randomises the delays of b by up to fraction either way, so that many clients which failed at the same time do not all retry at the same time.
+// For example, a fraction of 0.2 turns a one second delay into anything from 0.8 to 1.2 seconds
+func JitteredBackoff(b Backoff, fraction float64) Backoff {
+	return BackoffFunc(func(attempt int) time.Duration {
+		d := float64(b.Delay(attempt))
+		return time.Duration(d + d*fraction*(2*rand.Float64()-1))
+	})
+}
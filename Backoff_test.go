@@ -0,0 +1,61 @@
+package bufconn_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	b := bufconn.ConstantBackoff(time.Second)
+	for attempt := 1; attempt < 5; attempt++ {
+		if d := b.Delay(attempt); d != time.Second {
+			t.Fatalf("attempt %d: expected 1s, got %v", attempt, d)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := bufconn.ExponentialBackoff(100*time.Millisecond, time.Second)
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if d := b.Delay(i + 1); d != w*time.Millisecond {
+			t.Fatalf("attempt %d: expected %v, got %v", i+1, w*time.Millisecond, d)
+		}
+	}
+}
+
+func TestExponentialBackoffWithoutMaxDoesNotOverflow(t *testing.T) {
+	b := bufconn.ExponentialBackoff(time.Second, 0)
+	prev := time.Duration(0)
+	for attempt := 1; attempt < 100; attempt++ {
+		d := b.Delay(attempt)
+		if d < prev || d <= 0 {
+			t.Fatalf("attempt %d: expected delays to keep growing, got %v after %v", attempt, d, prev)
+		}
+		prev = d
+	}
+}
+
+func TestJitteredBackoffStaysInRange(t *testing.T) {
+	b := bufconn.JitteredBackoff(bufconn.ConstantBackoff(time.Second), 0.2)
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		d := b.Delay(1)
+		if d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("expected a delay within 20%% of 1s, got %v", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("expected the delays to vary")
+	}
+}
+
+func TestBackoffFunc(t *testing.T) {
+	b := bufconn.BackoffFunc(func(attempt int) time.Duration { return time.Duration(attempt) * time.Millisecond })
+	if d := b.Delay(3); d != 3*time.Millisecond {
+		t.Fatalf("expected 3ms, got %v", d)
+	}
+}
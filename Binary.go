@@ -0,0 +1,31 @@
+package bufconn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ReadBinary reads a fixed size value from the buffer using encoding/binary, waiting for enough bytes to arrive. v must be a pointer to a fixed size value, such as a struct of sized integers.
+// If the timeout is reached, this function will return an error. If the timeout is zero, then no timeout will be used
+func (c *C) ReadBinary(v any, order binary.ByteOrder, timeout time.Duration) error {
+	size := binary.Size(v)
+	if size < 0 {
+		return errors.New("value does not have a fixed size")
+	}
+	bs, err := c.Read(size, timeout)
+	if err != nil {
+		return err
+	}
+	return binary.Read(bytes.NewReader(bs), order, v)
+}
+
+// WriteBinary writes a fixed size value to the remote using encoding/binary. No delimiter is added
+func (c *C) WriteBinary(v any, order binary.ByteOrder) (int, error) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, order, v); err != nil {
+		return 0, err
+	}
+	return c.Write(buf.Bytes())
+}
@@ -0,0 +1,74 @@
+package bufconn_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+type header struct {
+	Version uint8
+	Flags   uint16
+	Length  uint32
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	a, b := net.Pipe()
+	ca := bufconn.NewConn(a, nil, '\n')
+	defer ca.Stop()
+	cb := bufconn.NewConn(b, nil, '\n')
+	defer cb.Stop()
+	want := header{1, 0x0203, 0x04050607}
+	ca.QueueOperation(func(c *bufconn.C) {
+		c.WriteBinary(want, binary.BigEndian)
+	})
+	got := make(chan header, 1)
+	errs := make(chan error, 1)
+	cb.QueueOperation(func(c *bufconn.C) {
+		var h header
+		errs <- c.ReadBinary(&h, binary.BigEndian, time.Second)
+		got <- h
+	})
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if h := <-got; h != want {
+		t.Fatalf("expected %+v, got %+v", want, h)
+	}
+}
+
+func TestBinaryByteOrder(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	c.QueueOperation(func(c *bufconn.C) {
+		c.WriteBinary(header{1, 0x0203, 0x04050607}, binary.LittleEndian)
+	})
+	buf := make([]byte, 7)
+	if _, err := io.ReadFull(b, buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1, 3, 2, 7, 6, 5, 4}; string(buf) != string(want) {
+		t.Fatalf("expected % x, got % x", want, buf)
+	}
+}
+
+func TestBinaryNeedsFixedSize(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	errs := make(chan error, 1)
+	c.QueueOperation(func(c *bufconn.C) {
+		var s []int
+		errs <- c.ReadBinary(&s, binary.BigEndian, time.Second)
+	})
+	if err := <-errs; err == nil {
+		t.Fatal("expected an error for a value without a fixed size")
+	}
+}
@@ -0,0 +1,155 @@
+package bufconn
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by writes and sends while a connection's circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerState is the state of a connection's circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets every write through. This is the state of a connection without a circuit breaker
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every write straight away, without touching the socket
+	BreakerOpen
+	// BreakerHalfOpen lets a single write through to probe whether the connection has recovered
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker counts consecutive write failures and decides whether writes may go ahead. It is safe for concurrent use
+type breaker struct {
+	lock      sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     BreakerState
+	openedAt  time.Time
+	probing   bool
+	onChange  func(from, to BreakerState)
+}
+
+// SetCircuitBreaker makes the connection stop writing after failures consecutive writes fail (including timeouts). While open, writes and SendMsg fail straight away with ErrCircuitOpen.
+// Once cooldown has passed, a single write is let through as a probe: if it succeeds the breaker closes, and otherwise it opens again for another cooldown.
+// onChange, which may be nil, is called whenever the state changes. If failures is zero or less, the breaker is removed
+func (c *Conn) SetCircuitBreaker(failures int, cooldown time.Duration, onChange func(from, to BreakerState)) {
+	var b *breaker
+	if failures > 0 {
+		b = &breaker{threshold: failures, cooldown: cooldown, onChange: onChange}
+	}
+	c.updateSettings(func(s *settings) {
+		s.breaker = b
+	})
+}
+
+// BreakerState returns the state of the connection's circuit breaker
+func (c *Conn) BreakerState() BreakerState {
+	b := c.settings().breaker
+	if b == nil {
+		return BreakerClosed
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.current()
+}
+
+// current returns the state, counting an open breaker whose cooldown has passed as half open. The lock must be held
+func (b *breaker) current() BreakerState {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// set changes the state, and returns a function which reports the change. The lock must be held, and the returned function called once it is released
+func (b *breaker) set(to BreakerState) func() {
+	from := b.state
+	b.state = to
+	if from == to || b.onChange == nil {
+		return func() {}
+	}
+	return func() { b.onChange(from, to) }
+}
+
+// ready checks whether a write could go ahead, without claiming the probe of a half open breaker
+func (b *breaker) ready() error {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if s := b.current(); s == BreakerOpen || (s == BreakerHalfOpen && b.probing) {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// allow checks whether a write may go ahead now. If the breaker is half open, the write becomes the probe
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	switch b.current() {
+	case BreakerOpen:
+		b.lock.Unlock()
+		return ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			b.lock.Unlock()
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		report := b.set(BreakerHalfOpen)
+		b.lock.Unlock()
+		report()
+		return nil
+	}
+	b.lock.Unlock()
+	return nil
+}
+
+// record updates the breaker with the result of a write which was allowed
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	var report func()
+	if err == nil {
+		b.failures = 0
+		b.probing = false
+		report = b.set(BreakerClosed)
+	} else {
+		b.failures++
+		if b.probing || b.failures >= b.threshold {
+			b.probing = false
+			b.openedAt = time.Now()
+			report = b.set(BreakerOpen)
+		} else {
+			report = func() {}
+		}
+	}
+	b.lock.Unlock()
+	report()
+}
+
+// available checks if the connection is worth sending to: it has not stopped and its circuit breaker is not open
+func (c *Conn) available() bool {
+	return !c.IsStopped() && c.settings().breaker.ready() == nil
+}
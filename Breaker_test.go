@@ -0,0 +1,114 @@
+package bufconn_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// flakyConn is a net.Conn whose writes fail while failing is set
+type flakyConn struct {
+	net.Conn
+	failing int32
+}
+
+func (f *flakyConn) Write(bs []byte) (int, error) {
+	if atomic.LoadInt32(&f.failing) != 0 {
+		return 0, errors.New("write failed")
+	}
+	return f.Conn.Write(bs)
+}
+
+// writeNow writes the message from an operation and returns the error
+func writeNow(c *bufconn.Conn, msg string) error {
+	errs := make(chan error, 1)
+	c.QueueOperation(func(c *bufconn.C) {
+		_, err := c.WriteMsg(msg)
+		errs <- err
+	})
+	return <-errs
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	flaky := &flakyConn{Conn: a, failing: 1}
+	var lock sync.Mutex
+	var changes []string
+	c := bufconn.NewConn(flaky, nil, '\n')
+	defer c.Stop()
+	c.SetCircuitBreaker(2, 50*time.Millisecond, func(from, to bufconn.BreakerState) {
+		lock.Lock()
+		defer lock.Unlock()
+		changes = append(changes, fmt.Sprint(from, "->", to))
+	})
+	got := lines(b)
+	for i := 0; i < 2; i++ {
+		if err := writeNow(c, "lost"); err == nil || errors.Is(err, bufconn.ErrCircuitOpen) {
+			t.Fatalf("expected the write itself to fail, got %v", err)
+		}
+	}
+	if s := c.BreakerState(); s != bufconn.BreakerOpen {
+		t.Fatalf("expected the breaker to be open, got %v", s)
+	}
+	if err := writeNow(c, "blocked"); !errors.Is(err, bufconn.ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if err := c.SendMsg("blocked", bufconn.PriorityNormal); !errors.Is(err, bufconn.ErrCircuitOpen) {
+		t.Fatalf("expected SendMsg to fail with ErrCircuitOpen, got %v", err)
+	}
+	atomic.StoreInt32(&flaky.failing, 0)
+	waitFor(t, "the cooldown", func() bool { return c.BreakerState() == bufconn.BreakerHalfOpen })
+	if err := writeNow(c, "probe"); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, got, "probe")
+	if s := c.BreakerState(); s != bufconn.BreakerClosed {
+		t.Fatalf("expected the breaker to be closed, got %v", s)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if got := fmt.Sprint(changes); got != "[closed->open open->half-open half-open->closed]" {
+		t.Fatalf("unexpected state changes %s", got)
+	}
+}
+
+func TestCircuitBreakerFailedProbeReopens(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	flaky := &flakyConn{Conn: a, failing: 1}
+	c := bufconn.NewConn(flaky, nil, '\n')
+	defer c.Stop()
+	c.SetCircuitBreaker(1, 20*time.Millisecond, nil)
+	writeNow(c, "lost")
+	waitFor(t, "the cooldown", func() bool { return c.BreakerState() == bufconn.BreakerHalfOpen })
+	writeNow(c, "failed probe")
+	if s := c.BreakerState(); s != bufconn.BreakerOpen {
+		t.Fatalf("expected a failed probe to open the breaker again, got %v", s)
+	}
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	flaky := &flakyConn{Conn: a}
+	c := bufconn.NewConn(flaky, nil, '\n')
+	defer c.Stop()
+	c.SetCircuitBreaker(2, time.Minute, nil)
+	got := lines(b)
+	for i := 0; i < 3; i++ {
+		atomic.StoreInt32(&flaky.failing, 1)
+		writeNow(c, "lost")
+		atomic.StoreInt32(&flaky.failing, 0)
+		if err := writeNow(c, "ok"); err != nil {
+			t.Fatalf("expected failures which are not consecutive to leave the breaker closed, got %v", err)
+		}
+		expectLines(t, got, "ok")
+	}
+}
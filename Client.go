@@ -0,0 +1,274 @@
+package bufconn
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// resolvedAddr is a cached DNS lookup for one of the addresses of a Client
+type resolvedAddr struct {
+	addrs []string
+	at    time.Time
+}
+
+// Client is a connection to one of an ordered list of addresses, which reconnects automatically when the connection is lost.
+// The first address is the primary, and the rest are backups which are tried in order when the primary can not be reached
+type Client struct {
+	network      string
+	addrs        []string
+	delim        byte
+	lock         sync.Mutex
+	handler      func(*C)
+	conn         *Conn
+	current      int
+	backoff      Backoff
+	failBack     time.Duration
+	onTransition func(from, to string)
+	resolveTTL   time.Duration
+	resolved     map[string]resolvedAddr
+	wake         chan struct{}
+	stopOnce     sync.Once
+	stopChan     chan struct{}
+}
+
+// DialClient connects to the first reachable address in addrs, trying them in order. It only returns an error if none of the addresses could be reached
+func DialClient(network string, addrs []string, handler func(*C), delim byte) (*Client, error) {
+	cl := &Client{
+		network:  network,
+		addrs:    addrs,
+		delim:    delim,
+		handler:  handler,
+		backoff:  ConstantBackoff(time.Second),
+		resolved: make(map[string]resolvedAddr),
+		wake:     make(chan struct{}, 1),
+		stopChan: make(chan struct{}),
+	}
+	conn, i, err := cl.dialFrom(0)
+	if err != nil {
+		return nil, err
+	}
+	cl.conn, cl.current = conn, i
+	go cl.supervise()
+	return cl, nil
+}
+
+// dialFrom tries each address in order, starting at index start, and returns the first connection that succeeds
+func (cl *Client) dialFrom(start int) (*Conn, int, error) {
+	var lastErr error = ErrNoBackends
+	for i := start; i < len(cl.addrs); i++ {
+		conn, err := cl.dialAddr(i)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return conn, i, nil
+	}
+	return nil, 0, lastErr
+}
+
+// dialAddr connects to the address at index i, and starts a Conn on it
+func (cl *Client) dialAddr(i int) (*Conn, error) {
+	resolved, err := cl.resolve(cl.addrs[i])
+	if err != nil {
+		return nil, err
+	}
+	c, err := dialRace(cl.network, resolved)
+	if err != nil {
+		return nil, err
+	}
+	conn := newConn(c, cl.messageHandler(), cl.delim)
+	conn.OnRemoteGoingAway(nil)
+	conn.start()
+	return conn, nil
+}
+
+// resolve looks up the IPs for an address, reusing the previous lookup if it is younger than the resolve TTL
+func (cl *Client) resolve(addr string) ([]string, error) {
+	cl.lock.Lock()
+	cached, ok := cl.resolved[addr]
+	ttl := cl.resolveTTL
+	cl.lock.Unlock()
+	if ok && ttl > 0 && time.Since(cached.at) < ttl {
+		return cached.addrs, nil
+	}
+	addrs, err := resolveAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	cl.lock.Lock()
+	cl.resolved[addr] = resolvedAddr{addrs, time.Now()}
+	cl.lock.Unlock()
+	return addrs, nil
+}
+
+// supervise waits for the current connection to drop and replaces it, and periodically tries to fail back to the primary
+func (cl *Client) supervise() {
+	for {
+		cl.lock.Lock()
+		conn, current, failBack := cl.conn, cl.current, cl.failBack
+		cl.lock.Unlock()
+		var failBackCheck <-chan time.Time
+		if failBack > 0 && current != 0 {
+			failBackCheck = time.After(failBack)
+		}
+		select {
+		case <-cl.stopChan:
+			return
+		case <-conn.Done():
+			cl.reconnect(conn.Err())
+		case <-cl.wake:
+		case <-failBackCheck:
+			// Only the primary is tried, as the current backup is already working
+			primary, err := cl.dialAddr(0)
+			if err != nil {
+				continue
+			}
+			if !cl.swap(primary, 0) {
+				return
+			}
+			// Let the old connection finish whatever it was doing
+			conn.Stop()
+		}
+	}
+}
+
+// reconnect keeps trying every address, from the primary down, until one succeeds or the client is stopped.
+// If the last connection stopped because the remote was going away, it waits before the first attempt rather than reconnecting to a server which is still shutting down
+func (cl *Client) reconnect(lost error) {
+	var goAway *GoAwayError
+	if errors.As(lost, &goAway) {
+		cl.lock.Lock()
+		delay := cl.backoff.Delay(1)
+		cl.lock.Unlock()
+		select {
+		case <-cl.stopChan:
+			return
+		case <-time.After(delay):
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		conn, i, err := cl.dialFrom(0)
+		if err == nil {
+			cl.swap(conn, i)
+			return
+		}
+		cl.lock.Lock()
+		delay := cl.backoff.Delay(attempt)
+		cl.lock.Unlock()
+		select {
+		case <-cl.stopChan:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// swap makes conn the current connection. If the client has been stopped in the meantime, conn is stopped instead and false is returned
+func (cl *Client) swap(conn *Conn, i int) bool {
+	cl.lock.Lock()
+	select {
+	case <-cl.stopChan:
+		cl.lock.Unlock()
+		conn.Stop()
+		return false
+	default:
+	}
+	from := cl.addrs[cl.current]
+	cl.conn, cl.current = conn, i
+	f := cl.onTransition
+	cl.lock.Unlock()
+	if f != nil {
+		f(from, cl.addrs[i])
+	}
+	return true
+}
+
+func (cl *Client) messageHandler() func(*C) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	return cl.handler
+}
+
+// SetMessageHandler changes the message handler for the current connection and any future ones
+func (cl *Client) SetMessageHandler(f func(*C)) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	cl.handler = f
+	cl.conn.SetMessageHandler(f)
+}
+
+// SetRetryDelay sets how long to wait between attempts when none of the addresses can be reached. The default is one second.
+// This is the same as using SetBackoff with ConstantBackoff
+func (cl *Client) SetRetryDelay(d time.Duration) {
+	cl.SetBackoff(ConstantBackoff(d))
+}
+
+// SetBackoff sets how long to wait before each attempt when none of the addresses can be reached. Attempts are counted from the connection being lost
+func (cl *Client) SetBackoff(b Backoff) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	cl.backoff = b
+}
+
+// SetFailBack sets how often to try to move back to the primary while connected to a backup. If zero (the default), the client stays on the backup until it fails
+func (cl *Client) SetFailBack(interval time.Duration) {
+	cl.lock.Lock()
+	cl.failBack = interval
+	cl.lock.Unlock()
+	// The supervisor may already be waiting with the old interval
+	select {
+	case cl.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SetResolveTTL sets how long the result of a DNS lookup is reused for when dialing. If zero (the default), hostnames are resolved again on every connection attempt
+func (cl *Client) SetResolveTTL(ttl time.Duration) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	cl.resolveTTL = ttl
+}
+
+// SetTransitionHandler sets a function which is called each time the client connects to a new connection, with the address it was on and the address it is now on
+func (cl *Client) SetTransitionHandler(f func(from, to string)) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	cl.onTransition = f
+}
+
+// Conn returns the current connection. This may be stopped if the client is in the middle of reconnecting
+func (cl *Client) Conn() *Conn {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	return cl.conn
+}
+
+// Addr returns the address of the current connection
+func (cl *Client) Addr() string {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	return cl.addrs[cl.current]
+}
+
+// QueueOperation queues an operation on the current connection. If the client has been stopped, ErrStopped is returned
+func (cl *Client) QueueOperation(o func(*C)) error {
+	select {
+	case <-cl.stopChan:
+		return ErrStopped
+	default:
+	}
+	cl.Conn().QueueOperation(o)
+	return nil
+}
+
+// Stop stops the current connection and prevents any further reconnects
+func (cl *Client) Stop() {
+	cl.stopOnce.Do(func() {
+		cl.lock.Lock()
+		close(cl.stopChan)
+		conn := cl.conn
+		cl.lock.Unlock()
+		conn.Stop()
+	})
+}
@@ -0,0 +1,141 @@
+package bufconn_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// listener listens on a local port, sending every accepted connection on the returned channel
+func listener(t *testing.T) (net.Listener, <-chan net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { c.Close() })
+			accepted <- c
+		}
+	}()
+	return l, accepted
+}
+
+// unreachableAddr returns a local address which nothing is listening on
+func unreachableAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+// accept waits for the next accepted connection
+func accept(t *testing.T, accepted <-chan net.Conn) net.Conn {
+	t.Helper()
+	select {
+	case c := <-accepted:
+		return c
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a connection")
+		return nil
+	}
+}
+
+func TestClientFailsOverToBackup(t *testing.T) {
+	backup, accepted := listener(t)
+	primary := unreachableAddr(t)
+	cl, err := bufconn.DialClient("tcp", []string{primary, backup.Addr().String()}, nil, '\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Stop()
+	if cl.Addr() != backup.Addr().String() {
+		t.Fatalf("expected to be on the backup, got %s", cl.Addr())
+	}
+	remote := accept(t, accepted)
+	cl.QueueOperation(func(c *bufconn.C) { c.WriteMsg("hello") })
+	expectLines(t, lines(remote), "hello")
+}
+
+func TestClientReconnectsWhenDropped(t *testing.T) {
+	l, accepted := listener(t)
+	cl, err := bufconn.DialClient("tcp", []string{l.Addr().String()}, nil, '\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Stop()
+	cl.SetRetryDelay(10 * time.Millisecond)
+	first := cl.Conn()
+	accept(t, accepted).Close()
+	<-first.Done()
+	remote := accept(t, accepted)
+	waitFor(t, "new connection", func() bool { return cl.Conn() != first })
+	cl.QueueOperation(func(c *bufconn.C) { c.WriteMsg("again") })
+	expectLines(t, lines(remote), "again")
+}
+
+func TestClientFailsBackToPrimary(t *testing.T) {
+	backup, _ := listener(t)
+	primaryAddr := unreachableAddr(t)
+	cl, err := bufconn.DialClient("tcp", []string{primaryAddr, backup.Addr().String()}, nil, '\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Stop()
+	transitions := make(chan [2]string, 1)
+	cl.SetTransitionHandler(func(from, to string) { transitions <- [2]string{from, to} })
+	// Give the client time to settle, so the new interval has to reach a supervisor which is already waiting
+	time.Sleep(20 * time.Millisecond)
+	cl.SetFailBack(10 * time.Millisecond)
+	primary, err := net.Listen("tcp", primaryAddr)
+	if err != nil {
+		t.Skip("primary port was taken:", err)
+	}
+	defer primary.Close()
+	go func() {
+		if c, err := primary.Accept(); err == nil {
+			defer c.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+	select {
+	case tr := <-transitions:
+		if tr != [2]string{backup.Addr().String(), primaryAddr} {
+			t.Fatalf("unexpected transition %v", tr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected to fail back to the primary")
+	}
+}
+
+func TestClientReconnectsByHostname(t *testing.T) {
+	l, accepted := listener(t)
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	cl, err := bufconn.DialClient("tcp4", []string{net.JoinHostPort("localhost", port)}, nil, '\n')
+	if err != nil {
+		t.Skip("localhost does not resolve to IPv4:", err)
+	}
+	defer cl.Stop()
+	cl.SetRetryDelay(10 * time.Millisecond)
+	cl.SetResolveTTL(time.Minute)
+	first := cl.Conn()
+	accept(t, accepted).Close()
+	<-first.Done()
+	remote := accept(t, accepted)
+	waitFor(t, "new connection", func() bool { return cl.Conn() != first })
+	cl.QueueOperation(func(c *bufconn.C) { c.WriteMsg("again") })
+	expectLines(t, lines(remote), "again")
+}
@@ -0,0 +1,85 @@
+package bufconn
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// maxClockSamples is how many recent ping round trips are kept for estimating the clock offset
+const maxClockSamples = 8
+
+// clockSample is the result of one ping round trip
+type clockSample struct {
+	offset time.Duration
+	rtt    time.Duration
+}
+
+// SetTimeSync makes the connection exchange timestamped pings with the remote every interval, to estimate how far the remote's clock is from this one (see ClockOffset).
+// The remote must also have called SetTimeSync so that it answers the pings, though it may use an interval of zero to only answer
+func (c *Conn) SetTimeSync(interval time.Duration) {
+	c.answerPings()
+	if interval > 0 {
+		c.every(interval, func(c *C) {
+			c.ping()
+		})
+	}
+}
+
+// ClockOffset returns how far ahead the remote's clock is of this one's (negative if it is behind), and the round trip time of the ping it was measured with.
+// Of the last few pings, the one with the shortest round trip is used, as it is the least affected by network delay. ok is false until a ping has been answered
+func (c *Conn) ClockOffset() (offset, rtt time.Duration, ok bool) {
+	c.clockLock.Lock()
+	defer c.clockLock.Unlock()
+	if len(c.clockSamples) == 0 {
+		return 0, 0, false
+	}
+	best := c.clockSamples[0]
+	for _, s := range c.clockSamples[1:] {
+		if s.rtt < best.rtt {
+			best = s
+		}
+	}
+	return best.offset, best.rtt, true
+}
+
+// RemoteTime converts a time read from the remote's clock to this one's, using the estimated clock offset. If there is no estimate yet, t is returned unchanged
+func (c *Conn) RemoteTime(t time.Time) time.Time {
+	offset, _, _ := c.ClockOffset()
+	return t.Add(-offset)
+}
+
+// answerPings makes the connection answer ping control frames with the times they were received and answered, and record the remote's answers to its own pings
+func (c *Conn) answerPings() {
+	c.onControl("ping", func(c *C, args string) {
+		received := time.Now().UnixNano()
+		c.writeControl("pong", fmt.Sprintf("%s %d %d", args, received, time.Now().UnixNano()))
+	})
+	c.onControl("pong", func(c *C, args string) {
+		c.Conn.recordPong(args, time.Now())
+	})
+}
+
+// ping sends a ping control frame carrying the time it was sent
+func (c *C) ping() error {
+	return c.writeControl("ping", strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// recordPong works out the clock offset and round trip time from the remote's answer to a ping, in the same way as NTP
+func (c *Conn) recordPong(args string, now time.Time) {
+	var sent, received, answered int64
+	if _, err := fmt.Sscanf(args, "%d %d %d", &sent, &received, &answered); err != nil {
+		return
+	}
+	t3 := now.UnixNano()
+	s := clockSample{
+		offset: time.Duration(((received - sent) + (answered - t3)) / 2),
+		rtt:    time.Duration((t3 - sent) - (answered - received)),
+	}
+	c.clockLock.Lock()
+	defer c.clockLock.Unlock()
+	c.clockSamples = append(c.clockSamples, s)
+	if len(c.clockSamples) > maxClockSamples {
+		c.clockSamples = c.clockSamples[1:]
+	}
+}
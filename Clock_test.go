@@ -0,0 +1,73 @@
+package bufconn_test
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// absDuration returns the size of d
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func TestClockOffsetBetweenConns(t *testing.T) {
+	a, b := net.Pipe()
+	ca := bufconn.NewConn(a, nil, '\n')
+	defer ca.Stop()
+	cb := bufconn.NewConn(b, nil, '\n')
+	defer cb.Stop()
+	if _, _, ok := ca.ClockOffset(); ok {
+		t.Fatal("expected no estimate before any pings")
+	}
+	now := time.Now()
+	if !ca.RemoteTime(now).Equal(now) {
+		t.Fatal("expected RemoteTime to be unchanged without an estimate")
+	}
+	cb.SetTimeSync(0)
+	ca.SetTimeSync(10 * time.Millisecond)
+	waitFor(t, "a ping to be answered", func() bool {
+		_, _, ok := ca.ClockOffset()
+		return ok
+	})
+	// Both ends share a clock, so the offset is only measurement error
+	if offset, rtt, _ := ca.ClockOffset(); absDuration(offset) > 50*time.Millisecond || rtt < 0 {
+		t.Fatalf("expected an offset near zero, got %v (rtt %v)", offset, rtt)
+	}
+}
+
+func TestClockOffsetSkewedRemote(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	// The remote answers pings as if its clock were an hour ahead
+	go func() {
+		for msg := range lines(b) {
+			if sent := strings.TrimPrefix(msg, "BUFCONN-CTL ping "); sent != msg {
+				ahead := time.Now().Add(time.Hour).UnixNano()
+				fmt.Fprintf(b, "BUFCONN-CTL pong %s %d %d\n", sent, ahead, ahead)
+			}
+		}
+	}()
+	c.SetTimeSync(10 * time.Millisecond)
+	waitFor(t, "a ping to be answered", func() bool {
+		_, _, ok := c.ClockOffset()
+		return ok
+	})
+	offset, _, _ := c.ClockOffset()
+	if absDuration(offset-time.Hour) > 50*time.Millisecond {
+		t.Fatalf("expected an offset of about an hour, got %v", offset)
+	}
+	remote := time.Now().Add(time.Hour)
+	if d := absDuration(c.RemoteTime(remote).Sub(time.Now())); d > 100*time.Millisecond {
+		t.Fatalf("expected the remote time to convert to about now, off by %v", d)
+	}
+}
@@ -0,0 +1,126 @@
+package bufconn
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// MaxDecompressedSize is the largest a compressed message may be once decompressed. A remote which sends a message that decompresses to more is stopped with ErrDecompressedTooLarge, so a few bytes on the wire can not use up all memory
+var MaxDecompressedSize = 1 << 24
+
+// ErrDecompressedTooLarge is the error a connection stops with when a compressed message decompresses to more than MaxDecompressedSize bytes
+var ErrDecompressedTooLarge = errors.New("decompressed message too large")
+
+// dictCapabilityPrefix is the handshake capability advertising a compression dictionary, followed by its ID
+const dictCapabilityPrefix = "dict:"
+
+// Dictionary is a pre-trained compression dictionary: a sample of typical message content, which lets even very small messages be compressed well.
+// Both ends must have the same data under the same ID. Messages are compressed with DEFLATE using the dictionary as its preset dictionary, rather than zstd, as the standard library has no zstd
+type Dictionary struct {
+	// ID identifies the dictionary during the handshake. It must not contain spaces or the delimiter
+	ID   string
+	Data []byte
+}
+
+// compressor compresses messages with a dictionary. It is only used from the processing loop, so it is not safe for concurrent use
+type compressor struct {
+	id   string
+	dict []byte
+	w    *flate.Writer
+	r    io.ReadCloser
+	buf  bytes.Buffer
+}
+
+func newCompressor(d Dictionary) *compressor {
+	w, _ := flate.NewWriterDict(nil, flate.BestCompression, d.Data)
+	return &compressor{
+		id:   d.ID,
+		dict: d.Data,
+		w:    w,
+		r:    flate.NewReaderDict(bytes.NewReader(nil), d.Data),
+	}
+}
+
+// compress deflates a message and encodes it as base64, so that the result never contains the delimiter
+func (z *compressor) compress(msg []byte) []byte {
+	z.buf.Reset()
+	z.w.Reset(&z.buf)
+	z.w.Write(msg)
+	z.w.Close()
+	out := make([]byte, base64.RawStdEncoding.EncodedLen(z.buf.Len()))
+	base64.RawStdEncoding.Encode(out, z.buf.Bytes())
+	return out
+}
+
+// decompress reverses compress. It returns ErrDecompressedTooLarge without reading any further once the output goes over MaxDecompressedSize
+func (z *compressor) decompress(msg []byte) ([]byte, error) {
+	raw := make([]byte, base64.RawStdEncoding.DecodedLen(len(msg)))
+	n, err := base64.RawStdEncoding.Decode(raw, msg)
+	if err != nil {
+		return nil, err
+	}
+	z.r.(flate.Resetter).Reset(bytes.NewReader(raw[:n]), z.dict)
+	limit := MaxDecompressedSize
+	out, err := io.ReadAll(io.LimitReader(z.r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, ErrDecompressedTooLarge
+	}
+	return out, nil
+}
+
+// Compression returns the ID of the dictionary the connection's messages are compressed with, or an empty string if they are not compressed
+func (c *Conn) Compression() string {
+	if c.compressor == nil {
+		return ""
+	}
+	return c.compressor.id
+}
+
+// pickDictionary returns the first of the dictionaries which both ends advertised during the handshake
+func pickDictionary(dicts []Dictionary, agreed []string) (Dictionary, bool) {
+	for _, d := range dicts {
+		for _, have := range agreed {
+			if have == dictCapabilityPrefix+d.ID {
+				return d, true
+			}
+		}
+	}
+	return Dictionary{}, false
+}
+
+// decodeMsg removes a received message's header if the connection uses headers, decompresses it if it is compressed, then converts and validates its text. If it can not be decoded, the connection is stopped
+func (c *Conn) decodeMsg(msg []byte) ([]byte, MessageFlags, bool) {
+	var flags MessageFlags
+	if c.headers {
+		var err error
+		if flags, msg, err = c.readHeader(msg); err != nil {
+			c.stopWithErr(err)
+			return nil, 0, false
+		}
+	} else if c.compressor != nil {
+		flags = FlagCompressed
+	}
+	if flags&FlagCompressed != 0 {
+		if c.compressor == nil {
+			c.stopWithErr(errors.New("received a compressed message, but compression was not agreed"))
+			return nil, 0, false
+		}
+		out, err := c.compressor.decompress(msg)
+		if err != nil {
+			c.stopWithErr(err)
+			return nil, 0, false
+		}
+		msg = out
+	}
+	msg, ok := c.settings().text.decode(msg)
+	if !ok {
+		c.stopWithErr(ErrInvalidUTF8)
+	}
+	return msg, flags, ok
+}
@@ -0,0 +1,87 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// handshakePair connects two conns over a pipe with the same handshake on both ends. Messages received by b are sent to the returned channel
+func handshakePair(t *testing.T, h bufconn.Handshake) (*bufconn.Conn, *bufconn.Conn, chan string) {
+	t.Helper()
+	pa, pb := net.Pipe()
+	received := make(chan string, 10)
+	type result struct {
+		c   *bufconn.Conn
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := bufconn.NewConnHandshake(pa, nil, '\n', h)
+		done <- result{c, err}
+	}()
+	b, err := bufconn.NewConnHandshake(pb, func(c *bufconn.C) {
+		for {
+			msg, ok := c.TryReadMsg()
+			if !ok {
+				return
+			}
+			received <- msg
+		}
+	}, '\n', h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	t.Cleanup(func() {
+		r.c.Stop()
+		b.Stop()
+	})
+	return r.c, b, received
+}
+
+var testDictionary = bufconn.Dictionary{ID: "test", Data: []byte(strings.Repeat("the quick brown fox ", 8))}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	a, b, received := handshakePair(t, bufconn.Handshake{Versions: []int{1}, Dictionaries: []bufconn.Dictionary{testDictionary}})
+	if a.Compression() != "test" || b.Compression() != "test" {
+		t.Fatalf("expected both ends to compress with the test dictionary, got %q and %q", a.Compression(), b.Compression())
+	}
+	a.SendMsg("the quick brown fox jumps", bufconn.PriorityNormal)
+	select {
+	case msg := <-received:
+		if msg != "the quick brown fox jumps" {
+			t.Fatalf("expected the message back unchanged, got %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestDecompressionLimit(t *testing.T) {
+	defer func(limit int) { bufconn.MaxDecompressedSize = limit }(bufconn.MaxDecompressedSize)
+	bufconn.MaxDecompressedSize = 1024
+	a, b, received := handshakePair(t, bufconn.Handshake{Versions: []int{1}, Dictionaries: []bufconn.Dictionary{testDictionary}})
+	// This compresses to a few bytes, but is far over the limit once decompressed
+	a.SendMsg(strings.Repeat("x", 64*1024), bufconn.PriorityNormal)
+	select {
+	case <-b.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the receiving connection to stop")
+	}
+	if !errors.Is(b.Err(), bufconn.ErrDecompressedTooLarge) {
+		t.Fatalf("expected ErrDecompressedTooLarge, got %v", b.Err())
+	}
+	select {
+	case msg := <-received:
+		t.Fatalf("expected nothing to be delivered, got %d bytes", len(msg))
+	default:
+	}
+}
@@ -3,90 +3,391 @@ package bufconn
 import (
 	"errors"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// closedChan is always ready to receive from
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// lastConnID is the ID given to the most recently created Conn
+var lastConnID uint64
+
+// ErrStopped is returned when an operation could not complete because the connection was stopped
+var ErrStopped = errors.New("connection stopped")
+
 // Conn is a wrapper for a net.Conn which provides more high level functionality.
 // It allows concurrent safe communication over a socket with a more logical API.
 // To do this, set the message handler (which is what is called when a new message comes in), or queue an operation.
 // For example, to sned hello to the remote, you could queue an operation which writes hello to the socket. You cannot directly write to the socket to prevent multiple goroutines writing at the same time and interfering
 type Conn struct {
-	netconn    net.Conn
-	readBuf    []byte
-	readChan   chan byte
-	opChan     chan func(*C)
+	id uint64
+	// writeStart is when the write currently in progress started, in unix nanoseconds, or zero if there is none. It is accessed atomically
+	writeStart int64
+	// handlerStart is when the message handler call currently in progress started, in unix nanoseconds, or zero if there is none. It is accessed atomically
+	handlerStart int64
+	// opStart is the same for the operation currently running
+	opStart int64
+	// loopGoroutine is the ID of the processing loop's goroutine, for the watchdog. It is accessed atomically
+	loopGoroutine int64
+	// readGoroutine is the same for the goroutine reading from the socket
+	readGoroutine int64
+	// buffered is the length of readBuf, kept so it can be read from other goroutines. It is accessed atomically
+	buffered int64
+	// prefetch is the prefetch window in bytes, or zero if there is none. It is accessed atomically
+	prefetch int64
+	// prefetchWake is sent to when bytes are taken from the buffer, to wake the reader if it is waiting for room in the prefetch window
+	prefetchWake chan struct{}
+	// reader is the goroutine reading from the socket, which is replaced when the connection migrates
+	reader *reader
+	// sock holds the *socket the connection is using. It is replaced as a whole when the connection migrates, so it can be read from any goroutine
+	sock     atomic.Value
+	readBuf  []byte
+	readChan chan byte
+	// opLanes holds queued operations, one channel per Priority. opSignal has one value for every queued operation, so the loop can wait on all lanes at once
+	opLanes   [numPriorities]chan func(*C)
+	opSignal  chan struct{}
+	opSkipped [numPriorities]int
+	// scheduled counts messages handled and operations run, for the weighted schedule
+	scheduled int
+	// pending is true when complete messages were buffered while a handler or operation was running. The loop never saw their delimiters arrive, so must call the handler for them itself
+	pending    bool
+	checkChan  chan func(*C)
 	msgHandler func(*C)
-	msgDelim   byte
-	isStopped  bool
-	stopChan   chan bool
+	// handlerFunc holds msgHandler too, so that Debug can read it from other goroutines
+	handlerFunc atomic.Value
+	// debugLog holds the *log.Logger for debug logging, or nil
+	debugLog atomic.Value
+	// authzGates are the checks of the Authorize handlers currently running, which every message taken must pass
+	authzGates []*authzGate
+	// trace is the trace ID of the message a Registry is handling or writing
+	trace string
+	// compressor compresses messages, if compression was agreed during the handshake
+	compressor *compressor
+	// headers is whether every message starts with a header, which is agreed in the handshake
+	headers bool
+	// lastFlags are the flags of the last message taken from the buffer
+	lastFlags MessageFlags
+	// deltaSent and deltaRecv are the latest version of each key written and read with delta encoding
+	deltaSent map[string]string
+	deltaRecv map[string]string
+	// delims holds the *delimTable of extra delimiters, or nil if there are none
+	delims atomic.Value
+	// lastDelim is the delimiter which ended the last message taken from the buffer
+	lastDelim byte
+	// arrivals are when the messages in the buffer were received, and receivedAt is when the last message taken from the buffer was
+	arrivals   arrivals
+	receivedAt time.Time
+	// quota holds the *quotaCounter, or nil if there is no quota
+	quota atomic.Value
+	// msgDelim is the main delimiter. It is read by the reader goroutine and can be changed by an upgrade, so it is accessed atomically with mainDelim
+	msgDelim uint32
+	// msgsRead counts the messages read from the buffer, so the loop can tell if a handler made progress
+	msgsRead int
+	// msgsReceived counts the messages added to the buffer, and msgsTaken the messages removed from it including control frames, so handler switches happen at the right message
+	msgsReceived int
+	msgsTaken    int
+	// switches are handler switches waiting for earlier messages to be handled (see SwitchHandler)
+	switches []handlerSwitch
+	// protocolVersion is the version agreed on in the handshake, if there was one
+	protocolVersion int
+	// capabilities are the capabilities both ends advertised in the handshake
+	capabilities []string
+	// labelled is whether the connection's goroutines have pprof labels, and extraLabels holds the []string of extra labels (see SetProfileLabels)
+	labelled    bool
+	extraLabels atomic.Value
+	bytesIn     rateCounter
+	bytesOut    rateCounter
+	// mirror holds the *mirror copying inbound messages, or nil. mirrorLock is held while it is replaced
+	mirror     atomic.Value
+	mirrorLock sync.Mutex
+	// leaks tracks the goroutines and timers the connection creates, or is nil if LeakCheck was off
+	leaks        *leakTracker
+	spool        *spool
+	controlLock  sync.Mutex
+	controls     map[string]func(*C, string)
+	clockLock    sync.Mutex
+	clockSamples []clockSample
+	// lastReceived is when the last complete message was received (or when the connection was created), used by heartbeats
+	lastReceived time.Time
+	metaLock     sync.Mutex
+	meta         map[string]any
+	// limiter holds the *tokenBucket shared between connections to limit how many messages they handle in total. It is swapped by a Manager while the connection runs
+	limiter atomic.Value
+	// writeSched holds the *writeScheduler shared between connections to take turns writing (see Manager.SetFairWrites). It is swapped by a Manager while the connection runs
+	writeSched atomic.Value
+	// opts are the settings which can be changed while the connection runs
+	opts         settings
+	settingsLock sync.RWMutex
+	stopChan     chan bool
+	stopOnce     sync.Once
+	stopErr      error
+	done         chan struct{}
+	// partialSince is when the first byte of the message currently being received was buffered, or zero if there are no bytes after the last delimiter
+	partialSince time.Time
 }
 
 // NewConn creates a new Conn using a net.Conn, a new message handler function, and a delimeter for messages
 func NewConn(c net.Conn, handler func(*C), delim byte) *Conn {
+	conn := newConn(c, handler, delim)
+	conn.start()
+	return conn
+}
+
+// newConn creates a Conn without starting its goroutines, so fields can be set before anything runs
+func newConn(c net.Conn, handler func(*C), delim byte) *Conn {
 	if handler == nil {
 		handler = func(c *C) {
 			c.ReadMsg(0)
 		}
 	}
 	conn := &Conn{
-		c,
-		make([]byte, 0),
-		make(chan byte, 100),
-		make(chan func(*C), 10),
-		handler,
-		delim,
-		false,
-		make(chan bool, 10),
-	}
-	go func() {
-		minibuf := make([]byte, 1)
+		id:           atomic.AddUint64(&lastConnID, 1),
+		readBuf:      make([]byte, 0),
+		lastReceived: time.Now(),
+		readChan:     make(chan byte, 100),
+		prefetchWake: make(chan struct{}, 1),
+		opSignal:     make(chan struct{}, 10*numPriorities),
+		checkChan:    make(chan func(*C), 10),
+		msgHandler:   handler,
+		msgDelim:     uint32(delim),
+		stopChan:     make(chan bool, 10),
+		done:         make(chan struct{}),
+		opts:         settings{writeRetry: RetryShortWrites, ids: &cryptoIDs{}},
+	}
+	for i := range conn.opLanes {
+		conn.opLanes[i] = make(chan func(*C), 10)
+	}
+	conn.handlerFunc.Store(handler)
+	conn.sock.Store(&socket{Conn: c})
+	if LeakCheck {
+		conn.leaks = &leakTracker{running: make(map[int]*tracked)}
+		conn.checkLeaks()
+	}
+	return conn
+}
+
+// start launches the goroutines which read from the socket and process messages and operations
+func (c *Conn) start() {
+	c.labelled = ProfileLabels
+	c.startReader(c.socket())
+	c.goTracked("loop", func() {
+		atomic.StoreInt64(&c.loopGoroutine, goroutineID())
+		c.labelGoroutine("loop")
+		defer func() {
+			c.spool.close()
+		}()
 		for {
-			// Check if the conn has been stopped. If the exit is not clean (i.e. remote simply stops responding) then this goroutine will hang forever
-			if conn.isStopped {
+			// We do this to give stop priority over other waiting operations
+			if len(c.stopChan) > 0 {
+				<-c.stopChan
+				c.socket().Close()
 				return
 			}
-			_, err := conn.netconn.Read(minibuf)
-			if err != nil {
-				conn.Stop()
+			if c.runPreferred() {
+				continue
+			}
+			// A buffered message is handled like any other waiting work, so it is picked at random along with the channels rather than blocking
+			var pending <-chan struct{}
+			if c.pending {
+				pending = closedChan
+			}
+			select {
+			case b := <-c.readChan:
+				c.handleByte(b)
+			case <-c.opSignal:
+				c.runOp()
+			case <-pending:
+				c.handlePending()
+			case f := <-c.checkChan:
+				f(&C{c})
+			case <-c.stopChan:
+				c.socket().Close()
 				return
 			}
-			conn.readChan <- minibuf[0]
 		}
-	}()
-	go func() {
+	})
+}
+
+// startReader launches the goroutine which reads from nc into the read channel. It exits without stopping the connection if the reader is replaced (see MigrateTo)
+func (c *Conn) startReader(nc net.Conn) {
+	r := &reader{quit: make(chan struct{}), exited: make(chan struct{})}
+	c.reader = r
+	c.goTracked("reader", func() {
+		defer close(r.exited)
+		atomic.StoreInt64(&c.readGoroutine, goroutineID())
+		c.labelGoroutine("reader")
+		buf := make([]byte, maxPrefetchRead)
+		// partial is the number of bytes read since the last delimiter, for the prefetch window
+		partial := 0
 		for {
-			// We do this to give stop priority over other waiting operations
-			if len(conn.stopChan) > 0 {
-				<-conn.stopChan
-				conn.netconn.Close()
+			// Check if the conn has been stopped. If the exit is not clean (i.e. remote simply stops responding) then this goroutine will hang forever
+			if c.IsStopped() {
 				return
 			}
-			select {
-			case b := <-conn.readChan:
-				conn.readBuf = append(conn.readBuf, b)
-				if b == conn.msgDelim {
-					conn.msgHandler(&C{conn})
+			size := c.readSize(partial, r.quit)
+			if size == 0 {
+				return
+			}
+			n, err := nc.Read(buf[:size])
+			received := time.Now()
+			if n > 0 {
+				c.bytesIn.add(n)
+				if err := c.quotaCounter().add(Inbound, n); err != nil {
+					c.stopWithErr(err)
+					return
+				}
+				for _, b := range buf[:n] {
+					if c.isDelim(b) {
+						partial = 0
+						c.arrivals.push(received)
+					} else {
+						partial++
+					}
+					select {
+					case c.readChan <- b:
+					case <-c.done:
+						// The processing loop has exited, so nothing will make room
+						return
+					}
+				}
+			}
+			if err != nil {
+				select {
+				case <-r.quit:
+					// The socket was closed because the connection moved to a new one
+				default:
+					c.finishReading(err)
 				}
-			case o := <-conn.opChan:
-				o(&C{conn})
-			case <-conn.stopChan:
-				conn.netconn.Close()
 				return
 			}
 		}
-	}()
-	return conn
+	})
+}
+
+// finishReading stops the connection with the error from reading the socket, once the processing loop has dealt with any control frames already received.
+// This means a go-away notice sent just before the remote closed is not lost. If the loop is too busy to do this quickly, the connection is stopped anyway
+func (c *Conn) finishReading(err error) {
+	select {
+	case c.checkChan <- func(c *C) {
+		c.Conn.updateWholeBuffer()
+		c.handleControls()
+		c.Conn.stopWithErr(err)
+	}:
+	default:
+	}
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+		c.stopWithErr(err)
+	}
+}
+
+// handleByte adds a byte from the socket to the buffer, and calls the message handler if it completes a message
+func (c *Conn) handleByte(b byte) {
+	c.appendByte(b)
+	if c.isDelim(b) {
+		c.handlePending()
+	}
+}
+
+// handlePending calls the message handler for the next complete message in the buffer, after dealing with any control frames in front of it
+func (c *Conn) handlePending() {
+	c.scheduled++
+	if !(&C{c}).handleControls() || c.nextDelim() < 0 {
+		c.pending = false
+		return
+	}
+	// If the handler did not read anything, calling it again for the same buffer would not help
+	c.pending = c.callHandler() && c.nextDelim() >= 0
+}
+
+// runOp runs the next queued operation
+func (c *Conn) runOp() {
+	c.scheduled++
+	atomic.StoreInt64(&c.opStart, time.Now().UnixNano())
+	op := c.nextOp()
+	c.runLabelled("operation", func() {
+		op(&C{c})
+	})
+	atomic.StoreInt64(&c.opStart, 0)
+	c.pending = c.nextDelim() >= 0
+}
+
+// callHandler runs the message handler once, and returns whether it read any messages
+func (c *Conn) callHandler() bool {
+	if l := c.rateLimiter(); l != nil {
+		l.wait()
+	}
+	before := c.msgsRead
+	start := time.Now()
+	atomic.StoreInt64(&c.handlerStart, start.UnixNano())
+	handler := c.handlerFor()
+	c.runLabelled("handler", func() {
+		handler(&C{c})
+	})
+	atomic.StoreInt64(&c.handlerStart, 0)
+	if m := c.settings().metrics; m != nil {
+		m.HandlerDone(time.Since(start))
+	}
+	return c.msgsRead != before
+}
+
+// every runs f in the processing loop once per interval until the connection is stopped. If the loop is busy when f is due, that run is skipped
+func (c *Conn) every(interval time.Duration, f func(*C)) {
+	c.goTracked("check", func() {
+		c.labelGoroutine("check")
+		t := time.NewTicker(interval)
+		defer c.track("timer", "check ticker")()
+		defer t.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-t.C:
+				select {
+				case c.checkChan <- f:
+				default:
+				}
+			}
+		}
+	})
 }
 
 func (c *Conn) updateWholeBuffer() {
 	for len(c.readChan) > 0 {
-		c.readBuf = append(c.readBuf, <-c.readChan)
+		c.appendByte(<-c.readChan)
+	}
+	c.unspool()
+}
+
+// appendByte adds a byte that has been read from the socket to the end of the buffer, or to the spool if the buffer is full
+func (c *Conn) appendByte(b byte) {
+	if c.spooling() {
+		if err := c.spool.write(b); err != nil {
+			c.stopWithErr(err)
+		}
+	} else {
+		c.readBuf = append(c.readBuf, b)
+		atomic.AddInt64(&c.buffered, 1)
+	}
+	if c.isDelim(b) {
+		c.msgsReceived++
+		c.partialSince = time.Time{}
+		c.lastReceived = time.Now()
+	} else if c.partialSince.IsZero() {
+		c.partialSince = time.Now()
 	}
 }
 
 // QueueOperation adds an operation to the end of the queue of operations, and it will be performed when possible
 func (c *Conn) QueueOperation(o func(*C)) {
-	c.opChan <- o
+	c.QueueOperationPriority(o, PriorityNormal)
 }
 
 // SetMessageHandler changes the message handler for the next message. It will come into effect after the current operation or handler
@@ -97,30 +398,89 @@ func (c *Conn) SetMessageHandler(f func(*C)) {
 		}
 	}
 	c.msgHandler = f
+	c.handlerFunc.Store(f)
+}
+
+// SetBatchMessageHandler changes the message handler to one which is passed every complete message in the buffer at once (see BatchHandler)
+func (c *Conn) SetBatchMessageHandler(f func(c *C, msgs []string)) {
+	c.SetMessageHandler(BatchHandler(f))
+}
+
+// SetBytesMessageHandler changes the message handler to one which is passed each message already read from the buffer (see BytesHandler)
+func (c *Conn) SetBytesMessageHandler(f func(c *C, msg []byte)) {
+	c.SetMessageHandler(BytesHandler(f))
 }
 
 // Stop will exit cleanly by finishing the current operation first
 func (c *Conn) Stop() {
-	if c.isStopped {
-		return
+	c.stopWithErr(nil)
+}
+
+// stopWithErr stops the connection, recording err as the reason it stopped
+func (c *Conn) stopWithErr(err error) {
+	c.stopOnce.Do(func() {
+		c.stopErr = err
+		c.stopChan <- true
+		close(c.done)
+	})
+}
+
+// Err returns the error which caused the connection to stop, such as the error from reading the socket or one of the policies giving up on the remote.
+// It returns nil if the connection is still running or was stopped by calling Stop
+func (c *Conn) Err() error {
+	select {
+	case <-c.done:
+		return c.stopErr
+	default:
+		return nil
+	}
+}
+
+// stoppedErr is the error reads return once the connection has stopped: the error it stopped with, or ErrStopped if it was stopped by calling Stop
+func (c *Conn) stoppedErr() error {
+	if err := c.Err(); err != nil {
+		return err
 	}
-	c.isStopped = true
-	c.stopChan <- true
+	return ErrStopped
+}
+
+// Done returns a channel that is closed once the connection has been stopped, either by calling Stop or by the remote going away
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
 }
 
 // IsStopped checks if the connection will run any further operations. This may return true (stopped) even if an operation is currently ongoing
 func (c *Conn) IsStopped() bool {
-	return c.isStopped
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Buffered returns the number of bytes which have been received from the remote but not yet read by a handler or operation
+func (c *Conn) Buffered() int {
+	return int(atomic.LoadInt64(&c.buffered)) + len(c.readChan)
+}
+
+// ID returns a number which uniquely identifies this connection within the process
+func (c *Conn) ID() uint64 {
+	return c.id
 }
 
 // Underlying net.Conn.LocalAddr()
 func (c *Conn) LocalAddr() net.Addr {
-	return c.netconn.LocalAddr()
+	return c.socket().LocalAddr()
 }
 
-// Underlying net.Conn.RemoteAddr()
+// Underlying net.Conn.RemoteAddr(), or the address from the PROXY header if the connection came from an Acceptor which reads them
 func (c *Conn) RemoteAddr() net.Addr {
-	return c.netconn.RemoteAddr()
+	s := c.socket()
+	if s.proxyAddr != nil {
+		return s.proxyAddr
+	}
+	return s.RemoteAddr()
 }
 
 // C is a wrapper for Conn which adds the ability to read and write messages. This should only be used within message handlers and operations
@@ -129,50 +489,202 @@ type C struct {
 }
 
 // ReadMsg reads an entire message (string ending with the delimer) from the buffer. It will wait for it to become available.
-// If the timeout is reached, this function will return an error. If the timeout is zero, then no timeout will be used.
+// If the timeout is reached, this function will return ErrReadTimeout. If the timeout is zero, then no timeout will be used.
+// If the connection stops before a message is available, the error it stopped with is returned, or ErrStopped if there was none.
 // It does NOT include the delimeter in the return
 func (c *C) ReadMsg(timeout time.Duration) (string, error) {
 	now := time.Now()
 	for {
 		if time.Since(now) > timeout && timeout != 0 {
-			return "", errors.New("message read timeout")
+			return "", c.Conn.readTimedOut()
+		}
+		// The stop is checked first so that messages received before it are still read
+		stopped := c.Conn.IsStopped()
+		if msg, ok := c.TryReadMsg(); ok {
+			return msg, nil
+		}
+		if stopped {
+			return "", c.Conn.stoppedErr()
+		}
+	}
+}
+
+// readBurst waits for a message, then also reads any other complete messages which are already buffered.
+// Messages which arrive while a handler is running do not trigger the handler again, so handlers should use this to avoid leaving them in the buffer
+func (c *C) readBurst() []string {
+	msg, _ := c.ReadMsg(0)
+	msgs := []string{msg}
+	for {
+		msg, ok := c.TryReadMsg()
+		if !ok {
+			return msgs
 		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// TryReadMsg reads a message from the buffer if a complete one is available, without waiting for one. The second return is false if there was no complete message.
+// Like ReadMsg, it does NOT include the delimeter in the return
+func (c *C) TryReadMsg() (string, bool) {
+	return c.takeMsg(AuditOK)
+}
+
+// discardMsg reads the next complete message, if there is one, recording it as denied rather than read
+func (c *C) discardMsg() {
+	c.takeMsg(AuditDenied)
+}
+
+// takeMsg removes the next complete message from the buffer, and audits it with the outcome
+func (c *C) takeMsg(outcome string) (string, bool) {
+	out, ok := c.takeBytes(outcome)
+	return string(out), ok
+}
+
+// takeBytes is the same as takeMsg, but returns the message without copying it into a string.
+// While an Authorize handler is running, messages it denies are skipped, so every message taken is checked
+func (c *C) takeBytes(outcome string) ([]byte, bool) {
+	for {
 		c.Conn.updateWholeBuffer()
-		for i, b := range c.Conn.readBuf {
-			if b == c.Conn.msgDelim {
-				out := make([]byte, i+1)
-				copy(out, c.Conn.readBuf)
-				c.Conn.readBuf = c.Conn.readBuf[i+1:]
-				return string(out[:len(out)-1]), nil
-			}
+		if !c.handleControls() {
+			return nil, false
+		}
+		i := c.Conn.nextDelim()
+		if i < 0 {
+			return nil, false
+		}
+		out := make([]byte, i)
+		copy(out, c.Conn.readBuf)
+		c.Conn.lastDelim = c.Conn.readBuf[i]
+		c.Conn.readBuf = c.Conn.readBuf[i+1:]
+		c.Conn.consumed(i + 1)
+		c.Conn.unspool()
+		c.Conn.msgsTaken++
+		c.Conn.takeArrival()
+		c.Conn.msgsRead++
+		out, flags, ok := c.Conn.decodeMsg(out)
+		if !ok {
+			return nil, false
+		}
+		c.Conn.lastFlags = flags
+		var denied *authzGate
+		if outcome == AuditOK {
+			denied = c.Conn.deniedBy(c, string(out))
+		}
+		msgOutcome := outcome
+		if denied != nil {
+			msgOutcome = AuditDenied
 		}
+		if m := c.Conn.settings().metrics; m != nil {
+			m.MessageRead(len(out))
+		}
+		c.Conn.audit(Inbound, out, len(out), msgOutcome, nil)
+		if m := c.Conn.currentMirror(); m != nil {
+			m.send(c.Conn.redact(string(out)), c.Conn.lastDelim)
+		}
+		if denied == nil {
+			return out, true
+		}
+		if denied.onDeny != nil {
+			denied.onDeny(c, string(out))
+		}
+	}
+}
+
+// peekMsg returns the next complete message in the buffer without removing it
+func (c *C) peekMsg() (string, bool) {
+	c.Conn.updateWholeBuffer()
+	if !c.handleControls() {
+		return "", false
+	}
+	i := c.Conn.nextDelim()
+	if i < 0 {
+		return "", false
 	}
+	msg, _, ok := c.Conn.decodeMsg(c.Conn.readBuf[:i])
+	return string(msg), ok
+}
+
+// nextDelim returns the index of the first delimiter in the buffer, or -1 if there is not a complete message
+func (c *Conn) nextDelim() int {
+	for i, b := range c.readBuf {
+		if c.isDelim(b) {
+			return i
+		}
+	}
+	return -1
 }
 
 // Read reads an number of bytes from the buffer. It will wait for them to become available.
-// If the timeout is reached, this function will return an error. If the timeout is zero, then no timeout will be used
+// If the timeout is reached, this function will return ErrReadTimeout. If the timeout is zero, then no timeout will be used.
+// Like ReadMsg, it returns the connection's error if it stops before there are enough bytes
 func (c *C) Read(n int, timeout time.Duration) ([]byte, error) {
 	now := time.Now()
 	for {
 		if time.Since(now) > timeout && timeout != 0 {
-			return []byte{}, errors.New("message read timeout")
+			return []byte{}, c.Conn.readTimedOut()
 		}
+		stopped := c.Conn.IsStopped()
 		c.Conn.updateWholeBuffer()
 		if len(c.Conn.readBuf) >= n {
 			out := make([]byte, n)
 			copy(out, c.Conn.readBuf)
 			c.Conn.readBuf = c.Conn.readBuf[n:]
+			c.Conn.consumed(n)
+			c.Conn.rawTaken(out)
+			c.Conn.unspool()
+			c.Conn.audit(Inbound, nil, n, AuditOK, nil)
 			return out, nil
 		}
+		if stopped {
+			return []byte{}, c.Conn.stoppedErr()
+		}
 	}
 }
 
-// Write writes a slice of bytes to the underlying net.Conn. It returns the number of bytes written and the error
+// write writes to the underlying net.Conn, recording when the write started so stalls can be noticed
+func (c *Conn) write(bs []byte) (int, error) {
+	if err := c.quotaCounter().add(Outbound, len(bs)); err != nil {
+		c.stopWithErr(err)
+		return 0, err
+	}
+	b := c.settings().breaker
+	if err := b.allow(); err != nil {
+		return 0, err
+	}
+	atomic.StoreInt64(&c.writeStart, time.Now().UnixNano())
+	defer atomic.StoreInt64(&c.writeStart, 0)
+	n, err := c.writeSlots().write(c, bs)
+	c.bytesOut.add(n)
+	b.record(err)
+	return n, err
+}
+
+// Write writes a slice of bytes to the underlying net.Conn, retrying short writes (see SetWriteRetry). It returns the number of bytes written and the error
 func (c *C) Write(bs []byte) (int, error) {
-	return c.Conn.netconn.Write(bs)
+	n, err := c.Conn.write(bs)
+	c.Conn.audit(Outbound, nil, len(bs), AuditOK, err)
+	return n, err
 }
 
 // WriteMsg takes a string message and appends the delimeter, then writes it to the underlying connection
 func (c *C) WriteMsg(msg string) (int, error) {
-	return c.Write(append([]byte(msg), c.Conn.msgDelim))
+	return c.writeMsg(msg, 0)
+}
+
+// writeMsg writes a message with the flags for its header, if the connection uses headers
+func (c *C) writeMsg(msg string, flags MessageFlags) (int, error) {
+	return c.writeMsgDelim(msg, flags, c.Conn.mainDelim())
+}
+
+// writeMsgDelim writes a message ending with delim
+func (c *C) writeMsgDelim(msg string, flags MessageFlags, delim byte) (int, error) {
+	if msg == "" {
+		if write, err := c.Conn.emptyWrite(); !write {
+			return 0, err
+		}
+	}
+	bs := c.Conn.encodeMsg([]byte(msg), flags)
+	n, err := c.Conn.write(append(bs, delim))
+	c.Conn.audit(Outbound, []byte(msg), len(msg), AuditOK, err)
+	return n, err
 }
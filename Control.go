@@ -0,0 +1,78 @@
+package bufconn
+
+import "strings"
+
+// controlPrefix starts every control frame. Control frames are ordinary messages which the library sends to the remote's library, and which are never seen by message handlers
+const controlPrefix = "BUFCONN-CTL "
+
+// onControl makes control frames of the kind be passed to f, with everything after the kind as args, instead of to the message handler.
+// Control frames of kinds without a function are treated as ordinary messages
+func (c *Conn) onControl(kind string, f func(c *C, args string)) {
+	c.controlLock.Lock()
+	defer c.controlLock.Unlock()
+	if c.controls == nil {
+		c.controls = make(map[string]func(*C, string))
+	}
+	c.controls[kind] = f
+}
+
+// control returns the function for the control frame of the message, if it is one
+func (c *Conn) control(msg []byte) (func(*C, string), string) {
+	if len(msg) < len(controlPrefix) || string(msg[:len(controlPrefix)]) != controlPrefix {
+		return nil, ""
+	}
+	kind, args, _ := strings.Cut(string(msg[len(controlPrefix):]), " ")
+	c.controlLock.Lock()
+	defer c.controlLock.Unlock()
+	return c.controls[kind], args
+}
+
+// handleControls takes every control frame from the front of the buffer and passes it to its function, stopping at the first ordinary message. Empty messages are dealt with here too, unless they are delivered.
+// It returns false once an empty message has stopped the connection, as nothing after it should be handled
+func (c *C) handleControls() bool {
+	if c.Conn.Err() == ErrEmptyMessage {
+		return false
+	}
+	for {
+		i := c.Conn.nextDelim()
+		if i < 0 {
+			return true
+		}
+		empty := c.Conn.settings().emptyPolicy
+		if i == 0 && empty != EmptyDeliver {
+			c.Conn.readBuf = c.Conn.readBuf[1:]
+			c.Conn.consumed(1)
+			c.Conn.unspool()
+			c.Conn.msgsTaken++
+			c.Conn.arrivals.pop()
+			if empty == EmptyError {
+				c.Conn.stopWithErr(ErrEmptyMessage)
+				return false
+			}
+			continue
+		}
+		if c.Conn.readBuf[i] != c.Conn.mainDelim() {
+			return true
+		}
+		f, args := c.Conn.control(c.Conn.readBuf[:i])
+		if f == nil {
+			return true
+		}
+		c.Conn.readBuf = c.Conn.readBuf[i+1:]
+		c.Conn.consumed(i + 1)
+		c.Conn.unspool()
+		c.Conn.msgsTaken++
+		c.Conn.arrivals.pop()
+		f(c, args)
+	}
+}
+
+// writeControl writes a control frame to the remote
+func (c *C) writeControl(kind, args string) error {
+	msg := controlPrefix + kind
+	if args != "" {
+		msg += " " + args
+	}
+	_, err := c.Conn.write(append([]byte(msg), c.Conn.mainDelim()))
+	return err
+}
@@ -0,0 +1,83 @@
+package bufconn
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DebugInfo is a snapshot of a connection's internal state, for admin endpoints and debugging
+type DebugInfo struct {
+	ID      uint64
+	Stopped bool
+	Err     error
+	// QueuedOps is the number of operations waiting to run, and QueuedByPriority splits it up by priority, lowest first
+	QueuedOps        int
+	QueuedByPriority [numPriorities]int
+	// SendQueue is the state of the send queue, if there is one (see Conn.SetSendQueue)
+	SendQueue SendQueueStats
+	// ReadBacklog is the number of received bytes waiting to be added to the buffer
+	ReadBacklog int
+	// Buffered is the number of bytes in the buffer (see Conn.Buffered)
+	Buffered int
+	// Prefetch is the prefetch window in bytes, or zero if there is none (see Conn.SetPrefetch)
+	Prefetch int
+	// Handler is the name of the message handler function, and HandlerPC its address
+	Handler   string
+	HandlerPC uintptr
+	// Busy is what the processing loop is doing ("idle", "handler" or "operation") and BusyFor is for how long
+	Busy    string
+	BusyFor time.Duration
+	// WritingFor is how long the write in progress has been running, or zero if there is none
+	WritingFor time.Duration
+	// LoopState and ReaderState are the scheduler states of the processing loop and socket reading goroutines as shown in stack traces, such as "select" or "IO wait". They are empty once the goroutines have exited
+	LoopState   string
+	ReaderState string
+}
+
+// Debug takes a snapshot of the connection's internal state. It is safe to call from any goroutine at any time, but reading the goroutine states is slow, so it should not be called often
+func (c *Conn) Debug() DebugInfo {
+	d := DebugInfo{
+		ID:          c.id,
+		Stopped:     c.IsStopped(),
+		Err:         c.Err(),
+		QueuedOps:   len(c.opSignal),
+		ReadBacklog: len(c.readChan),
+		Buffered:    c.Buffered(),
+		Prefetch:    c.Prefetch(),
+		Busy:        "idle",
+	}
+	d.SendQueue = c.SendQueueStats()
+	for p := range c.opLanes {
+		d.QueuedByPriority[p] = len(c.opLanes[p])
+	}
+	if f, ok := c.handlerFunc.Load().(func(*C)); ok {
+		d.HandlerPC = reflect.ValueOf(f).Pointer()
+		if fn := runtime.FuncForPC(d.HandlerPC); fn != nil {
+			d.Handler = fn.Name()
+		}
+	}
+	if started := atomic.LoadInt64(&c.handlerStart); started != 0 {
+		d.Busy, d.BusyFor = "handler", time.Since(time.Unix(0, started))
+	} else if started := atomic.LoadInt64(&c.opStart); started != 0 {
+		d.Busy, d.BusyFor = "operation", time.Since(time.Unix(0, started))
+	}
+	if started := atomic.LoadInt64(&c.writeStart); started != 0 {
+		d.WritingFor = time.Since(time.Unix(0, started))
+	}
+	d.LoopState = goroutineState(goroutineStack(atomic.LoadInt64(&c.loopGoroutine)))
+	d.ReaderState = goroutineState(goroutineStack(atomic.LoadInt64(&c.readGoroutine)))
+	return d
+}
+
+// goroutineState reads the state out of the header of a goroutine's stack trace, such as "select" from "goroutine 8 [select]:"
+func goroutineState(stack string) string {
+	start := strings.IndexByte(stack, '[')
+	end := strings.IndexByte(stack, ']')
+	if start < 0 || end < start {
+		return ""
+	}
+	return stack[start+1 : end]
+}
@@ -0,0 +1,56 @@
+package bufconn_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// namedHandler is a message handler with a name to look for in debug info
+func namedHandler(c *bufconn.C) {
+	c.ReadMsg(0)
+}
+
+func TestDebugShowsQueuesAndBusyLoop(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, namedHandler, '\n')
+	release, started := make(chan struct{}), make(chan struct{})
+	c.QueueOperation(func(*bufconn.C) {
+		close(started)
+		<-release
+	})
+	<-started
+	c.QueueOperationPriority(func(*bufconn.C) {}, bufconn.PriorityLow)
+	c.QueueOperationPriority(func(*bufconn.C) {}, bufconn.PriorityHigh)
+	c.QueueOperationPriority(func(*bufconn.C) {}, bufconn.PriorityHigh)
+	b.Write([]byte("partial"))
+	waitFor(t, "bytes to arrive", func() bool { return c.Buffered() == 7 })
+	time.Sleep(10 * time.Millisecond)
+	d := c.Debug()
+	close(release)
+	if d.ID != c.ID() || d.Stopped || d.QueuedOps != 3 || d.QueuedByPriority != [3]int{1, 0, 2} {
+		t.Fatalf("unexpected queues %+v", d)
+	}
+	if d.Busy != "operation" || d.BusyFor < 10*time.Millisecond || d.WritingFor != 0 {
+		t.Fatalf("expected the loop to be busy in an operation, got %q for %v", d.Busy, d.BusyFor)
+	}
+	if d.Buffered != 7 {
+		t.Fatalf("expected 7 bytes buffered, got %d", d.Buffered)
+	}
+	if !strings.HasSuffix(d.Handler, "namedHandler") || d.HandlerPC == 0 {
+		t.Fatalf("expected the handler's name, got %q", d.Handler)
+	}
+	if d.LoopState == "" || d.ReaderState == "" {
+		t.Fatalf("expected goroutine states, got %q and %q", d.LoopState, d.ReaderState)
+	}
+	c.Stop()
+	<-c.Done()
+	waitFor(t, "the goroutines to exit", func() bool {
+		d := c.Debug()
+		return d.Stopped && d.LoopState == "" && d.ReaderState == ""
+	})
+}
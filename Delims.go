@@ -0,0 +1,73 @@
+package bufconn
+
+import "sync/atomic"
+
+// delimTable holds the handler for each extra delimiter, indexed by the delimiter byte
+type delimTable [256]func(*C)
+
+// SetDelimHandler makes delim end messages as well as the main delimiter, with messages ending in it passed to handler instead of the message handler.
+// This is for protocols which mix frame types on one socket, such as text commands ending in '\n' and binary frames ending in 0x00. Handlers can tell which delimiter ended the last message they read with LastDelim.
+// Messages are still read in the order they arrived, so a handler which reads more than one message at a time (such as a BatchHandler) may be given messages with other delimiters.
+// Control frames only ever end with the main delimiter. If handler is nil, delim stops being a delimiter. If delim is the main delimiter, this is the same as SetMessageHandler.
+// It should be called before the remote starts sending, or from within a handler or operation
+func (c *Conn) SetDelimHandler(delim byte, handler func(*C)) {
+	if delim == c.mainDelim() {
+		c.SetMessageHandler(handler)
+		return
+	}
+	var t delimTable
+	if old := c.delimTable(); old != nil {
+		t = *old
+	}
+	t[delim] = handler
+	for _, h := range t {
+		if h != nil {
+			c.delims.Store(&t)
+			return
+		}
+	}
+	c.delims.Store((*delimTable)(nil))
+}
+
+// mainDelim returns the delimiter which ends messages for the message handler and control frames
+func (c *Conn) mainDelim() byte {
+	return byte(atomic.LoadUint32(&c.msgDelim))
+}
+
+// delimTable returns the table of extra delimiters, or nil if there are none
+func (c *Conn) delimTable() *delimTable {
+	t, _ := c.delims.Load().(*delimTable)
+	return t
+}
+
+// isDelim checks if b ends a message, either as the main delimiter or an extra one
+func (c *Conn) isDelim(b byte) bool {
+	if b == c.mainDelim() {
+		return true
+	}
+	t := c.delimTable()
+	return t != nil && t[b] != nil
+}
+
+// handlerFor returns the handler for the next complete message in the buffer, which depends on the delimiter it ends with
+func (c *Conn) handlerFor() func(*C) {
+	c.applySwitches()
+	i := c.nextDelim()
+	if i < 0 || c.readBuf[i] == c.mainDelim() {
+		return c.msgHandler
+	}
+	if t := c.delimTable(); t != nil && t[c.readBuf[i]] != nil {
+		return t[c.readBuf[i]]
+	}
+	return c.msgHandler
+}
+
+// LastDelim returns the delimiter which ended the last message read from the buffer
+func (c *C) LastDelim() byte {
+	return c.Conn.lastDelim
+}
+
+// WriteMsgDelim is the same as WriteMsg, but ends the message with delim instead of the main delimiter. The remote must also treat delim as a delimiter (see SetDelimHandler)
+func (c *C) WriteMsgDelim(msg string, delim byte) (int, error) {
+	return c.writeMsgDelim(msg, 0, delim)
+}
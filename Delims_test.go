@@ -0,0 +1,63 @@
+package bufconn_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// delimConn creates a connection with '\n' as its main delimiter and 0 as an extra one, sending each message it receives on the returned channel with the handler that read it and the delimiter that ended it
+func delimConn(t *testing.T) (*bufconn.Conn, net.Conn, <-chan string) {
+	a, b := net.Pipe()
+	got := make(chan string, 20)
+	read := func(name string) func(*bufconn.C) {
+		return func(c *bufconn.C) {
+			msg, _ := c.ReadMsg(0)
+			got <- fmt.Sprintf("%s %q %d", name, msg, c.LastDelim())
+		}
+	}
+	c := bufconn.NewConn(a, read("text"), '\n')
+	c.SetDelimHandler(0, read("binary"))
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	return c, b, got
+}
+
+func TestDelimHandlersGetTheirMessages(t *testing.T) {
+	_, remote, got := delimConn(t)
+	remote.Write([]byte("one\ntwo\x00three\n\x00"))
+	expectLines(t, got, `text "one" 10`, `binary "two" 0`, `text "three" 10`, `binary "" 0`)
+}
+
+func TestDelimHandlerRemoved(t *testing.T) {
+	c, remote, got := delimConn(t)
+	remote.Write([]byte("a\x00"))
+	expectLines(t, got, `binary "a" 0`)
+	c.SetDelimHandler(0, nil)
+	remote.Write([]byte("b\x00c\n"))
+	expectLines(t, got, `text "b\x00c" 10`)
+}
+
+func TestWriteMsgDelim(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	c.QueueOperation(func(c *bufconn.C) {
+		c.WriteMsgDelim("frame", 0)
+		c.WriteMsg("line")
+	})
+	want := "frame\x00line\n"
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(b, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != want {
+		t.Fatalf("expected %q, got %q", want, buf)
+	}
+}
@@ -0,0 +1,106 @@
+package bufconn
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// deltaPrefix starts every message written with WriteDelta
+const deltaPrefix = "BUFCONN-DELTA "
+
+// ErrDeltaBase is passed to a DeltaHandler's error function when a delta arrives for a key which has no earlier version to apply it to
+var ErrDeltaBase = errors.New("delta for key with no base version")
+
+// WriteDelta writes msg as the latest version of key. If key has been written before, only the difference from the last version is sent when that is shorter, which saves a lot for frequently updated messages which barely change.
+// The remote must read the messages with a DeltaHandler. key must not contain spaces or the delimiter. This should only be called within an operation or handler
+func (c *C) WriteDelta(key, msg string) (int, error) {
+	if c.Conn.deltaSent == nil {
+		c.Conn.deltaSent = make(map[string]string)
+	}
+	full := deltaPrefix + key + " = " + msg
+	out := full
+	if prev, ok := c.Conn.deltaSent[key]; ok {
+		prefix, suffix := commonAffixes(prev, msg)
+		delta := deltaPrefix + key + " ~ " + strconv.Itoa(prefix) + " " + strconv.Itoa(suffix) + " " + msg[prefix:len(msg)-suffix]
+		if len(delta) < len(full) {
+			out = delta
+		}
+	}
+	n, err := c.WriteMsg(out)
+	if err == nil {
+		c.Conn.deltaSent[key] = msg
+	}
+	return n, err
+}
+
+// DeltaHandler creates a message handler which rebuilds each message written with WriteDelta from the last version of its key, and passes it to f with its key.
+// Messages which were not written with WriteDelta are passed to f with an empty key. Messages which can not be rebuilt are passed to onError, which may be nil, and then dropped
+func DeltaHandler(f func(c *C, key, msg string), onError func(c *C, err error)) func(*C) {
+	return func(c *C) {
+		msg, ok := c.TryReadMsg()
+		if !ok {
+			return
+		}
+		if !strings.HasPrefix(msg, deltaPrefix) {
+			f(c, "", msg)
+			return
+		}
+		key, full, err := c.Conn.applyDelta(msg[len(deltaPrefix):])
+		if err != nil {
+			if onError != nil {
+				onError(c, err)
+			}
+			return
+		}
+		f(c, key, full)
+	}
+}
+
+// applyDelta rebuilds a message from a delta (without its prefix), such as "key ~ 3 2 abc", and remembers it as the key's latest version
+func (c *Conn) applyDelta(delta string) (string, string, error) {
+	key, rest, _ := strings.Cut(delta, " ")
+	kind, body, ok := strings.Cut(rest, " ")
+	if !ok {
+		return key, "", fmt.Errorf("malformed delta for key %q", key)
+	}
+	if c.deltaRecv == nil {
+		c.deltaRecv = make(map[string]string)
+	}
+	switch kind {
+	case "=":
+		c.deltaRecv[key] = body
+		return key, body, nil
+	case "~":
+		prev, ok := c.deltaRecv[key]
+		if !ok {
+			return key, "", fmt.Errorf("%w: %q", ErrDeltaBase, key)
+		}
+		prefixStr, rest, _ := strings.Cut(body, " ")
+		suffixStr, middle, ok := strings.Cut(rest, " ")
+		prefix, err1 := strconv.Atoi(prefixStr)
+		suffix, err2 := strconv.Atoi(suffixStr)
+		if !ok || err1 != nil || err2 != nil || prefix < 0 || suffix < 0 || prefix+suffix > len(prev) {
+			return key, "", fmt.Errorf("malformed delta for key %q", key)
+		}
+		full := prev[:prefix] + middle + prev[len(prev)-suffix:]
+		c.deltaRecv[key] = full
+		return key, full, nil
+	default:
+		return key, "", fmt.Errorf("malformed delta for key %q", key)
+	}
+}
+
+// commonAffixes returns the lengths of the longest common prefix of a and b, and the longest common suffix of what is left after it
+func commonAffixes(a, b string) (int, int) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return prefix, suffix
+}
@@ -0,0 +1,70 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// deltaConn creates a connection reading with a DeltaHandler, sending each message as "key=msg" and each error on the returned channels
+func deltaConn(t *testing.T) (net.Conn, <-chan string, <-chan error) {
+	a, b := net.Pipe()
+	got, errs := make(chan string, 10), make(chan error, 10)
+	c := bufconn.NewConn(a, bufconn.DeltaHandler(func(c *bufconn.C, key, msg string) {
+		got <- key + "=" + msg
+	}, func(c *bufconn.C, err error) {
+		errs <- err
+	}), '\n')
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	return b, got, errs
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	a, b := net.Pipe()
+	sender := bufconn.NewConn(a, nil, '\n')
+	defer sender.Stop()
+	wire := make(chan string, 10)
+	relay := bufconn.NewConn(b, nil, '\n')
+	defer relay.Stop()
+	remote, got, _ := deltaConn(t)
+	// The relay passes each message on to the delta handler as it was sent, so the wire format can be checked
+	relay.SetMessageHandler(func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		wire <- msg
+		remote.Write([]byte(msg + "\n"))
+	})
+	first := `{"x":1,"y":2,"name":"a long name which does not change"}`
+	second := `{"x":5,"y":2,"name":"a long name which does not change"}`
+	sender.QueueOperation(func(c *bufconn.C) {
+		c.WriteDelta("pos", first)
+		c.WriteDelta("pos", second)
+		c.WriteDelta("other", "short")
+		c.WriteDelta("other", "shout")
+		c.WriteMsg("plain")
+	})
+	expectLines(t, got, "pos="+first, "pos="+second, "other=short", "other=shout", "=plain")
+	expectLines(t, wire, "BUFCONN-DELTA pos = "+first, "BUFCONN-DELTA pos ~ 5 50 5")
+	// A delta for a short message is longer than sending it whole
+	expectLines(t, wire, "BUFCONN-DELTA other = short", "BUFCONN-DELTA other = shout", "plain")
+}
+
+func TestDeltaErrors(t *testing.T) {
+	remote, got, errs := deltaConn(t)
+	remote.Write([]byte("BUFCONN-DELTA k ~ 0 0 x\nBUFCONN-DELTA k = abc\nBUFCONN-DELTA k ~ 2 2 x\nBUFCONN-DELTA k\nBUFCONN-DELTA k ~ 1 1 x\n"))
+	if err := <-errs; !errors.Is(err, bufconn.ErrDeltaBase) {
+		t.Fatalf("expected ErrDeltaBase, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !strings.Contains(err.Error(), "malformed delta") {
+			t.Fatalf("expected a malformed delta, got %v", err)
+		}
+	}
+	// Messages which can not be rebuilt are dropped without changing the key's version
+	expectLines(t, got, "k=abc", "k=axc")
+}
@@ -0,0 +1,136 @@
+package bufconn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// FallbackDelay is how long dialing waits on one connection attempt before racing the next address alongside it (see RFC 8305).
+// If an attempt fails, the next one is started straight away
+var FallbackDelay = 250 * time.Millisecond
+
+// Dial connects to the address on the named network (see net.Dial) and wraps the resulting connection in a new Conn.
+// If the host resolves to both IPv6 and IPv4 addresses, they are raced against each other and the first to connect is used
+func Dial(network, addr string, handler func(*C), delim byte) (*Conn, error) {
+	addrs, err := resolveAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := dialRace(network, addrs)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(c, handler, delim), nil
+}
+
+// resolveAddr looks up the host of a host:port address, returning an address for each IP it resolves to.
+// Addresses which are not host:port (such as unix sockets), or whose host is empty or already an IP, are returned unchanged
+func resolveAddr(addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []string{addr}, nil
+	}
+	if _, err := netip.ParseAddr(host); host == "" || err == nil {
+		// There is nothing to look up, and an empty host means the local system, as with net.Dial
+		return []string{addr}, nil
+	}
+	ips, err := net.LookupHost(host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, port)
+	}
+	return addrs, nil
+}
+
+// sortAddrs drops addresses which do not suit the network, and interleaves the rest so IPv6 and IPv4 take turns, starting with IPv6
+func sortAddrs(network string, addrs []string) []string {
+	var v6, v4, other []string
+	for _, addr := range addrs {
+		host, _, err := net.SplitHostPort(addr)
+		ip := net.ParseIP(host)
+		switch {
+		case err != nil || ip == nil:
+			other = append(other, addr)
+		case ip.To4() == nil:
+			if network != "tcp4" && network != "udp4" {
+				v6 = append(v6, addr)
+			}
+		default:
+			if network != "tcp6" && network != "udp6" {
+				v4 = append(v4, addr)
+			}
+		}
+	}
+	sorted := make([]string, 0, len(addrs))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			sorted = append(sorted, v6[i])
+		}
+		if i < len(v4) {
+			sorted = append(sorted, v4[i])
+		}
+	}
+	return append(sorted, other...)
+}
+
+// dialRace dials the addresses in turn, starting the next one whenever the current attempt fails or FallbackDelay passes, and returns the first to connect
+func dialRace(network string, addrs []string) (net.Conn, error) {
+	addrs = sortAddrs(network, addrs)
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses suitable for network " + network)
+	}
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	results := make(chan result, len(addrs))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var d net.Dialer
+	next, pending := 0, 0
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			c, err := d.DialContext(ctx, network, addr)
+			results <- result{c, err}
+		}()
+	}
+	start()
+	var lastErr error
+	for pending > 0 {
+		var fallback <-chan time.Time
+		if next < len(addrs) {
+			fallback = time.After(FallbackDelay)
+		}
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// Any other attempts which still manage to connect are not needed
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if r := <-results; r.err == nil {
+							r.c.Close()
+						}
+					}
+				}(pending)
+				return r.c, nil
+			}
+			lastErr = r.err
+			if next < len(addrs) {
+				start()
+			}
+		case <-fallback:
+			start()
+		}
+	}
+	return nil, lastErr
+}
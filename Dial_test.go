@@ -0,0 +1,80 @@
+package bufconn
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolveAddrLeavesLiteralsAlone(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:80", "[::1]:80", ":80", "/tmp/bufconn.sock"} {
+		got, err := resolveAddr(addr)
+		if err != nil {
+			t.Fatalf("%s: %v", addr, err)
+		}
+		if !reflect.DeepEqual(got, []string{addr}) {
+			t.Fatalf("%s: expected it unchanged, got %v", addr, got)
+		}
+	}
+}
+
+func TestResolveAddrLooksUpHostnames(t *testing.T) {
+	got, err := resolveAddr("localhost:80")
+	if err != nil {
+		t.Skip("localhost does not resolve:", err)
+	}
+	if len(got) == 0 {
+		t.Fatal("expected at least one address")
+	}
+	for _, addr := range got {
+		if addr != "127.0.0.1:80" && addr != "[::1]:80" {
+			t.Fatalf("unexpected address %s", addr)
+		}
+	}
+}
+
+func TestSortAddrsInterleavesFamilies(t *testing.T) {
+	addrs := []string{"1.1.1.1:80", "2.2.2.2:80", "[::1]:80", "[::2]:80", "[::3]:80", "/tmp/bufconn.sock"}
+	want := []string{"[::1]:80", "1.1.1.1:80", "[::2]:80", "2.2.2.2:80", "[::3]:80", "/tmp/bufconn.sock"}
+	if got := sortAddrs("tcp", addrs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got := sortAddrs("tcp4", addrs); !reflect.DeepEqual(got, []string{"1.1.1.1:80", "2.2.2.2:80", "/tmp/bufconn.sock"}) {
+		t.Fatalf("expected only IPv4 addresses, got %v", got)
+	}
+	if got := sortAddrs("tcp6", addrs); !reflect.DeepEqual(got, []string{"[::1]:80", "[::2]:80", "[::3]:80", "/tmp/bufconn.sock"}) {
+		t.Fatalf("expected only IPv6 addresses, got %v", got)
+	}
+}
+
+func TestDialRaceMovesOnAfterFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.Addr().String()
+	dead.Close()
+	// The refused attempt should start the next one straight away, well before the fallback delay
+	defer func(d time.Duration) { FallbackDelay = d }(FallbackDelay)
+	FallbackDelay = time.Minute
+	c, err := dialRace("tcp", []string{deadAddr, l.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.RemoteAddr().String() != l.Addr().String() {
+		t.Fatalf("expected to reach %s, got %s", l.Addr(), c.RemoteAddr())
+	}
+}
+
+func TestDialRaceNoSuitableAddrs(t *testing.T) {
+	if _, err := dialRace("tcp6", []string{"127.0.0.1:80"}); err == nil {
+		t.Fatal("expected an error with no IPv6 addresses")
+	}
+}
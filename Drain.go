@@ -0,0 +1,25 @@
+package bufconn
+
+// handlerSwitch is a change of message handler waiting for the messages received before it to be handled
+type handlerSwitch struct {
+	// at is the number of messages which must be taken from the buffer before the switch happens
+	at      int
+	handler func(*C)
+}
+
+// SwitchHandler replaces the message handler at this point in the stream. Every message received before the call is still passed to the current handler, even if it is waiting in the buffer, and every message received after it is passed to f.
+// This is for moving between protocol phases, such as from an auth handler to the main one, when the remote may already have sent messages for the next phase. SetMessageHandler instead switches straight away, so a backlog is handled by the new handler.
+// It should only be called from within a handler or operation. The current handler should read one message per call, as a handler which reads past the switch point is given messages meant for f. Raw reads with Read are not counted as messages
+func (c *C) SwitchHandler(f func(*C)) {
+	c.Conn.updateWholeBuffer()
+	c.Conn.switches = append(c.Conn.switches, handlerSwitch{c.Conn.msgsReceived, f})
+	c.Conn.applySwitches()
+}
+
+// applySwitches makes any handler switches whose messages have all been taken from the buffer
+func (c *Conn) applySwitches() {
+	for len(c.switches) > 0 && c.msgsTaken >= c.switches[0].at {
+		c.SetMessageHandler(c.switches[0].handler)
+		c.switches = c.switches[1:]
+	}
+}
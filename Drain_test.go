@@ -0,0 +1,53 @@
+package bufconn_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// phaseHandler reads one message per call and sends it on got with the phase name before it
+func phaseHandler(name string, got chan<- string, next func(*bufconn.C)) func(*bufconn.C) {
+	return func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		got <- name + " " + msg
+		if next != nil {
+			c.SwitchHandler(next)
+		}
+	}
+}
+
+func TestSwitchHandlerDrainsBacklog(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	got := make(chan string, 20)
+	c := bufconn.NewConn(a, phaseHandler("old", got, nil), '\n')
+	defer c.Stop()
+	started, release, switched := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	c.QueueOperation(func(c *bufconn.C) {
+		close(started)
+		<-release
+		c.SwitchHandler(phaseHandler("new", got, nil))
+		close(switched)
+	})
+	<-started
+	b.Write([]byte("a\nb\n"))
+	waitFor(t, "the backlog to arrive", func() bool { return c.Buffered() == 4 })
+	close(release)
+	<-switched
+	b.Write([]byte("c\n"))
+	expectLines(t, got, "old a", "old b", "new c")
+}
+
+func TestSwitchHandlerFromHandler(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	got := make(chan string, 20)
+	c := bufconn.NewConn(a, phaseHandler("auth", got, phaseHandler("main", got, nil)), '\n')
+	defer c.Stop()
+	b.Write([]byte("login\n"))
+	expectLines(t, got, "auth login")
+	b.Write([]byte("x\ny\n"))
+	expectLines(t, got, "main x", "main y")
+}
@@ -0,0 +1,37 @@
+package bufconn
+
+import "errors"
+
+// ErrEmptyMessage is the error a connection stops with when it receives an empty message while using EmptyError, and is returned when writing one
+var ErrEmptyMessage = errors.New("empty message")
+
+// EmptyPolicy is what a connection does with empty messages, which are two delimiters in a row
+type EmptyPolicy int
+
+const (
+	// EmptyDeliver treats empty messages like any other: they are passed to the handler and read as empty strings, and writing one sends a lone delimiter. This is the default
+	EmptyDeliver EmptyPolicy = iota
+	// EmptySkip drops received empty messages before the handler sees them, and writing one sends nothing
+	EmptySkip
+	// EmptyError stops the connection with ErrEmptyMessage when one is received, and writing one returns ErrEmptyMessage
+	EmptyError
+)
+
+// SetEmptyMessages sets what the connection does with empty messages, in both directions
+func (c *Conn) SetEmptyMessages(p EmptyPolicy) {
+	c.updateSettings(func(s *settings) {
+		s.emptyPolicy = p
+	})
+}
+
+// emptyWrite checks what to do when writing an empty message, returning whether to write it, and the error to return otherwise
+func (c *Conn) emptyWrite() (bool, error) {
+	switch c.settings().emptyPolicy {
+	case EmptySkip:
+		return false, nil
+	case EmptyError:
+		return false, ErrEmptyMessage
+	default:
+		return true, nil
+	}
+}
@@ -0,0 +1,78 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// emptyConn creates a connection with the empty message policy, sending each message it receives (in brackets, so empty ones show) on the returned channel
+func emptyConn(t *testing.T, p bufconn.EmptyPolicy) (*bufconn.Conn, net.Conn, <-chan string) {
+	a, b := net.Pipe()
+	got := make(chan string, 20)
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		got <- "[" + msg + "]"
+	}, '\n')
+	c.SetEmptyMessages(p)
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	return c, b, got
+}
+
+func TestEmptyDeliver(t *testing.T) {
+	c, remote, got := emptyConn(t, bufconn.EmptyDeliver)
+	remote.Write([]byte("a\n\nb\n"))
+	expectLines(t, got, "[a]", "[]", "[b]")
+	sent := lines(remote)
+	if err := writeNow(c, ""); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, sent, "")
+}
+
+func TestEmptySkip(t *testing.T) {
+	c, remote, got := emptyConn(t, bufconn.EmptySkip)
+	remote.Write([]byte("a\n\n\nb\n"))
+	expectLines(t, got, "[a]", "[b]")
+	sent := lines(remote)
+	if err := writeNow(c, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeNow(c, "after"); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, sent, "after")
+}
+
+func TestEmptyError(t *testing.T) {
+	c, remote, got := emptyConn(t, bufconn.EmptyError)
+	if err := writeNow(c, ""); !errors.Is(err, bufconn.ErrEmptyMessage) {
+		t.Fatalf("expected writing an empty message to fail, got %v", err)
+	}
+	remote.Write([]byte("a\n\nb\n"))
+	expectLines(t, got, "[a]")
+	waitFor(t, "the connection to stop", c.IsStopped)
+	if !errors.Is(c.Err(), bufconn.ErrEmptyMessage) {
+		t.Fatalf("expected the connection to stop with ErrEmptyMessage, got %v", c.Err())
+	}
+	select {
+	case msg := <-got:
+		t.Fatalf("expected no messages after the empty one, got %s", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestEmptyPolicyChangedWhileRunning(t *testing.T) {
+	c, remote, got := emptyConn(t, bufconn.EmptyDeliver)
+	remote.Write([]byte("\n"))
+	expectLines(t, got, "[]")
+	c.SetEmptyMessages(bufconn.EmptySkip)
+	remote.Write([]byte("\nb\n"))
+	expectLines(t, got, "[b]")
+}
@@ -0,0 +1,98 @@
+package bufconn
+
+import "sync"
+
+// writeScheduler shares a limited number of write slots between connections, handing them out in the order they were asked for. It is safe for concurrent use
+type writeScheduler struct {
+	lock    sync.Mutex
+	slots   int
+	quantum int
+	active  int
+	waiting []chan struct{}
+}
+
+// SetFairWrites limits the number of managed connections writing to their sockets at once to writers, and takes turns between the connections waiting to write, so that a few busy connections can not starve the rest of a shared uplink.
+// Each turn writes at most quantum bytes, with longer writes going to the back of the line for each further quantum. Time spent waiting for a turn counts as a blocked write (see DetectWriteStall).
+// If writers is zero or less, writes are not scheduled. If quantum is zero or less, each write is done in one turn
+func (m *Manager) SetFairWrites(writers, quantum int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.writeSched = nil
+	if writers > 0 {
+		m.writeSched = &writeScheduler{slots: writers, quantum: quantum}
+	}
+	for _, c := range m.conns {
+		c.writeSched.Store(m.writeSched)
+	}
+}
+
+// writeSlots returns the scheduler the connection shares with the other connections of its Manager, or nil if it does not have one
+func (c *Conn) writeSlots() *writeScheduler {
+	s, _ := c.writeSched.Load().(*writeScheduler)
+	return s
+}
+
+// acquire waits for a write slot, returning false if done is closed first
+func (s *writeScheduler) acquire(done <-chan struct{}) bool {
+	s.lock.Lock()
+	if s.active < s.slots && len(s.waiting) == 0 {
+		s.active++
+		s.lock.Unlock()
+		return true
+	}
+	turn := make(chan struct{})
+	s.waiting = append(s.waiting, turn)
+	s.lock.Unlock()
+	select {
+	case <-turn:
+		return true
+	case <-done:
+	}
+	s.lock.Lock()
+	for i, w := range s.waiting {
+		if w == turn {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			s.lock.Unlock()
+			return false
+		}
+	}
+	s.lock.Unlock()
+	// The slot was handed over just as the connection stopped, so it must be passed on
+	s.release()
+	return false
+}
+
+// release gives up a write slot, handing it straight to the connection which has been waiting longest
+func (s *writeScheduler) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.waiting) > 0 {
+		close(s.waiting[0])
+		s.waiting = s.waiting[1:]
+		return
+	}
+	s.active--
+}
+
+// write writes bs to the connection's socket, taking turns with other connections sharing the scheduler. It does nothing special to a nil scheduler
+func (s *writeScheduler) write(c *Conn, bs []byte) (int, error) {
+	if s == nil {
+		return c.writeFull(bs)
+	}
+	written := 0
+	for {
+		chunk := bs[written:]
+		if s.quantum > 0 && len(chunk) > s.quantum {
+			chunk = chunk[:s.quantum]
+		}
+		if !s.acquire(c.done) {
+			return written, ErrStopped
+		}
+		n, err := c.writeFull(chunk)
+		s.release()
+		written += n
+		if err != nil || written >= len(bs) {
+			return written, err
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package bufconn_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestFairWritesTakeTurns(t *testing.T) {
+	m := bufconn.NewManager()
+	m.SetFairWrites(1, 4)
+	a, aRemote := managedConn(t, m)
+	b, bRemote := managedConn(t, m)
+	// a's remote is not reading, so a holds the only slot until it does
+	a.SendMsg("aaaaaaaa", bufconn.PriorityNormal)
+	time.Sleep(10 * time.Millisecond)
+	gotB := lines(bRemote)
+	b.SendMsg("bb", bufconn.PriorityNormal)
+	select {
+	case msg := <-gotB:
+		t.Fatalf("expected b to wait for a write slot, got %q", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+	// Once a has written its first quantum, b's turn comes before a's next one
+	if _, err := io.ReadFull(aRemote, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, gotB, "bb")
+	expectLines(t, lines(aRemote), "aaaa")
+}
+
+func TestFairWritesStoppedWaiterGivesUpTurn(t *testing.T) {
+	m := bufconn.NewManager()
+	m.SetFairWrites(1, 0)
+	a, aRemote := managedConn(t, m)
+	b, _ := managedConn(t, m)
+	c, cRemote := managedConn(t, m)
+	a.SendMsg("a", bufconn.PriorityNormal)
+	time.Sleep(10 * time.Millisecond)
+	b.SendMsg("b", bufconn.PriorityNormal)
+	time.Sleep(10 * time.Millisecond)
+	b.Stop()
+	gotC := lines(cRemote)
+	c.SendMsg("c", bufconn.PriorityNormal)
+	expectLines(t, lines(aRemote), "a")
+	expectLines(t, gotC, "c")
+}
+
+func TestFairWritesOff(t *testing.T) {
+	m := bufconn.NewManager()
+	m.SetFairWrites(1, 0)
+	m.SetFairWrites(0, 0)
+	a, _ := managedConn(t, m)
+	b, bRemote := managedConn(t, m)
+	a.SendMsg("a", bufconn.PriorityNormal)
+	time.Sleep(10 * time.Millisecond)
+	gotB := lines(bRemote)
+	b.SendMsg("b", bufconn.PriorityNormal)
+	expectLines(t, gotB, "b")
+}
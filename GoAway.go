@@ -0,0 +1,75 @@
+package bufconn
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GoAwayCode says why a remote is stopping a connection on purpose
+type GoAwayCode int
+
+const (
+	// GoAwayNormal means the remote is finished with the connection
+	GoAwayNormal GoAwayCode = iota
+	// GoAwayShutdown means the remote process is shutting down, and may not come back
+	GoAwayShutdown
+	// GoAwayRestart means the remote is restarting (for example to deploy a new version) and will be back shortly, so reconnecting straight away is pointless
+	GoAwayRestart
+	// GoAwayOverloaded means the remote is shedding load, so the connection should be retried later or elsewhere
+	GoAwayOverloaded
+	// GoAwayPolicy means the remote stopped the connection because this end broke one of its rules
+	GoAwayPolicy
+)
+
+func (code GoAwayCode) String() string {
+	switch code {
+	case GoAwayNormal:
+		return "normal"
+	case GoAwayShutdown:
+		return "shutdown"
+	case GoAwayRestart:
+		return "restart"
+	case GoAwayOverloaded:
+		return "overloaded"
+	case GoAwayPolicy:
+		return "policy"
+	default:
+		return "code " + strconv.Itoa(int(code))
+	}
+}
+
+// GoAwayError is the error a connection stops with when the remote announced it was going away, so that planned shutdowns can be told apart from crashes
+type GoAwayError struct {
+	Code   GoAwayCode
+	Reason string
+}
+
+func (e *GoAwayError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("remote going away (%v)", e.Code)
+	}
+	return fmt.Sprintf("remote going away (%v): %s", e.Code, e.Reason)
+}
+
+// GoAway tells the remote that this end is stopping the connection on purpose, and why, then stops it. It is queued with PriorityLow, so that messages already queued are normally written first.
+// The remote only understands the notice if it has called OnRemoteGoingAway, otherwise it just sees the connection close
+func (c *Conn) GoAway(code GoAwayCode, reason string) {
+	c.QueueOperationPriority(func(c *C) {
+		c.writeControl("goaway", strconv.Itoa(int(code))+" "+reason)
+		c.Stop()
+	}, PriorityLow)
+}
+
+// OnRemoteGoingAway makes the connection understand go-away notices from the remote (see GoAway). When one arrives, f is called if it is not nil, and the connection stops with a *GoAwayError
+func (c *Conn) OnRemoteGoingAway(f func(*GoAwayError)) {
+	c.onControl("goaway", func(c *C, args string) {
+		codeStr, reason, _ := strings.Cut(args, " ")
+		code, _ := strconv.Atoi(codeStr)
+		e := &GoAwayError{GoAwayCode(code), reason}
+		if f != nil {
+			f(e)
+		}
+		c.Conn.stopWithErr(e)
+	})
+}
@@ -0,0 +1,72 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestGoAwayStopsRemoteWithReason(t *testing.T) {
+	a, b := net.Pipe()
+	leaving := bufconn.NewConn(a, nil, '\n')
+	got := make(chan string, 10)
+	remote := bufconn.NewConn(b, func(c *bufconn.C) {
+		if msg, ok := c.TryReadMsg(); ok {
+			got <- msg
+		}
+	}, '\n')
+	defer remote.Stop()
+	notices := make(chan *bufconn.GoAwayError, 1)
+	remote.OnRemoteGoingAway(func(e *bufconn.GoAwayError) { notices <- e })
+	leaving.SendMsg("last words", bufconn.PriorityNormal)
+	leaving.GoAway(bufconn.GoAwayRestart, "deploying v2")
+	// Messages queued before the notice are written first
+	expectLines(t, got, "last words")
+	select {
+	case <-remote.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the remote to stop")
+	}
+	var goAway *bufconn.GoAwayError
+	if !errors.As(remote.Err(), &goAway) || goAway.Code != bufconn.GoAwayRestart || goAway.Reason != "deploying v2" {
+		t.Fatalf("expected a restart GoAwayError, got %v", remote.Err())
+	}
+	if e := <-notices; e.Code != bufconn.GoAwayRestart {
+		t.Fatalf("expected the callback to be told it was a restart, got %v", e.Code)
+	}
+	waitFor(t, "the leaving end to stop", leaving.IsStopped)
+}
+
+func TestGoAwayErrorMessage(t *testing.T) {
+	e := &bufconn.GoAwayError{Code: bufconn.GoAwayOverloaded}
+	if e.Error() != "remote going away (overloaded)" {
+		t.Fatalf("unexpected message %q", e.Error())
+	}
+	e.Reason = "too busy"
+	if e.Error() != "remote going away (overloaded): too busy" {
+		t.Fatalf("unexpected message %q", e.Error())
+	}
+	if s := bufconn.GoAwayCode(42).String(); s != "code 42" {
+		t.Fatalf("unexpected name for an unknown code %q", s)
+	}
+}
+
+func TestGoAwayClientWaitsBeforeReconnecting(t *testing.T) {
+	l, accepted := listener(t)
+	cl, err := bufconn.DialClient("tcp", []string{l.Addr().String()}, nil, '\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Stop()
+	cl.SetRetryDelay(200 * time.Millisecond)
+	server := bufconn.NewConn(accept(t, accepted), nil, '\n')
+	start := time.Now()
+	server.GoAway(bufconn.GoAwayRestart, "")
+	accept(t, accepted)
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Fatalf("expected the client to wait before reconnecting to a restarting server, took %v", d)
+	}
+}
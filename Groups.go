@@ -0,0 +1,139 @@
+package bufconn
+
+// PresenceEvent describes a connection joining or leaving a group
+type PresenceEvent struct {
+	Group  string
+	Joined bool
+	Conn   *Conn
+	// Metadata is a copy of the connection's metadata at the time of the event
+	Metadata map[string]any
+}
+
+// Join adds a managed connection to the group with the tag, such as "admins" or "region:eu". Connections can be in any number of groups, and leave them all when they stop
+func (m *Manager) Join(c *Conn, tag string) {
+	m.lock.Lock()
+	if _, ok := m.conns[c.ID()]; !ok || m.groups[tag][c.ID()] == c {
+		m.lock.Unlock()
+		return
+	}
+	if m.groups == nil {
+		m.groups = make(map[string]map[uint64]*Conn)
+	}
+	if m.groups[tag] == nil {
+		m.groups[tag] = make(map[uint64]*Conn)
+	}
+	m.groups[tag][c.ID()] = c
+	m.lock.Unlock()
+	m.announce([]PresenceEvent{{tag, true, c, c.AllMetadata()}})
+}
+
+// Leave removes the connection from the group with the tag
+func (m *Manager) Leave(c *Conn, tag string) {
+	m.lock.Lock()
+	left := m.leave(c, tag)
+	m.lock.Unlock()
+	if left {
+		m.announce([]PresenceEvent{{tag, false, c, c.AllMetadata()}})
+	}
+}
+
+// leave removes the connection from a group, forgetting the group if it is now empty, and returns whether it was in the group. The lock must be held
+func (m *Manager) leave(c *Conn, tag string) bool {
+	members := m.groups[tag]
+	if members[c.ID()] != c {
+		return false
+	}
+	delete(members, c.ID())
+	if len(members) == 0 {
+		delete(m.groups, tag)
+	}
+	return true
+}
+
+// OnPresence subscribes f to every join and leave of every group. Events are delivered in the goroutine which caused them, so f should return quickly.
+// The returned function unsubscribes f
+func (m *Manager) OnPresence(f func(PresenceEvent)) func() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.listeners == nil {
+		m.listeners = make(map[int]func(PresenceEvent))
+	}
+	id := m.nextListener
+	m.nextListener++
+	m.listeners[id] = f
+	return func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		delete(m.listeners, id)
+	}
+}
+
+// announce passes each event to every presence listener. The lock must not be held
+func (m *Manager) announce(events []PresenceEvent) {
+	m.lock.Lock()
+	listeners := make([]func(PresenceEvent), 0, len(m.listeners))
+	for _, f := range m.listeners {
+		listeners = append(listeners, f)
+	}
+	m.lock.Unlock()
+	for _, e := range events {
+		for _, f := range listeners {
+			f(e)
+		}
+	}
+}
+
+// Members returns every connection currently in the group with the tag
+func (m *Manager) Members(tag string) []*Conn {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	members := make([]*Conn, 0, len(m.groups[tag]))
+	for _, c := range m.groups[tag] {
+		members = append(members, c)
+	}
+	return members
+}
+
+// InGroup checks if the connection is in the group with the tag
+func (m *Manager) InGroup(c *Conn, tag string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.groups[tag][c.ID()] == c
+}
+
+// GroupsOf returns the tags of every group the connection is in
+func (m *Manager) GroupsOf(c *Conn) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	tags := make([]string, 0)
+	for tag, members := range m.groups {
+		if members[c.ID()] == c {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// SendToGroup queues the message to be written on every connection in the group, and returns how many it was queued on.
+// Connections which have stopped, or whose queue is full, are skipped rather than waited for, so one slow member can not hold up the rest
+func (m *Manager) SendToGroup(tag, msg string) int {
+	n := 0
+	for _, c := range m.Members(tag) {
+		if c.sendMsg(msg, PriorityNormal, false) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Broadcast queues the message to be written on every managed connection for which pred returns true (or every connection if pred is nil), and returns how many it was queued on.
+// As with SendToGroup, connections which have stopped or are full are skipped
+func (m *Manager) Broadcast(msg string, pred func(*Conn) bool) int {
+	n := 0
+	for _, c := range m.Conns() {
+		if (pred == nil || pred(c)) && c.sendMsg(msg, PriorityNormal, false) == nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,135 @@
+package bufconn_test
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestGroupsSendToGroup(t *testing.T) {
+	m := bufconn.NewManager()
+	admin, ra := managedConn(t, m)
+	user, ru := managedConn(t, m)
+	m.Join(admin, "admins")
+	m.Join(admin, "region:eu")
+	m.Join(user, "region:eu")
+	if n := m.SendToGroup("admins", "hello admins"); n != 1 {
+		t.Fatalf("expected 1 member to be sent to, got %d", n)
+	}
+	if n := m.SendToGroup("region:eu", "hello eu"); n != 2 {
+		t.Fatalf("expected 2 members to be sent to, got %d", n)
+	}
+	expectLines(t, lines(ra), "hello admins", "hello eu")
+	expectLines(t, lines(ru), "hello eu")
+	tags := m.GroupsOf(admin)
+	sort.Strings(tags)
+	if len(tags) != 2 || tags[0] != "admins" || tags[1] != "region:eu" {
+		t.Fatalf("unexpected groups %v", tags)
+	}
+	m.Leave(admin, "admins")
+	if m.InGroup(admin, "admins") || len(m.Members("admins")) != 0 {
+		t.Fatal("expected admin to have left")
+	}
+	if !m.InGroup(user, "region:eu") {
+		t.Fatal("expected user to still be in region:eu")
+	}
+}
+
+func TestGroupsLeftWhenStopped(t *testing.T) {
+	m := bufconn.NewManager()
+	c, _ := managedConn(t, m)
+	m.Join(c, "room")
+	c.Stop()
+	waitFor(t, "the group to be left", func() bool { return len(m.Members("room")) == 0 })
+	if n := m.SendToGroup("room", "anyone?"); n != 0 {
+		t.Fatalf("expected nobody to be sent to, got %d", n)
+	}
+}
+
+func TestGroupsJoinNeedsManagedConn(t *testing.T) {
+	m := bufconn.NewManager()
+	other := bufconn.NewManager()
+	c, _ := managedConn(t, other)
+	m.Join(c, "room")
+	if m.InGroup(c, "room") {
+		t.Fatal("expected a conn which is not managed to be unable to join")
+	}
+}
+
+func TestGroupsBroadcastFilter(t *testing.T) {
+	m := bufconn.NewManager()
+	c1, r1 := managedConn(t, m)
+	_, r2 := managedConn(t, m)
+	c1.SetIdentity("alice")
+	if n := m.Broadcast("for alice", func(c *bufconn.Conn) bool { return c.Identity() == "alice" }); n != 1 {
+		t.Fatalf("expected 1 conn to match, got %d", n)
+	}
+	if n := m.Broadcast("for everyone", nil); n != 2 {
+		t.Fatalf("expected every conn to match, got %d", n)
+	}
+	expectLines(t, lines(r1), "for alice", "for everyone")
+	expectLines(t, lines(r2), "for everyone")
+}
+
+// presenceLog records presence events as "+group" or "-group", with the conn's "name" metadata
+type presenceLog struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (p *presenceLog) record(e bufconn.PresenceEvent) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	sign := "-"
+	if e.Joined {
+		sign = "+"
+	}
+	p.events = append(p.events, fmt.Sprint(sign, e.Group, " ", e.Metadata["name"]))
+}
+
+func (p *presenceLog) get() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string{}, p.events...)
+}
+
+func TestPresenceEvents(t *testing.T) {
+	m := bufconn.NewManager()
+	log := &presenceLog{}
+	unsubscribe := m.OnPresence(log.record)
+	c, _ := managedConn(t, m)
+	c.SetMetadata("name", "alice")
+	m.Join(c, "room")
+	m.Join(c, "room")
+	m.Leave(c, "room")
+	m.Leave(c, "room")
+	m.Join(c, "lobby")
+	c.Stop()
+	waitFor(t, "the leave when stopped", func() bool { return len(log.get()) == 4 })
+	if got := fmt.Sprint(log.get()); got != "[+room alice -room alice +lobby alice -lobby alice]" {
+		t.Fatalf("unexpected events %s", got)
+	}
+	unsubscribe()
+	c2, _ := managedConn(t, m)
+	m.Join(c2, "room")
+	if n := len(log.get()); n != 4 {
+		t.Fatalf("expected no events after unsubscribing, got %d", n)
+	}
+}
+
+func TestPresenceMetadataIsSnapshot(t *testing.T) {
+	m := bufconn.NewManager()
+	events := make(chan bufconn.PresenceEvent, 1)
+	m.OnPresence(func(e bufconn.PresenceEvent) { events <- e })
+	c, _ := managedConn(t, m)
+	c.SetMetadata("name", "alice")
+	m.Join(c, "room")
+	e := <-events
+	c.SetMetadata("name", "bob")
+	if e.Metadata["name"] != "alice" || e.Conn != c {
+		t.Fatalf("expected the event to keep the metadata it was sent with, got %v", e.Metadata)
+	}
+}
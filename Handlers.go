@@ -0,0 +1,46 @@
+package bufconn
+
+// BatchHandler creates a message handler which is passed every complete message in the buffer at once, rather than being called once per message.
+// This saves overhead when many small messages arrive together. The messages do NOT include the delimeter
+func BatchHandler(f func(c *C, msgs []string)) func(*C) {
+	return func(c *C) {
+		f(c, c.readBurst())
+	}
+}
+
+// BytesHandler creates a message handler which reads the next complete message itself and passes it to f, so that f can not forget to read it and leave the buffer out of step.
+// f is called once per message, and may keep the slice. The message does NOT include the delimeter
+func BytesHandler(f func(c *C, msg []byte)) func(*C) {
+	return func(c *C) {
+		if msg, ok := c.takeBytes(AuditOK); ok {
+			f(c, msg)
+		}
+	}
+}
+
+// Chain creates a message handler which reads each message and passes it along the links in order, so that concerns such as logging and auth checks can be written once and composed.
+// Each link is given a next function which passes the message on to the rest of the chain, and can drop the message by not calling it. handler is at the end of the chain, and may be nil
+func Chain(handler func(c *C, msg string), links ...func(c *C, msg string, next func())) func(*C) {
+	return func(c *C) {
+		for {
+			msg, ok := c.TryReadMsg()
+			if !ok {
+				return
+			}
+			runChain(c, msg, handler, links)
+		}
+	}
+}
+
+// runChain passes the message to the first link, with a next function which runs the rest of the chain
+func runChain(c *C, msg string, handler func(c *C, msg string), links []func(c *C, msg string, next func())) {
+	if len(links) == 0 {
+		if handler != nil {
+			handler(c, msg)
+		}
+		return
+	}
+	links[0](c, msg, func() {
+		runChain(c, msg, handler, links[1:])
+	})
+}
@@ -0,0 +1,132 @@
+package bufconn_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestBatchHandlerGetsEveryBufferedMessage(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	batches := make(chan []string, 10)
+	c := bufconn.NewConn(a, bufconn.BatchHandler(func(c *bufconn.C, msgs []string) {
+		batches <- msgs
+	}), '\n')
+	defer c.Stop()
+	release, started := make(chan struct{}), make(chan struct{})
+	c.QueueOperation(func(*bufconn.C) {
+		close(started)
+		<-release
+	})
+	<-started
+	go b.Write([]byte("a\nb\nc\npart"))
+	waitFor(t, "messages to arrive", func() bool { return c.Buffered() == 10 })
+	close(release)
+	select {
+	case msgs := <-batches:
+		if got := fmt.Sprint(msgs); got != "[a b c]" {
+			t.Fatalf("expected one batch of the complete messages, got %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a batch")
+	}
+	b.Write([]byte("ial\n"))
+	select {
+	case msgs := <-batches:
+		if got := fmt.Sprint(msgs); got != "[partial]" {
+			t.Fatalf("expected the finished message, got %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a second batch")
+	}
+}
+
+func TestChainRunsLinksInOrder(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	got := make(chan string, 20)
+	link := func(name string) func(c *bufconn.C, msg string, next func()) {
+		return func(c *bufconn.C, msg string, next func()) {
+			got <- name + " before " + msg
+			next()
+			got <- name + " after " + msg
+		}
+	}
+	c := bufconn.NewConn(a, bufconn.Chain(func(c *bufconn.C, msg string) {
+		got <- "handler " + msg
+	}, link("first"), link("second")), '\n')
+	defer c.Stop()
+	b.Write([]byte("x\n"))
+	expectLines(t, got, "first before x", "second before x", "handler x", "second after x", "first after x")
+}
+
+func TestChainLinkDropsMessage(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	got := make(chan string, 20)
+	c := bufconn.NewConn(a, bufconn.Chain(func(c *bufconn.C, msg string) {
+		got <- msg
+	}, func(c *bufconn.C, msg string, next func()) {
+		if msg != "drop" {
+			next()
+		}
+	}), '\n')
+	defer c.Stop()
+	b.Write([]byte("a\ndrop\nb\n"))
+	expectLines(t, got, "a", "b")
+}
+
+func TestChainWithoutHandler(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	got := make(chan string, 20)
+	c := bufconn.NewConn(a, bufconn.Chain(nil, func(c *bufconn.C, msg string, next func()) {
+		got <- msg
+		next()
+	}), '\n')
+	defer c.Stop()
+	b.Write([]byte("a\nb\n"))
+	expectLines(t, got, "a", "b")
+}
+
+func TestBytesHandlerMessagesCanBeKept(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	got := make(chan []byte, 10)
+	c := bufconn.NewConn(a, bufconn.BytesHandler(func(c *bufconn.C, msg []byte) {
+		got <- msg
+	}), '\n')
+	defer c.Stop()
+	b.Write([]byte("first\nsecond\n"))
+	var kept [][]byte
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-got:
+			kept = append(kept, msg)
+		case <-time.After(time.Second):
+			t.Fatal("expected a message")
+		}
+	}
+	b.Write([]byte("overwrite\n"))
+	<-got
+	if s := fmt.Sprintf("%s", kept); s != "[first second]" {
+		t.Fatalf("expected the kept messages to be unchanged, got %s", s)
+	}
+}
+
+func TestSetBytesMessageHandler(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	got := make(chan string, 10)
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	c.SetBytesMessageHandler(func(c *bufconn.C, msg []byte) {
+		got <- string(msg)
+	})
+	b.Write([]byte("a\n\nb\n"))
+	expectLines(t, got, "a", "", "b")
+}
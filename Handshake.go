@@ -0,0 +1,207 @@
+package bufconn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handshakeMagic starts every handshake message, so that a remote which is not doing a handshake is noticed straight away
+const handshakeMagic = "BUFCONN"
+
+// maxHandshakeSize is the longest handshake message that will be read from the remote
+const maxHandshakeSize = 4096
+
+// Handshake describes what the two ends of a connection tell each other before any messages are handled. Both ends must use a handshake
+type Handshake struct {
+	// Versions are the protocol versions this end supports. Versions must be above zero. If empty, no version is agreed on
+	Versions []int
+	// Capabilities are optional features this end supports. Only the ones supported by both ends are enabled. They must not contain spaces or the delimiter
+	Capabilities []string
+	// Dictionaries are compression dictionaries this end has, most preferred first. If both ends have a dictionary with the same ID, every message is compressed with the first such one (see Conn.Compression).
+	// Compressed messages are base64 encoded, so the delimiter must not be a letter, digit, '+' or '/'
+	Dictionaries []Dictionary
+	// MessageHeaders adds a one byte header to every message saying how it was sent (see MessageFlags), if both ends enable it. This lets compression be skipped for messages it would not help.
+	// The delimiter must not be between '`' and 'o'
+	MessageHeaders bool
+	// Check is called with the versions the remote supports and the highest version both ends support (zero if there is none).
+	// Returning an error rejects the remote. If nil, the remote is only rejected when there is no common version
+	Check func(remote []int, agreed int) error
+	// Timeout is how long the remote has to complete the handshake. If zero, then no timeout will be used
+	Timeout time.Duration
+}
+
+// VersionError is the error returned when the two ends of a connection have no protocol version in common
+type VersionError struct {
+	Local  []int
+	Remote []int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("no common protocol version (local supports %v, remote supports %v)", e.Local, e.Remote)
+}
+
+// NewConnHandshake performs the handshake over c and then creates a new Conn, just like NewConn. The handler is not called for the handshake messages.
+// If the handshake fails, c is closed and the error is returned
+func NewConnHandshake(c net.Conn, handler func(*C), delim byte, h Handshake) (*Conn, error) {
+	conn, err := newConnHandshake(c, handler, delim, h)
+	if err != nil {
+		return nil, err
+	}
+	conn.start()
+	return conn, nil
+}
+
+// newConnHandshake performs the handshake over c and then creates a Conn without starting its goroutines. If the handshake fails, c is closed
+func newConnHandshake(c net.Conn, handler func(*C), delim byte, h Handshake) (*Conn, error) {
+	version, caps, err := h.perform(c, delim)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	conn := newConn(c, handler, delim)
+	conn.protocolVersion = version
+	conn.capabilities = caps
+	if d, ok := pickDictionary(h.Dictionaries, caps); ok {
+		conn.compressor = newCompressor(d)
+	}
+	conn.headers = conn.HasCapability(headersCapability)
+	return conn, nil
+}
+
+// perform sends this end's handshake message and reads the remote's, then agrees on a version and the capabilities both ends support
+func (h Handshake) perform(c net.Conn, delim byte) (int, []string, error) {
+	if h.Timeout != 0 {
+		c.SetDeadline(time.Now().Add(h.Timeout))
+		defer c.SetDeadline(time.Time{})
+	}
+	// Write at the same time as reading, as some connections (such as net.Pipe) block writes until the other end reads
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := c.Write(append([]byte(h.encode()), delim))
+		writeErr <- err
+	}()
+	msg, err := readRawMsg(c, delim, maxHandshakeSize)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := <-writeErr; err != nil {
+		return 0, nil, err
+	}
+	remote, remoteCaps, err := decodeHandshake(msg)
+	if err != nil {
+		return 0, nil, err
+	}
+	caps := make([]string, 0)
+	for _, lc := range h.allCapabilities() {
+		for _, rc := range remoteCaps {
+			if lc == rc {
+				caps = append(caps, lc)
+				break
+			}
+		}
+	}
+	agreed := 0
+	for _, v := range h.Versions {
+		for _, rv := range remote {
+			if v == rv && v > agreed {
+				agreed = v
+			}
+		}
+	}
+	if h.Check != nil {
+		return agreed, caps, h.Check(remote, agreed)
+	}
+	if agreed == 0 && len(h.Versions) > 0 {
+		return 0, nil, &VersionError{h.Versions, remote}
+	}
+	return agreed, caps, nil
+}
+
+// allCapabilities returns the capabilities to advertise, including one for each dictionary
+func (h Handshake) allCapabilities() []string {
+	caps := append([]string{}, h.Capabilities...)
+	for _, d := range h.Dictionaries {
+		caps = append(caps, dictCapabilityPrefix+d.ID)
+	}
+	if h.MessageHeaders {
+		caps = append(caps, headersCapability)
+	}
+	return caps
+}
+
+// encode creates the handshake message for this end, for example "BUFCONN v1 v2 c:compression"
+func (h Handshake) encode() string {
+	parts := []string{handshakeMagic}
+	for _, v := range h.Versions {
+		parts = append(parts, "v"+strconv.Itoa(v))
+	}
+	for _, c := range h.allCapabilities() {
+		parts = append(parts, "c:"+c)
+	}
+	return strings.Join(parts, " ")
+}
+
+// decodeHandshake reads the versions and capabilities out of a remote's handshake message. Parts which are not understood are ignored, so that newer remotes can send more information
+func decodeHandshake(msg string) ([]int, []string, error) {
+	parts := strings.Fields(msg)
+	if len(parts) == 0 || parts[0] != handshakeMagic {
+		return nil, nil, errors.New("remote did not send a handshake")
+	}
+	versions := make([]int, 0)
+	caps := make([]string, 0)
+	for _, p := range parts[1:] {
+		switch {
+		case strings.HasPrefix(p, "c:"):
+			caps = append(caps, p[2:])
+		case strings.HasPrefix(p, "v"):
+			v, err := strconv.Atoi(p[1:])
+			if err != nil {
+				continue
+			}
+			versions = append(versions, v)
+		}
+	}
+	return versions, caps, nil
+}
+
+// readRawMsg reads from c one byte at a time until the delimiter, so that nothing after the message is consumed
+func readRawMsg(c net.Conn, delim byte, max int) (string, error) {
+	buf := make([]byte, 0)
+	b := make([]byte, 1)
+	for {
+		if _, err := c.Read(b); err != nil {
+			return "", err
+		}
+		if b[0] == delim {
+			return string(buf), nil
+		}
+		if len(buf) >= max {
+			return "", errors.New("handshake message too long")
+		}
+		buf = append(buf, b[0])
+	}
+}
+
+// ProtocolVersion returns the protocol version agreed on during the handshake, or zero if the connection was created without one
+func (c *Conn) ProtocolVersion() int {
+	return c.protocolVersion
+}
+
+// Capabilities returns the capabilities which both ends advertised during the handshake
+func (c *Conn) Capabilities() []string {
+	return append([]string{}, c.capabilities...)
+}
+
+// HasCapability checks if both ends advertised the capability during the handshake
+func (c *Conn) HasCapability(name string) bool {
+	for _, have := range c.capabilities {
+		if have == name {
+			return true
+		}
+	}
+	return false
+}
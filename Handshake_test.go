@@ -0,0 +1,111 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// handshakeBoth performs a handshake between two ends with different settings, returning each end's connection and error
+func handshakeBoth(t *testing.T, ha, hb bufconn.Handshake) (a, b *bufconn.Conn, errA, errB error) {
+	t.Helper()
+	pa, pb := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		a, errA = bufconn.NewConnHandshake(pa, nil, '\n', ha)
+		close(done)
+	}()
+	b, errB = bufconn.NewConnHandshake(pb, nil, '\n', hb)
+	<-done
+	t.Cleanup(func() {
+		for _, c := range []*bufconn.Conn{a, b} {
+			if c != nil {
+				c.Stop()
+			}
+		}
+	})
+	return a, b, errA, errB
+}
+
+func TestHandshakeAgreesHighestCommonVersion(t *testing.T) {
+	a, b, errA, errB := handshakeBoth(t, bufconn.Handshake{Versions: []int{1, 2, 3}}, bufconn.Handshake{Versions: []int{2, 3, 4}})
+	if errA != nil || errB != nil {
+		t.Fatal(errA, errB)
+	}
+	if a.ProtocolVersion() != 3 || b.ProtocolVersion() != 3 {
+		t.Fatalf("expected version 3, got %d and %d", a.ProtocolVersion(), b.ProtocolVersion())
+	}
+}
+
+func TestHandshakeNoCommonVersion(t *testing.T) {
+	_, _, errA, errB := handshakeBoth(t, bufconn.Handshake{Versions: []int{1}}, bufconn.Handshake{Versions: []int{2}})
+	for _, err := range []error{errA, errB} {
+		var verr *bufconn.VersionError
+		if !errors.As(err, &verr) {
+			t.Fatalf("expected a VersionError, got %v", err)
+		}
+	}
+}
+
+func TestHandshakeCheckCanReject(t *testing.T) {
+	reject := errors.New("too old")
+	check := func(remote []int, agreed int) error {
+		if agreed < 2 {
+			return reject
+		}
+		return nil
+	}
+	_, b, errA, errB := handshakeBoth(t, bufconn.Handshake{Versions: []int{1, 2}, Check: check}, bufconn.Handshake{Versions: []int{1}})
+	if !errors.Is(errA, reject) {
+		t.Fatalf("expected the check's error, got %v", errA)
+	}
+	if errB != nil || b.ProtocolVersion() != 1 {
+		t.Fatalf("expected the other end to agree on version 1, got %v", errB)
+	}
+}
+
+func TestHandshakeRejectsNonHandshakeRemote(t *testing.T) {
+	pa, pb := net.Pipe()
+	defer pb.Close()
+	go pb.Write([]byte("hello\n"))
+	go func() {
+		buf := make([]byte, 64)
+		pb.Read(buf)
+	}()
+	if _, err := bufconn.NewConnHandshake(pa, nil, '\n', bufconn.Handshake{Versions: []int{1}}); err == nil {
+		t.Fatal("expected an error from a remote which does not handshake")
+	}
+}
+
+func TestHandshakeEnablesSharedCapabilities(t *testing.T) {
+	a, b, errA, errB := handshakeBoth(t,
+		bufconn.Handshake{Capabilities: []string{"zstd", "acks", "trace"}},
+		bufconn.Handshake{Capabilities: []string{"trace", "acks", "batch"}},
+	)
+	if errA != nil || errB != nil {
+		t.Fatal(errA, errB)
+	}
+	for _, c := range []*bufconn.Conn{a, b} {
+		if !c.HasCapability("acks") || !c.HasCapability("trace") {
+			t.Fatalf("expected acks and trace to be enabled, got %v", c.Capabilities())
+		}
+		if c.HasCapability("zstd") || c.HasCapability("batch") {
+			t.Fatalf("expected one-sided capabilities to be disabled, got %v", c.Capabilities())
+		}
+	}
+	if a.ProtocolVersion() != 0 {
+		t.Fatalf("expected no version without any versions, got %d", a.ProtocolVersion())
+	}
+}
+
+func TestHandshakeNoCapabilities(t *testing.T) {
+	a, _, errA, errB := handshakeBoth(t, bufconn.Handshake{}, bufconn.Handshake{Capabilities: []string{"acks"}})
+	if errA != nil || errB != nil {
+		t.Fatal(errA, errB)
+	}
+	if len(a.Capabilities()) != 0 {
+		t.Fatalf("expected no capabilities, got %v", a.Capabilities())
+	}
+}
@@ -0,0 +1,85 @@
+package bufconn
+
+import "fmt"
+
+// headersCapability is the handshake capability which enables per-message headers
+const headersCapability = "headers"
+
+// headerBase is added to a message's flags to make its header byte. The header is always between '`' and 'o', so it never looks like the start of a control frame
+const headerBase = 0x60
+
+// MessageFlags describe how a message was sent. When both ends enable MessageHeaders in their handshake, they are sent as a one byte header before every message
+type MessageFlags byte
+
+const (
+	// FlagCompressed means the message is compressed with the connection's dictionary. Messages which compression would make longer are sent without it
+	FlagCompressed MessageFlags = 1 << iota
+	// FlagEncrypted is reserved for encrypted messages. This library does not encrypt messages, so receiving one stops the connection
+	FlagEncrypted
+	// FlagPriority means the message was sent with PriorityHigh
+	FlagPriority
+	// FlagMoreFragments is reserved for messages split into several parts. This library does not split messages, so receiving one stops the connection
+	FlagMoreFragments
+	allFlags = FlagCompressed | FlagEncrypted | FlagPriority | FlagMoreFragments
+)
+
+// unsupportedFlags are the flags for layers this library does not have
+const unsupportedFlags = FlagEncrypted | FlagMoreFragments
+
+// HeaderError is the error a connection stops with when it receives a message with a missing or unsupported header
+type HeaderError struct {
+	Header byte
+}
+
+func (e *HeaderError) Error() string {
+	if e.Header == 0 {
+		return "message has no header"
+	}
+	return fmt.Sprintf("message has unsupported header %q", e.Header)
+}
+
+// Headers checks if the connection's messages have headers, which is agreed during the handshake
+func (c *Conn) Headers() bool {
+	return c.headers
+}
+
+// MessageFlags returns the flags of the last message read by the handler or operation, or zero if the connection does not use headers
+func (c *C) MessageFlags() MessageFlags {
+	return c.Conn.lastFlags
+}
+
+// encodeMsg compresses a message to be written if the connection uses compression, and adds its header if the connection uses headers.
+// With headers, a message is only compressed if that makes it shorter
+func (c *Conn) encodeMsg(msg []byte, flags MessageFlags) []byte {
+	if c.compressor != nil {
+		compressed := c.compressor.compress(msg)
+		if !c.headers || len(compressed) < len(msg) {
+			msg = compressed
+			flags |= FlagCompressed
+		}
+	}
+	if !c.headers {
+		return msg
+	}
+	return append([]byte{headerBase | byte(flags)}, msg...)
+}
+
+// readHeader splits the header from a received message
+func (c *Conn) readHeader(msg []byte) (MessageFlags, []byte, error) {
+	if len(msg) == 0 {
+		return 0, nil, &HeaderError{}
+	}
+	flags := MessageFlags(msg[0] - headerBase)
+	if msg[0] < headerBase || flags&^allFlags != 0 || flags&unsupportedFlags != 0 {
+		return 0, nil, &HeaderError{msg[0]}
+	}
+	return flags, msg[1:], nil
+}
+
+// priorityFlags returns the flags to send a message queued with the priority with
+func priorityFlags(p Priority) MessageFlags {
+	if p >= PriorityHigh {
+		return FlagPriority
+	}
+	return 0
+}
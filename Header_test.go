@@ -0,0 +1,99 @@
+package bufconn_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// headerConn creates a connection which agreed to use headers (and the test dictionary, if compress is true) with a raw remote, sending each message it receives with its flags on the returned channel.
+// Everything the connection writes after the handshake is sent on the other channel, headers included
+func headerConn(t *testing.T, compress bool) (*bufconn.Conn, net.Conn, <-chan string, <-chan string) {
+	a, b := net.Pipe()
+	sent := lines(b)
+	h := bufconn.Handshake{MessageHeaders: true}
+	if compress {
+		h.Dictionaries = []bufconn.Dictionary{testDictionary}
+		go b.Write([]byte("BUFCONN c:headers c:dict:test\n"))
+	} else {
+		go b.Write([]byte("BUFCONN c:headers\n"))
+	}
+	got := make(chan string, 20)
+	c, err := bufconn.NewConnHandshake(a, func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		got <- fmt.Sprint(c.MessageFlags(), ":", msg)
+	}, '\n', h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	if hs := <-sent; !strings.Contains(hs, "c:headers") {
+		t.Fatalf("expected the handshake to offer headers, got %q", hs)
+	}
+	return c, b, got, sent
+}
+
+func TestHeadersAgreed(t *testing.T) {
+	a, b, _ := handshakePair(t, bufconn.Handshake{MessageHeaders: true})
+	if !a.Headers() || !b.Headers() {
+		t.Fatal("expected both ends to use headers")
+	}
+	c, _, _ := handshakePair(t, bufconn.Handshake{})
+	if c.Headers() {
+		t.Fatal("expected no headers without the capability")
+	}
+}
+
+func TestHeadersWritten(t *testing.T) {
+	c, _, _, sent := headerConn(t, false)
+	c.SendMsg("normal", bufconn.PriorityNormal)
+	expectLines(t, sent, "`normal")
+	c.SendMsg("urgent", bufconn.PriorityHigh)
+	expectLines(t, sent, "durgent")
+}
+
+func TestHeadersRead(t *testing.T) {
+	_, remote, got, _ := headerConn(t, false)
+	remote.Write([]byte("`plain\ndurgent\n"))
+	expectLines(t, got, "0:plain", "4:urgent")
+}
+
+func TestHeadersUnsupportedStops(t *testing.T) {
+	for _, tc := range []struct {
+		msg    string
+		header byte
+	}{{"bsecret\n", 'b'}, {"hpart\n", 'h'}, {"zbad\n", 'z'}, {"\n", 0}} {
+		c, remote, _, _ := headerConn(t, false)
+		remote.Write([]byte(tc.msg))
+		waitFor(t, "the connection to stop", c.IsStopped)
+		var headerErr *bufconn.HeaderError
+		if !errors.As(c.Err(), &headerErr) || headerErr.Header != tc.header {
+			t.Fatalf("expected a HeaderError for %q, got %v", tc.header, c.Err())
+		}
+	}
+}
+
+func TestHeadersSkipCompressionWhichDoesNotHelp(t *testing.T) {
+	c, _, _, sent := headerConn(t, true)
+	c.SendMsg("zq", bufconn.PriorityNormal)
+	expectLines(t, sent, "`zq")
+	c.SendMsg("the quick brown fox jumps", bufconn.PriorityNormal)
+	if msg := <-sent; msg[0] != 'a' || strings.Contains(msg, "fox") {
+		t.Fatalf("expected a compressed message, got %q", msg)
+	}
+}
+
+func TestHeadersCompressionRoundTrip(t *testing.T) {
+	a, _, received := handshakePair(t, bufconn.Handshake{MessageHeaders: true, Dictionaries: []bufconn.Dictionary{testDictionary}})
+	for _, msg := range []string{"the quick brown fox jumps", "zq"} {
+		a.SendMsg(msg, bufconn.PriorityNormal)
+		expectLines(t, received, msg)
+	}
+}
@@ -0,0 +1,28 @@
+package bufconn
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrHeartbeatTimeout is the error a connection stops with when nothing has been received from the remote for longer than its heartbeat timeout
+var ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
+// SetHeartbeat checks that the remote is still alive, by sending it a ping whenever nothing has been received from it for interval, and stopping the connection with ErrHeartbeatTimeout once nothing has been received for timeout.
+// Any message from the remote counts as a sign of life, so pings are only sent while the connection is quiet. The remote must answer pings, by calling SetHeartbeat or SetTimeSync itself.
+// Pings also update the clock offset estimate (see ClockOffset)
+func (c *Conn) SetHeartbeat(interval, timeout time.Duration) {
+	c.answerPings()
+	lastPing := time.Time{}
+	c.every(checkInterval(interval), func(c *C) {
+		quiet := time.Since(c.lastReceived)
+		if quiet >= timeout {
+			c.Conn.stopWithErr(ErrHeartbeatTimeout)
+			return
+		}
+		if quiet >= interval && time.Since(lastPing) >= interval {
+			lastPing = time.Now()
+			c.ping()
+		}
+	})
+}
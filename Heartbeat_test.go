@@ -0,0 +1,69 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestHeartbeatKeepsQuietConnAlive(t *testing.T) {
+	a, b := net.Pipe()
+	ca := bufconn.NewConn(a, nil, '\n')
+	defer ca.Stop()
+	cb := bufconn.NewConn(b, nil, '\n')
+	defer cb.Stop()
+	ca.SetHeartbeat(20*time.Millisecond, 100*time.Millisecond)
+	cb.SetHeartbeat(20*time.Millisecond, 100*time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	if ca.IsStopped() || cb.IsStopped() {
+		t.Fatalf("expected pings to keep both ends alive, got %v and %v", ca.Err(), cb.Err())
+	}
+}
+
+func TestHeartbeatTimesOutSilentRemote(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	// The remote reads the pings but never answers them
+	lines(b)
+	c.SetHeartbeat(10*time.Millisecond, 50*time.Millisecond)
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the connection to stop")
+	}
+	if !errors.Is(c.Err(), bufconn.ErrHeartbeatTimeout) {
+		t.Fatalf("expected ErrHeartbeatTimeout, got %v", c.Err())
+	}
+}
+
+func TestHeartbeatSuppressedByTraffic(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	var pings int32
+	go func() {
+		for msg := range lines(b) {
+			if strings.HasPrefix(msg, "BUFCONN-CTL ping") {
+				atomic.AddInt32(&pings, 1)
+			}
+		}
+	}()
+	c.SetHeartbeat(40*time.Millisecond, time.Second)
+	// Anything from the remote is a sign of life, so no pings are needed while it keeps talking
+	for i := 0; i < 20; i++ {
+		b.Write([]byte("chatter\n"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&pings); n != 0 {
+		t.Fatalf("expected no pings while the remote was sending, got %d", n)
+	}
+	waitFor(t, "a ping once the remote goes quiet", func() bool { return atomic.LoadInt32(&pings) > 0 })
+}
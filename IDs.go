@@ -0,0 +1,85 @@
+package bufconn
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	mrand "math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// IDSource generates the message IDs and random bytes used by a connection, such as registry trace IDs. Implementations must be safe for concurrent use
+type IDSource interface {
+	// NextID returns the next message ID. IDs count up, and are never repeated on one connection
+	NextID() uint64
+	// Random fills bs with random bytes
+	Random(bs []byte) error
+}
+
+// SetIDSource replaces where the connection's IDs and random bytes come from, for example with SeededIDs to make them predictable in tests. IDs already handed out are not affected.
+// If ids is nil, the default is used, which counts up from one and reads crypto/rand
+func (c *Conn) SetIDSource(ids IDSource) {
+	if ids == nil {
+		ids = &cryptoIDs{}
+	}
+	c.updateSettings(func(s *settings) {
+		s.ids = ids
+	})
+}
+
+// NextID returns the connection's next message ID, for correlating requests with their responses. IDs count up from one by default
+func (c *Conn) NextID() uint64 {
+	return c.settings().ids.NextID()
+}
+
+// Nonce returns n random bytes which can be used as a nonce. It panics if no random bytes can be read, as with the default source this means the system is broken
+func (c *Conn) Nonce(n int) []byte {
+	bs := make([]byte, n)
+	if err := c.settings().ids.Random(bs); err != nil {
+		panic(err)
+	}
+	return bs
+}
+
+// newTraceID creates a random trace ID from the connection's IDSource
+func (c *Conn) newTraceID() string {
+	return hex.EncodeToString(c.Nonce(8))
+}
+
+// cryptoIDs is the default IDSource
+type cryptoIDs struct {
+	last uint64
+}
+
+func (s *cryptoIDs) NextID() uint64 {
+	return atomic.AddUint64(&s.last, 1)
+}
+
+func (s *cryptoIDs) Random(bs []byte) error {
+	_, err := rand.Read(bs)
+	return err
+}
+
+// SeededIDs returns an IDSource whose random bytes always come out the same for the same seed, so tests can check exact IDs and nonces. IDs count up from one.
+// The bytes are not secure, so it must not be used outside of tests
+func SeededIDs(seed int64) IDSource {
+	return &seededIDs{rand: mrand.New(mrand.NewSource(seed))}
+}
+
+// seededIDs is the IDSource returned by SeededIDs
+type seededIDs struct {
+	last uint64
+	lock sync.Mutex
+	rand *mrand.Rand
+}
+
+func (s *seededIDs) NextID() uint64 {
+	return atomic.AddUint64(&s.last, 1)
+}
+
+func (s *seededIDs) Random(bs []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := s.rand.Read(bs)
+	return err
+}
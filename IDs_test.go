@@ -0,0 +1,72 @@
+package bufconn_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// pipeConn creates a connection with no handler over a pipe, returning it and the remote end
+func pipeConn(t *testing.T) (*bufconn.Conn, net.Conn) {
+	a, b := net.Pipe()
+	c := bufconn.NewConn(a, nil, '\n')
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	return c, b
+}
+
+func TestIDsCountUpPerConnection(t *testing.T) {
+	c1, _ := pipeConn(t)
+	c2, _ := pipeConn(t)
+	for want := uint64(1); want <= 3; want++ {
+		if id := c1.NextID(); id != want {
+			t.Fatalf("expected ID %d, got %d", want, id)
+		}
+	}
+	if id := c2.NextID(); id != 1 {
+		t.Fatalf("expected each connection to count from one, got %d", id)
+	}
+	if n1, n2 := c1.Nonce(16), c1.Nonce(16); len(n1) != 16 || bytes.Equal(n1, n2) {
+		t.Fatalf("expected different 16 byte nonces, got %x and %x", n1, n2)
+	}
+}
+
+func TestSeededIDsArePredictable(t *testing.T) {
+	c1, _ := pipeConn(t)
+	c2, _ := pipeConn(t)
+	c1.NextID()
+	c1.SetIDSource(bufconn.SeededIDs(42))
+	c2.SetIDSource(bufconn.SeededIDs(42))
+	if id := c1.NextID(); id != 1 {
+		t.Fatalf("expected a new source to count from one, got %d", id)
+	}
+	if n1, n2 := c1.Nonce(8), c2.Nonce(8); !bytes.Equal(n1, n2) {
+		t.Fatalf("expected the same nonces for the same seed, got %x and %x", n1, n2)
+	}
+	c1.SetIDSource(nil)
+	if id := c1.NextID(); id != 1 {
+		t.Fatalf("expected the default source to count from one, got %d", id)
+	}
+}
+
+func TestSeededIDsMakeTracesPredictable(t *testing.T) {
+	r := bufconn.NewRegistry()
+	bufconn.RegisterJSON[greeting](r, "greeting", nil)
+	c, remote := pipeConn(t)
+	c.SetIDSource(bufconn.SeededIDs(7))
+	got := lines(remote)
+	c.QueueOperation(func(c *bufconn.C) { r.Write(c, greeting{"one"}) })
+	var env bufconn.Envelope
+	json.Unmarshal([]byte(<-got), &env)
+	want := make([]byte, 8)
+	bufconn.SeededIDs(7).Random(want)
+	if env.Trace != hex.EncodeToString(want) {
+		t.Fatalf("expected the trace %x, got %q", want, env.Trace)
+	}
+}
@@ -0,0 +1,33 @@
+package bufconn
+
+import "crypto/tls"
+
+// SetIdentity sets who the remote is, for example after it has logged in. This is what is passed to authorizers
+func (c *Conn) SetIdentity(id string) {
+	c.updateSettings(func(s *settings) {
+		s.identity = id
+	})
+}
+
+// Identity returns who the remote is. This is the identity set with SetIdentity, or if there is none and the connection is a *tls.Conn, the common name of the remote's verified certificate.
+// It returns an empty string if the remote is not known
+func (c *Conn) Identity() string {
+	if id := c.settings().identity; id != "" {
+		return id
+	}
+	if tc, ok := c.socket().Conn.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+			return state.VerifiedChains[0][0].Subject.CommonName
+		}
+	}
+	return ""
+}
+
+// ServerName returns the server name the remote asked for with SNI, or an empty string if the connection is not a *tls.Conn or the remote did not send one
+func (c *Conn) ServerName() string {
+	if tc, ok := c.socket().Conn.(*tls.Conn); ok {
+		return tc.ConnectionState().ServerName
+	}
+	return ""
+}
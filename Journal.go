@@ -0,0 +1,198 @@
+package bufconn
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JournalEntry is one outbound message recorded in a journal
+type JournalEntry struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	ConnID uint64    `json:"conn"`
+	Msg    string    `json:"msg"`
+}
+
+// journalLine is one line of a journal file. A line either records a queued message, or marks an earlier one as done with
+type journalLine struct {
+	JournalEntry
+	Done bool `json:"done,omitempty"`
+}
+
+// Journal records outbound messages to a file when they are queued with SendMsg, and marks them as done once written to the socket.
+// After a crash, reopening the journal gives the messages which were queued but may not have been delivered. It is safe for concurrent use
+type Journal struct {
+	lock        sync.Mutex
+	path        string
+	file        *os.File
+	enc         *json.Encoder
+	size        int64
+	maxSize     int64
+	compactAt   int64
+	maxAge      time.Duration
+	lastCompact time.Time
+	seq         uint64
+	pending     map[uint64]JournalEntry
+}
+
+// countingWriter adds the number of bytes written to the file onto n
+type countingWriter struct {
+	w *os.File
+	n *int64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	*cw.n += int64(n)
+	return n, err
+}
+
+// OpenJournal opens (or creates) the journal file at path, keeping any messages which were not done with when it was last used.
+// Once the file grows past maxSize bytes, or maxAge has passed since it was last truncated, it is rewritten with only the messages not yet done with, dropping those older than maxAge.
+// If maxSize or maxAge is zero or less, that limit is not used
+func OpenJournal(path string, maxSize int64, maxAge time.Duration) (*Journal, error) {
+	j := &Journal{
+		path:    path,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		pending: make(map[uint64]JournalEntry),
+	}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<30)
+		for scanner.Scan() {
+			var l journalLine
+			if json.Unmarshal(scanner.Bytes(), &l) != nil {
+				// The last line may be half written if the process crashed
+				continue
+			}
+			if l.Seq > j.seq {
+				j.seq = l.Seq
+			}
+			if l.Done {
+				delete(j.pending, l.Seq)
+			} else {
+				j.pending[l.Seq] = l.JournalEntry
+			}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Undelivered returns every message which was queued but not yet written, oldest first. This includes messages left over from before the journal was reopened
+func (j *Journal) Undelivered() []JournalEntry {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	entries := make([]JournalEntry, 0, len(j.pending))
+	for _, e := range j.pending {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Seq < entries[b].Seq })
+	return entries
+}
+
+// Discard marks the message with the sequence number as done with, for example after it has been resent
+func (j *Journal) Discard(seq uint64) {
+	j.done(seq)
+}
+
+// Close closes the journal file. Messages not yet done with are kept in it
+func (j *Journal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.file.Close()
+}
+
+// record appends a queued message to the journal and returns its sequence number. Errors writing to the file are ignored
+func (j *Journal) record(connID uint64, msg string) uint64 {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.seq++
+	e := JournalEntry{Seq: j.seq, Time: time.Now(), ConnID: connID, Msg: msg}
+	j.pending[e.Seq] = e
+	j.enc.Encode(journalLine{JournalEntry: e})
+	j.maybeCompact()
+	return e.Seq
+}
+
+// done marks a message as done with
+func (j *Journal) done(seq uint64) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if _, ok := j.pending[seq]; !ok {
+		return
+	}
+	delete(j.pending, seq)
+	j.enc.Encode(journalLine{JournalEntry: JournalEntry{Seq: seq}, Done: true})
+	j.maybeCompact()
+}
+
+// maybeCompact rewrites the file if it has passed either limit. The lock must be held
+func (j *Journal) maybeCompact() {
+	if (j.maxSize > 0 && j.size > j.compactAt) || (j.maxAge > 0 && time.Since(j.lastCompact) > j.maxAge) {
+		j.compact()
+	}
+}
+
+// compact drops messages older than maxAge, then replaces the file with one holding only the messages not done with. The lock must be held, or the journal not yet shared
+func (j *Journal) compact() error {
+	if j.maxAge > 0 {
+		for seq, e := range j.pending {
+			if time.Since(e.Time) > j.maxAge {
+				delete(j.pending, seq)
+			}
+		}
+	}
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	var size int64
+	enc := json.NewEncoder(countingWriter{f, &size})
+	seqs := make([]uint64, 0, len(j.pending))
+	for seq := range j.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(a, b int) bool { return seqs[a] < seqs[b] })
+	for _, seq := range seqs {
+		if err := enc.Encode(journalLine{JournalEntry: j.pending[seq]}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		f.Close()
+		return err
+	}
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file = f
+	j.size = size
+	j.enc = json.NewEncoder(countingWriter{f, &j.size})
+	j.lastCompact = time.Now()
+	// If the undelivered messages alone are near the limit, wait for the file to double before rewriting it again
+	j.compactAt = j.maxSize
+	if 2*size > j.compactAt {
+		j.compactAt = 2 * size
+	}
+	return nil
+}
+
+// SetJournal records every message queued on the connection with SendMsg in the journal, until it is written. Journals may be shared between connections. If nil (the default), nothing is recorded
+func (c *Conn) SetJournal(j *Journal) {
+	c.updateSettings(func(s *settings) {
+		s.journal = j
+	})
+}
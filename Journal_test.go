@@ -0,0 +1,121 @@
+package bufconn_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// undelivered returns the messages in the journal which are not done with
+func undelivered(j *bufconn.Journal) []string {
+	var msgs []string
+	for _, e := range j.Undelivered() {
+		msgs = append(msgs, e.Msg)
+	}
+	return msgs
+}
+
+func TestJournalKeepsUnwrittenMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := bufconn.OpenJournal(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	c, remote, release := blockedConn(t)
+	c.SetJournal(j)
+	c.SendMsg("one", bufconn.PriorityNormal)
+	c.SendMsg("two", bufconn.PriorityNormal)
+	if got := fmt.Sprint(undelivered(j)); got != "[one two]" {
+		t.Fatalf("expected both messages to be undelivered, got %s", got)
+	}
+	// Opening a copy of the file is what a restart after a crash would see
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashed := filepath.Join(t.TempDir(), "crashed")
+	if err := os.WriteFile(crashed, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := bufconn.OpenJournal(crashed, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := fmt.Sprint(undelivered(reopened)); got != "[one two]" {
+		t.Fatalf("expected both messages after reopening, got %s", got)
+	}
+	got := lines(remote)
+	release()
+	expectLines(t, got, "one", "two")
+	waitFor(t, "messages to be done with", func() bool { return len(j.Undelivered()) == 0 })
+}
+
+func TestJournalCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := bufconn.OpenJournal(path, 1024, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	c.SetJournal(j)
+	got := lines(b)
+	for i := 0; i < 100; i++ {
+		c.SendMsg("message which is written many times", bufconn.PriorityNormal)
+		expectLines(t, got, "message which is written many times")
+	}
+	waitFor(t, "messages to be done with", func() bool { return len(j.Undelivered()) == 0 })
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 1024 {
+		t.Fatalf("expected the journal to be compacted to under 1024 bytes, got %d", info.Size())
+	}
+}
+
+func TestJournalDiscard(t *testing.T) {
+	j, err := bufconn.OpenJournal(filepath.Join(t.TempDir(), "journal"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	c, _, _ := blockedConn(t)
+	c.SetJournal(j)
+	c.SendMsg("resent elsewhere", bufconn.PriorityNormal)
+	j.Discard(j.Undelivered()[0].Seq)
+	if n := len(j.Undelivered()); n != 0 {
+		t.Fatalf("expected the discarded message to be gone, got %d", n)
+	}
+}
+
+func TestJournalDropsOldMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := bufconn.OpenJournal(path, 0, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, _ := blockedConn(t)
+	c.SetJournal(j)
+	c.SendMsg("old", bufconn.PriorityNormal)
+	j.Close()
+	time.Sleep(30 * time.Millisecond)
+	j, err = bufconn.OpenJournal(path, 0, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if n := len(j.Undelivered()); n != 0 {
+		t.Fatalf("expected messages older than the max age to be dropped, got %d", n)
+	}
+}
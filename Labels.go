@@ -0,0 +1,55 @@
+package bufconn
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+)
+
+// ProfileLabels is whether connections attach pprof labels to their goroutines, so that CPU and block profiles of a busy server can be split up by connection.
+// Each goroutine is labelled with "bufconn_conn" (the connection's ID), "bufconn_remote" (the remote's address) and "bufconn_role", which is "reader", "loop", "handler", "operation", "check" or "mirror".
+// Labelling every handler and operation has a cost, so it is off by default. It is read when each connection starts, so changing it does not affect running connections
+var ProfileLabels = false
+
+// SetProfileLabels adds extra pprof labels, as key value pairs, to the connection's processing loop and everything it runs, such as the remote's identity once it has logged in.
+// They replace any extra labels set before, and take effect from the next handler or operation. They are only used if ProfileLabels was true when the connection started
+func (c *Conn) SetProfileLabels(labels ...string) {
+	c.extraLabels.Store(append([]string{}, labels...))
+}
+
+// labelSet returns the connection's pprof labels for a goroutine with the role
+func (c *Conn) labelSet(role string) pprof.LabelSet {
+	remote := ""
+	if addr := c.RemoteAddr(); addr != nil {
+		remote = addr.String()
+	}
+	labels := []string{
+		"bufconn_conn", strconv.FormatUint(c.id, 10),
+		"bufconn_remote", remote,
+		"bufconn_role", role,
+	}
+	if extra, ok := c.extraLabels.Load().([]string); ok {
+		labels = append(labels, extra...)
+	}
+	return pprof.Labels(labels...)
+}
+
+// labelGoroutine labels the calling goroutine with the role, if profile labels are turned on
+func (c *Conn) labelGoroutine(role string) {
+	if !c.labelled {
+		return
+	}
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), c.labelSet(role)))
+}
+
+// runLabelled runs f on the processing loop labelled with the role, then labels the loop again. If profile labels are turned off, it just runs f
+func (c *Conn) runLabelled(role string, f func()) {
+	if !c.labelled {
+		f()
+		return
+	}
+	pprof.Do(context.Background(), c.labelSet(role), func(context.Context) {
+		f()
+	})
+	c.labelGoroutine("loop")
+}
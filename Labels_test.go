@@ -0,0 +1,62 @@
+package bufconn_test
+
+import (
+	"fmt"
+	"net"
+	"runtime/pprof"
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// goroutineProfile returns the goroutine profile, which lists the labels of each goroutine
+func goroutineProfile() string {
+	var sb strings.Builder
+	pprof.Lookup("goroutine").WriteTo(&sb, 1)
+	return sb.String()
+}
+
+// profileDuringHandler creates a connection with profile labels set to labelled, and returns the goroutine profile taken from within its handler
+func profileDuringHandler(t *testing.T, labelled bool, extra ...string) (*bufconn.Conn, string) {
+	defer func(old bool) { bufconn.ProfileLabels = old }(bufconn.ProfileLabels)
+	bufconn.ProfileLabels = labelled
+	a, b := net.Pipe()
+	profiles := make(chan string, 1)
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		c.ReadMsg(0)
+		profiles <- goroutineProfile()
+	}, '\n')
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	c.SetProfileLabels(extra...)
+	b.Write([]byte("x\n"))
+	return c, <-profiles
+}
+
+func TestProfileLabels(t *testing.T) {
+	c, profile := profileDuringHandler(t, true, "user", "alice")
+	for _, want := range []string{
+		fmt.Sprintf(`"bufconn_conn":"%d"`, c.ID()),
+		`"bufconn_role":"handler"`,
+		`"bufconn_role":"reader"`,
+		`"bufconn_remote":"pipe"`,
+		`"user":"alice"`,
+	} {
+		if !strings.Contains(profile, want) {
+			t.Fatalf("expected the profile to contain %s, got:\n%s", want, profile)
+		}
+	}
+}
+
+func TestProfileLabelsOffByDefault(t *testing.T) {
+	if bufconn.ProfileLabels {
+		t.Fatal("expected profile labels to be off by default")
+	}
+	c, profile := profileDuringHandler(t, false)
+	if want := fmt.Sprintf(`"bufconn_conn":"%d"`, c.ID()); strings.Contains(profile, want) {
+		t.Fatalf("expected no labels for the connection, got:\n%s", profile)
+	}
+}
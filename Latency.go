@@ -0,0 +1,162 @@
+package bufconn
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Latency is extra delay added to data passing through a connection, to mimic a slow network
+type Latency struct {
+	// Delay is added to every chunk of data
+	Delay time.Duration
+	// Jitter is the most that is randomly added to or taken away from Delay for each chunk. Data is never reordered by jitter
+	Jitter time.Duration
+}
+
+// next returns when data sent now should arrive, given when the previous chunk arrives
+func (l Latency) next(prev time.Time) time.Time {
+	d := l.Delay
+	if l.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*l.Jitter))) - l.Jitter
+	}
+	at := time.Now().Add(d)
+	if at.Before(prev) {
+		at = prev
+	}
+	return at
+}
+
+// enabled checks if the latency adds any delay
+func (l Latency) enabled() bool {
+	return l.Delay > 0 || l.Jitter > 0
+}
+
+// latencyChunk is some data which may not be passed on until at
+type latencyChunk struct {
+	data []byte
+	err  error
+	at   time.Time
+}
+
+// latencyConn delays the data read from and written to a net.Conn
+type latencyConn struct {
+	net.Conn
+	read, write Latency
+	in          chan latencyChunk
+	leftover    []byte
+	readErr     error
+	out         chan latencyChunk
+	writeLock   sync.Mutex
+	writeErr    error
+	lastWrite   time.Time
+	closeOnce   sync.Once
+	closed      chan struct{}
+}
+
+// SimulateLatency wraps a connection so that data read from it and written to it is delayed, for making a staging environment behave like a slow network while talking to real services.
+// Writes return straight away and the data is written in the background once its delay has passed, so delays do not limit throughput, just like on a real network. The error from a failed write is returned by the next one.
+// Data still being delayed when the connection is closed is dropped. Read and write deadlines on the returned connection are not accurate while data is delayed
+func SimulateLatency(c net.Conn, read, write Latency) net.Conn {
+	lc := &latencyConn{
+		Conn:   c,
+		read:   read,
+		write:  write,
+		closed: make(chan struct{}),
+	}
+	if read.enabled() {
+		lc.in = make(chan latencyChunk, 64)
+		go lc.readLoop()
+	}
+	if write.enabled() {
+		lc.out = make(chan latencyChunk, 64)
+		go lc.writeLoop()
+	}
+	return lc
+}
+
+// readLoop reads from the connection as fast as possible and stamps each chunk with when it may be read
+func (lc *latencyConn) readLoop() {
+	var prev time.Time
+	for {
+		buf := make([]byte, 32*1024)
+		n, err := lc.Conn.Read(buf)
+		prev = lc.read.next(prev)
+		select {
+		case lc.in <- latencyChunk{buf[:n], err, prev}:
+		case <-lc.closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeLoop writes each chunk once its delay has passed
+func (lc *latencyConn) writeLoop() {
+	for {
+		var chunk latencyChunk
+		select {
+		case chunk = <-lc.out:
+		case <-lc.closed:
+			return
+		}
+		time.Sleep(time.Until(chunk.at))
+		if _, err := lc.Conn.Write(chunk.data); err != nil {
+			lc.writeLock.Lock()
+			lc.writeErr = err
+			lc.writeLock.Unlock()
+		}
+	}
+}
+
+func (lc *latencyConn) Read(b []byte) (int, error) {
+	if lc.in == nil {
+		return lc.Conn.Read(b)
+	}
+	for len(lc.leftover) == 0 {
+		if lc.readErr != nil {
+			return 0, lc.readErr
+		}
+		var chunk latencyChunk
+		select {
+		case chunk = <-lc.in:
+		case <-lc.closed:
+			return 0, net.ErrClosed
+		}
+		time.Sleep(time.Until(chunk.at))
+		lc.leftover, lc.readErr = chunk.data, chunk.err
+	}
+	n := copy(b, lc.leftover)
+	lc.leftover = lc.leftover[n:]
+	return n, nil
+}
+
+func (lc *latencyConn) Write(b []byte) (int, error) {
+	if lc.out == nil {
+		return lc.Conn.Write(b)
+	}
+	lc.writeLock.Lock()
+	err := lc.writeErr
+	lc.lastWrite = lc.write.next(lc.lastWrite)
+	at := lc.lastWrite
+	lc.writeLock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case lc.out <- latencyChunk{data: append([]byte{}, b...), at: at}:
+		return len(b), nil
+	case <-lc.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (lc *latencyConn) Close() error {
+	lc.closeOnce.Do(func() {
+		close(lc.closed)
+	})
+	return lc.Conn.Close()
+}
@@ -0,0 +1,66 @@
+package bufconn_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestSimulateLatencyDelaysWrites(t *testing.T) {
+	a, b := net.Pipe()
+	slow := bufconn.SimulateLatency(a, bufconn.Latency{}, bufconn.Latency{Delay: 50 * time.Millisecond})
+	defer slow.Close()
+	defer b.Close()
+	got := lines(b)
+	start := time.Now()
+	if _, err := slow.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	// The write returns straight away, and the data arrives later
+	if d := time.Since(start); d > 20*time.Millisecond {
+		t.Fatalf("expected the write to return straight away, took %v", d)
+	}
+	expectLines(t, got, "hello")
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("expected the data to be delayed, arrived after %v", d)
+	}
+}
+
+func TestSimulateLatencyDelaysReads(t *testing.T) {
+	a, b := net.Pipe()
+	slow := bufconn.SimulateLatency(a, bufconn.Latency{Delay: 50 * time.Millisecond}, bufconn.Latency{})
+	c := bufconn.NewConn(slow, nil, '\n')
+	defer c.Stop()
+	defer b.Close()
+	start := time.Now()
+	b.Write([]byte("hello\n"))
+	msgs := make(chan string, 1)
+	c.QueueOperation(func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(time.Second)
+		msgs <- msg
+	})
+	if msg := <-msgs; msg != "hello" {
+		t.Fatalf("expected hello, got %q", msg)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("expected the data to be delayed, arrived after %v", d)
+	}
+}
+
+func TestSimulateLatencyJitterKeepsOrder(t *testing.T) {
+	a, b := net.Pipe()
+	slow := bufconn.SimulateLatency(a, bufconn.Latency{}, bufconn.Latency{Delay: 10 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	defer slow.Close()
+	defer b.Close()
+	got := lines(b)
+	var want []string
+	for i := 0; i < 20; i++ {
+		msg := fmt.Sprint("msg", i)
+		want = append(want, msg)
+		slow.Write([]byte(msg + "\n"))
+	}
+	expectLines(t, got, want...)
+}
@@ -0,0 +1,130 @@
+package bufconn
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LeakCheck turns on tracking of the goroutines and timers each connection creates, so that any still running some time after the connection stops are reported (see OnLeak).
+// Tracking costs a little every time one is created, so this is meant for tests and soak runs rather than production. It is read when each connection is created
+var LeakCheck = false
+
+// LeakGrace is how long the goroutines and timers of a stopped connection have to finish before they are reported as leaked. It is read when each connection is created
+var LeakGrace = 5 * time.Second
+
+// OnLeak is called with a report for each stopped connection which leaked goroutines or timers, when LeakCheck is on. If nil, reports are written with the log package. It is read when each connection is created
+var OnLeak func(LeakReport)
+
+// LeakReport lists what a connection left running after it stopped
+type LeakReport struct {
+	ConnID uint64
+	// StoppedFor is how long the connection had been stopped when the leaks were found
+	StoppedFor time.Duration
+	Leaks      []Leak
+}
+
+// Leak is one goroutine or timer which was still running after its connection stopped
+type Leak struct {
+	// Kind is "goroutine" or "timer"
+	Kind string
+	// Name says what it was for, such as "reader" or "watchdog"
+	Name string
+	// Age is how long before the report it was created
+	Age time.Duration
+	// Stack is the goroutine's stack trace, showing where it is stuck. It is empty for timers
+	Stack string
+}
+
+func (r LeakReport) String() string {
+	lines := []string{fmt.Sprintf("conn %d leaked %d goroutines and timers, %v after stopping", r.ConnID, len(r.Leaks), r.StoppedFor)}
+	for _, l := range r.Leaks {
+		lines = append(lines, fmt.Sprintf("%s %s (created %v ago)", l.Kind, l.Name, l.Age))
+		if l.Stack != "" {
+			lines = append(lines, l.Stack)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tracked is a goroutine or timer which a connection created
+type tracked struct {
+	kind      string
+	name      string
+	created   time.Time
+	goroutine int64
+}
+
+// leakTracker keeps track of what a connection has running. It is safe for concurrent use
+type leakTracker struct {
+	lock    sync.Mutex
+	lastID  int
+	running map[int]*tracked
+}
+
+// track records that a goroutine or timer was created, returning a function to call once it has finished. It does nothing if leak checking is off
+func (c *Conn) track(kind, name string) func() {
+	t := c.leaks
+	if t == nil {
+		return func() {}
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.lastID++
+	id := t.lastID
+	r := &tracked{kind: kind, name: name, created: time.Now()}
+	if kind == "goroutine" {
+		r.goroutine = goroutineID()
+	}
+	t.running[id] = r
+	return func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		delete(t.running, id)
+	}
+}
+
+// goTracked runs f in a new goroutine, which is tracked if leak checking is on
+func (c *Conn) goTracked(name string, f func()) {
+	go func() {
+		defer c.track("goroutine", name)()
+		f()
+	}()
+}
+
+// checkLeaks waits for LeakGrace after the connection stops, then reports anything it created which is still running
+func (c *Conn) checkLeaks() {
+	t := c.leaks
+	if t == nil {
+		return
+	}
+	grace, onLeak := LeakGrace, OnLeak
+	go func() {
+		<-c.done
+		stopped := time.Now()
+		time.Sleep(grace)
+		t.lock.Lock()
+		leaks := make([]Leak, 0, len(t.running))
+		for _, r := range t.running {
+			l := Leak{Kind: r.kind, Name: r.name, Age: time.Since(r.created)}
+			if r.goroutine != 0 {
+				l.Stack = goroutineStack(r.goroutine)
+			}
+			leaks = append(leaks, l)
+		}
+		t.lock.Unlock()
+		if len(leaks) == 0 {
+			return
+		}
+		sort.Slice(leaks, func(a, b int) bool { return leaks[a].Age > leaks[b].Age })
+		report := LeakReport{ConnID: c.id, StoppedFor: time.Since(stopped), Leaks: leaks}
+		if onLeak != nil {
+			onLeak(report)
+		} else {
+			log.Print(report)
+		}
+	}()
+}
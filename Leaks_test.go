@@ -0,0 +1,71 @@
+package bufconn_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// leakReports turns on leak checking with a short grace period until the test ends, and returns a channel of the reports
+func leakReports(t *testing.T) <-chan bufconn.LeakReport {
+	oldCheck, oldGrace, oldOnLeak := bufconn.LeakCheck, bufconn.LeakGrace, bufconn.OnLeak
+	t.Cleanup(func() {
+		bufconn.LeakCheck, bufconn.LeakGrace, bufconn.OnLeak = oldCheck, oldGrace, oldOnLeak
+	})
+	reports := make(chan bufconn.LeakReport, 10)
+	bufconn.LeakCheck = true
+	bufconn.LeakGrace = 50 * time.Millisecond
+	bufconn.OnLeak = func(r bufconn.LeakReport) { reports <- r }
+	return reports
+}
+
+func TestLeakCheckReportsStuckHandler(t *testing.T) {
+	reports := leakReports(t)
+	a, b := net.Pipe()
+	defer b.Close()
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		c.ReadMsg(0)
+		close(started)
+		<-release
+	}, '\n')
+	b.Write([]byte("x\n"))
+	<-started
+	c.Stop()
+	select {
+	case r := <-reports:
+		if r.ConnID != c.ID() || r.StoppedFor < 50*time.Millisecond {
+			t.Fatalf("unexpected report %v", r)
+		}
+		var loop *bufconn.Leak
+		for i := range r.Leaks {
+			if r.Leaks[i].Name == "loop" {
+				loop = &r.Leaks[i]
+			}
+		}
+		if loop == nil || loop.Kind != "goroutine" || !strings.Contains(loop.Stack, "TestLeakCheckReportsStuckHandler") {
+			t.Fatalf("expected the stuck loop goroutine to be reported, got %v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a leak report")
+	}
+}
+
+func TestLeakCheckQuietWhenClean(t *testing.T) {
+	reports := leakReports(t)
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	bufconn.DetectWriteStall(c, time.Second, false, nil)
+	c.Stop()
+	select {
+	case r := <-reports:
+		t.Fatalf("expected no leaks, got %v", r)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,251 @@
+package bufconn
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrMemoryLimit is the error a connection stops with when a Manager stops it to keep the total buffered data under its memory limit
+var ErrMemoryLimit = errors.New("manager memory limit exceeded")
+
+// ErrDuplicateIdentity is returned by Manager.Add when a connection is rejected because its identity is already connected
+var ErrDuplicateIdentity = errors.New("identity already connected")
+
+// DuplicateAction is what a Manager should do when a connection is added with the same identity as one it already has
+type DuplicateAction int
+
+const (
+	// DuplicateAllow keeps both connections
+	DuplicateAllow DuplicateAction = iota
+	// DuplicateReject stops the new connection and keeps the old one
+	DuplicateReject
+	// DuplicateTakeover stops the old connection and keeps the new one
+	DuplicateTakeover
+)
+
+// Manager keeps track of many connections, from servers and clients alike, so they can be inspected and controlled together.
+// Connections are forgotten automatically once they stop
+type Manager struct {
+	lock         sync.Mutex
+	conns        map[uint64]*Conn
+	groups       map[string]map[uint64]*Conn
+	listeners    map[int]func(PresenceEvent)
+	nextListener int
+	limiter      *tokenBucket
+	writeSched   *writeScheduler
+	memoryLimit  int
+	watching     bool
+	onDuplicate  func(existing, incoming *Conn) DuplicateAction
+}
+
+// ManagerStats is the combined stats of every connection in a Manager
+type ManagerStats struct {
+	Conns    int
+	Buffered int
+	Throughput
+}
+
+// NewManager creates an empty manager
+func NewManager() *Manager {
+	return &Manager{
+		conns: make(map[uint64]*Conn),
+	}
+}
+
+// Add starts managing the connection, and applies any global policies to it.
+// If the duplicate handler rejects the connection, it is told to go away (see GoAway) and ErrDuplicateIdentity is returned
+func (m *Manager) Add(c *Conn) error {
+	m.lock.Lock()
+	// The check and the insert happen under one lock, so two connections with the same identity can not both get in
+	var replaced []*Conn
+	var events []PresenceEvent
+	if id := c.Identity(); m.onDuplicate != nil && id != "" {
+		for _, other := range m.conns {
+			if other == c || other.Identity() != id {
+				continue
+			}
+			switch m.onDuplicate(other, c) {
+			case DuplicateReject:
+				m.lock.Unlock()
+				m.announce(events)
+				for _, r := range replaced {
+					r.GoAway(GoAwayPolicy, "replaced by a new connection")
+				}
+				c.GoAway(GoAwayPolicy, "identity already connected")
+				return ErrDuplicateIdentity
+			case DuplicateTakeover:
+				events = append(events, m.removeLocked(other)...)
+				replaced = append(replaced, other)
+			}
+		}
+	}
+	m.conns[c.ID()] = c
+	c.limiter.Store(m.limiter)
+	c.writeSched.Store(m.writeSched)
+	m.lock.Unlock()
+	m.announce(events)
+	for _, r := range replaced {
+		r.GoAway(GoAwayPolicy, "replaced by a new connection")
+	}
+	c.goTracked("manager removal", func() {
+		<-c.Done()
+		m.Remove(c)
+	})
+	return nil
+}
+
+// SetDuplicateHandler sets a function which decides what happens when a connection is added with the same identity (see Conn.Identity) as one already managed.
+// It is called once for each existing connection with the identity. The connection which loses is told to go away with GoAwayPolicy, so it stops cleanly.
+// It is called while the manager is locked, so it must not call the manager's methods. If nil (the default), or for connections without an identity, duplicates are allowed
+func (m *Manager) SetDuplicateHandler(f func(existing, incoming *Conn) DuplicateAction) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.onDuplicate = f
+}
+
+// Remove stops managing the connection, without stopping it
+func (m *Manager) Remove(c *Conn) {
+	m.lock.Lock()
+	events := m.removeLocked(c)
+	m.lock.Unlock()
+	m.announce(events)
+}
+
+// removeLocked stops managing the connection, returning the presence events to announce once the lock is released. The lock must be held
+func (m *Manager) removeLocked(c *Conn) []PresenceEvent {
+	var events []PresenceEvent
+	if m.conns[c.ID()] == c {
+		delete(m.conns, c.ID())
+		c.limiter.Store((*tokenBucket)(nil))
+		c.writeSched.Store((*writeScheduler)(nil))
+		for tag := range m.groups {
+			if m.leave(c, tag) {
+				events = append(events, PresenceEvent{tag, false, c, c.AllMetadata()})
+			}
+		}
+	}
+	return events
+}
+
+// Get returns the connection with the ID, if it is being managed
+func (m *Manager) Get(id uint64) (*Conn, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	c, ok := m.conns[id]
+	return c, ok
+}
+
+// Conns returns every managed connection
+func (m *Manager) Conns() []*Conn {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	conns := make([]*Conn, 0, len(m.conns))
+	for _, c := range m.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Len returns the number of managed connections
+func (m *Manager) Len() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.conns)
+}
+
+// Stats adds up the stats of every managed connection
+func (m *Manager) Stats() ManagerStats {
+	var s ManagerStats
+	for _, c := range m.Conns() {
+		t := c.Throughput()
+		s.Conns++
+		s.Buffered += c.Buffered()
+		s.In1s += t.In1s
+		s.In10s += t.In10s
+		s.In60s += t.In60s
+		s.Out1s += t.Out1s
+		s.Out10s += t.Out10s
+		s.Out60s += t.Out60s
+		s.TotalIn += t.TotalIn
+		s.TotalOut += t.TotalOut
+	}
+	return s
+}
+
+// StopMatching stops every managed connection for which pred returns true, and returns how many were stopped
+func (m *Manager) StopMatching(pred func(*Conn) bool) int {
+	n := 0
+	for _, c := range m.Conns() {
+		if pred(c) {
+			c.Stop()
+			n++
+		}
+	}
+	return n
+}
+
+// StopAll stops every managed connection
+func (m *Manager) StopAll() {
+	m.StopMatching(func(*Conn) bool { return true })
+}
+
+// SetGlobalRateLimit limits the total number of messages handled across every managed connection to perSecond per second on average, with bursts of up to burst messages.
+// Messages over the limit wait until they are allowed. If perSecond is zero or less, there is no limit
+func (m *Manager) SetGlobalRateLimit(perSecond float64, burst int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.limiter = nil
+	if perSecond > 0 {
+		m.limiter = newTokenBucket(perSecond, burst)
+	}
+	for _, c := range m.conns {
+		c.limiter.Store(m.limiter)
+	}
+}
+
+// SetMemoryLimit limits the total number of buffered bytes (see Conn.Buffered) across every managed connection. When it is exceeded, the connections with the most buffered are stopped with ErrMemoryLimit until the total is back under.
+// If limit is zero or less, there is no limit
+func (m *Manager) SetMemoryLimit(limit int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.memoryLimit = limit
+	if limit > 0 && !m.watching {
+		m.watching = true
+		go m.watchMemory()
+	}
+}
+
+// watchMemory periodically enforces the memory limit, until it is removed
+func (m *Manager) watchMemory() {
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for range t.C {
+		m.lock.Lock()
+		limit := m.memoryLimit
+		if limit <= 0 {
+			m.watching = false
+			m.lock.Unlock()
+			return
+		}
+		m.lock.Unlock()
+		conns := m.Conns()
+		total := 0
+		sizes := make(map[*Conn]int, len(conns))
+		for _, c := range conns {
+			sizes[c] = c.Buffered()
+			total += sizes[c]
+		}
+		for total > limit {
+			var biggest *Conn
+			for c, n := range sizes {
+				if biggest == nil || n > sizes[biggest] {
+					biggest = c
+				}
+			}
+			total -= sizes[biggest]
+			delete(sizes, biggest)
+			biggest.stopWithErr(ErrMemoryLimit)
+		}
+	}
+}
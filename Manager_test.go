@@ -0,0 +1,169 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// managedConn creates a connection over a pipe and adds it to the manager, returning it and the remote end
+func managedConn(t *testing.T, m *bufconn.Manager) (*bufconn.Conn, net.Conn) {
+	t.Helper()
+	a, b := net.Pipe()
+	c := bufconn.NewConn(a, nil, '\n')
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	if err := m.Add(c); err != nil {
+		t.Fatal(err)
+	}
+	return c, b
+}
+
+func TestManagerForgetsStoppedConns(t *testing.T) {
+	m := bufconn.NewManager()
+	c1, _ := managedConn(t, m)
+	c2, _ := managedConn(t, m)
+	if m.Len() != 2 {
+		t.Fatalf("expected 2 conns, got %d", m.Len())
+	}
+	if got, ok := m.Get(c1.ID()); !ok || got != c1 {
+		t.Fatal("expected to find the first conn by its ID")
+	}
+	c1.Stop()
+	waitFor(t, "the stopped conn to be forgotten", func() bool { return m.Len() == 1 })
+	if _, ok := m.Get(c1.ID()); ok {
+		t.Fatal("expected the stopped conn to be gone")
+	}
+	m.Remove(c2)
+	if m.Len() != 0 || c2.IsStopped() {
+		t.Fatal("expected Remove to forget the conn without stopping it")
+	}
+}
+
+func TestManagerStopMatching(t *testing.T) {
+	m := bufconn.NewManager()
+	keep, _ := managedConn(t, m)
+	drop, _ := managedConn(t, m)
+	if n := m.StopMatching(func(c *bufconn.Conn) bool { return c == drop }); n != 1 {
+		t.Fatalf("expected 1 conn to be stopped, got %d", n)
+	}
+	waitFor(t, "the conn to stop", drop.IsStopped)
+	if keep.IsStopped() {
+		t.Fatal("expected the other conn to keep running")
+	}
+	m.StopAll()
+	waitFor(t, "every conn to stop", keep.IsStopped)
+}
+
+func TestManagerStats(t *testing.T) {
+	m := bufconn.NewManager()
+	_, r1 := managedConn(t, m)
+	_, r2 := managedConn(t, m)
+	r1.Write([]byte("abc\n"))
+	r2.Write([]byte("de\n"))
+	waitFor(t, "bytes to be counted", func() bool { return m.Stats().TotalIn == 7 })
+	if s := m.Stats(); s.Conns != 2 {
+		t.Fatalf("expected 2 conns in the stats, got %d", s.Conns)
+	}
+}
+
+func TestManagerMemoryLimitStopsBiggest(t *testing.T) {
+	m := bufconn.NewManager()
+	small, r1 := managedConn(t, m)
+	big, r2 := managedConn(t, m)
+	// Neither message is finished, so both stay buffered
+	r1.Write([]byte(strings.Repeat("x", 10)))
+	r2.Write([]byte(strings.Repeat("x", 90)))
+	m.SetMemoryLimit(50)
+	select {
+	case <-big.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the biggest conn to be stopped")
+	}
+	if !errors.Is(big.Err(), bufconn.ErrMemoryLimit) {
+		t.Fatalf("expected ErrMemoryLimit, got %v", big.Err())
+	}
+	time.Sleep(150 * time.Millisecond)
+	if small.IsStopped() {
+		t.Fatal("expected the smaller conn to keep running once under the limit")
+	}
+	m.SetMemoryLimit(0)
+}
+
+func TestManagerGlobalRateLimit(t *testing.T) {
+	m := bufconn.NewManager()
+	m.SetGlobalRateLimit(20, 1)
+	_, r1 := managedConn(t, m)
+	_, r2 := managedConn(t, m)
+	start := time.Now()
+	r1.Write([]byte("a\nb\n"))
+	r2.Write([]byte("c\nd\n"))
+	waitFor(t, "messages to be handled", func() bool { return m.Stats().Buffered == 0 })
+	// One message uses the burst, and the other three share one limit between both conns
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("expected the limit to be shared between conns, took %v", d)
+	}
+}
+
+// identifiedConn creates a connection with the identity over a pipe, and adds it to the manager, returning the error from Add
+func identifiedConn(t *testing.T, m *bufconn.Manager, identity string) (*bufconn.Conn, error) {
+	t.Helper()
+	a, b := net.Pipe()
+	c := bufconn.NewConn(a, nil, '\n')
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	lines(b)
+	c.SetIdentity(identity)
+	return c, m.Add(c)
+}
+
+func TestManagerDuplicateReject(t *testing.T) {
+	m := bufconn.NewManager()
+	m.SetDuplicateHandler(func(existing, incoming *bufconn.Conn) bufconn.DuplicateAction { return bufconn.DuplicateReject })
+	first, err := identifiedConn(t, m, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := identifiedConn(t, m, "alice")
+	if !errors.Is(err, bufconn.ErrDuplicateIdentity) {
+		t.Fatalf("expected ErrDuplicateIdentity, got %v", err)
+	}
+	waitFor(t, "the duplicate to stop", second.IsStopped)
+	if first.IsStopped() || m.Len() != 1 {
+		t.Fatal("expected the first conn to be kept")
+	}
+	if _, err := identifiedConn(t, m, "bob"); err != nil {
+		t.Fatalf("expected other identities to be allowed, got %v", err)
+	}
+}
+
+func TestManagerDuplicateTakeover(t *testing.T) {
+	m := bufconn.NewManager()
+	m.SetDuplicateHandler(func(existing, incoming *bufconn.Conn) bufconn.DuplicateAction { return bufconn.DuplicateTakeover })
+	first, _ := identifiedConn(t, m, "alice")
+	m.Join(first, "room")
+	second, err := identifiedConn(t, m, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the old conn to stop", first.IsStopped)
+	if second.IsStopped() || m.Len() != 1 || m.InGroup(first, "room") {
+		t.Fatal("expected only the new conn to be kept")
+	}
+}
+
+func TestManagerDuplicateAllowedByDefault(t *testing.T) {
+	m := bufconn.NewManager()
+	identifiedConn(t, m, "alice")
+	if _, err := identifiedConn(t, m, "alice"); err != nil || m.Len() != 2 {
+		t.Fatalf("expected duplicates to be allowed without a handler, got %v", err)
+	}
+}
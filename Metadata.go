@@ -0,0 +1,30 @@
+package bufconn
+
+// SetMetadata stores a value against the connection under key, for keeping application state (such as a username) with the connection. It is safe to call from any goroutine
+func (c *Conn) SetMetadata(key string, value any) {
+	c.metaLock.Lock()
+	defer c.metaLock.Unlock()
+	if c.meta == nil {
+		c.meta = make(map[string]any)
+	}
+	c.meta[key] = value
+}
+
+// Metadata returns the value stored under key, and whether there was one
+func (c *Conn) Metadata(key string) (any, bool) {
+	c.metaLock.Lock()
+	defer c.metaLock.Unlock()
+	v, ok := c.meta[key]
+	return v, ok
+}
+
+// AllMetadata returns a copy of every value stored against the connection
+func (c *Conn) AllMetadata() map[string]any {
+	c.metaLock.Lock()
+	defer c.metaLock.Unlock()
+	meta := make(map[string]any, len(c.meta))
+	for k, v := range c.meta {
+		meta[k] = v
+	}
+	return meta
+}
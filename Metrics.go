@@ -0,0 +1,151 @@
+package bufconn
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Metrics receives measurements from a Conn. Its methods are called from the connection's processing loop, so they should return quickly.
+// One Metrics can be shared between many connections, so implementations must be safe for concurrent use
+type Metrics interface {
+	// MessageRead is called with the size in bytes (not including the delimiter) of every message read from the buffer
+	MessageRead(size int)
+	// HandlerDone is called with how long the message handler took, each time it returns
+	HandlerDone(d time.Duration)
+}
+
+// SetMetrics sets where the connection reports its measurements to. If nil, nothing is reported
+func (c *Conn) SetMetrics(m Metrics) {
+	c.updateSettings(func(s *settings) {
+		s.metrics = m
+	})
+}
+
+// Histogram counts observed values in buckets. It is safe for concurrent use
+type Histogram struct {
+	lock   sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+	min    float64
+	max    float64
+}
+
+// NewHistogram creates a histogram with buckets covering start, start*factor, start*factor^2 and so on, n buckets in total. Values above the last bucket are counted in an extra overflow bucket
+func NewHistogram(start, factor float64, n int) *Histogram {
+	bounds := make([]float64, n)
+	for i := range bounds {
+		bounds[i] = start
+		start *= factor
+	}
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, n+1),
+	}
+}
+
+// Observe adds a value to the histogram
+func (h *Histogram) Observe(v float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+}
+
+// Snapshot returns a copy of the histogram as it is now
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	buckets := make([]HistogramBucket, len(h.counts))
+	for i, n := range h.counts {
+		bound := math.Inf(1)
+		if i < len(h.bounds) {
+			bound = h.bounds[i]
+		}
+		buckets[i] = HistogramBucket{bound, n}
+	}
+	return HistogramSnapshot{
+		Count:   h.count,
+		Sum:     h.sum,
+		Min:     h.min,
+		Max:     h.max,
+		Buckets: buckets,
+	}
+}
+
+// HistogramBucket is the number of observed values which were at most UpperBound (and more than the previous bucket's UpperBound)
+type HistogramBucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// HistogramSnapshot is a copy of a histogram at one point in time
+type HistogramSnapshot struct {
+	Count   uint64
+	Sum     float64
+	Min     float64
+	Max     float64
+	Buckets []HistogramBucket
+}
+
+// Mean returns the average of all observed values, or zero if there are none
+func (s HistogramSnapshot) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// Quantile estimates the value below which the fraction q (between 0 and 1) of observations fall, as the upper bound of the bucket it lands in.
+// The estimate is capped at Max, so it is never infinite
+func (s HistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(q * float64(s.Count)))
+	var seen uint64
+	for _, b := range s.Buckets {
+		seen += b.Count
+		if seen >= target {
+			return math.Min(b.UpperBound, s.Max)
+		}
+	}
+	return s.Max
+}
+
+// HistogramMetrics is a Metrics which records message sizes (in bytes) and handler durations (in seconds) into histograms
+type HistogramMetrics struct {
+	Sizes     *Histogram
+	Durations *Histogram
+}
+
+// NewHistogramMetrics creates a HistogramMetrics with buckets from 1 byte to 32MB for sizes, and from 1µs to about 30 minutes for durations
+func NewHistogramMetrics() *HistogramMetrics {
+	return &HistogramMetrics{
+		Sizes:     NewHistogram(1, 2, 26),
+		Durations: NewHistogram(1e-6, 2, 31),
+	}
+}
+
+// MessageRead implements Metrics
+func (m *HistogramMetrics) MessageRead(size int) {
+	m.Sizes.Observe(float64(size))
+}
+
+// HandlerDone implements Metrics
+func (m *HistogramMetrics) HandlerDone(d time.Duration) {
+	m.Durations.Observe(d.Seconds())
+}
@@ -0,0 +1,67 @@
+package bufconn_test
+
+import (
+	"math"
+	"net"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	h := bufconn.NewHistogram(1, 10, 3)
+	for _, v := range []float64{0.5, 1, 5, 50, 500, 5000} {
+		h.Observe(v)
+	}
+	s := h.Snapshot()
+	want := []bufconn.HistogramBucket{{1, 2}, {10, 1}, {100, 1}, {math.Inf(1), 2}}
+	if len(s.Buckets) != len(want) {
+		t.Fatalf("expected %d buckets, got %d", len(want), len(s.Buckets))
+	}
+	for i, b := range s.Buckets {
+		if b != want[i] {
+			t.Fatalf("bucket %d: expected %v, got %v", i, want[i], b)
+		}
+	}
+	if s.Count != 6 || s.Min != 0.5 || s.Max != 5000 || s.Sum != 5556.5 {
+		t.Fatalf("unexpected summary %+v", s)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := bufconn.NewHistogram(1, 2, 10)
+	if q := h.Snapshot().Quantile(0.5); q != 0 {
+		t.Fatalf("expected 0 for an empty histogram, got %v", q)
+	}
+	for i := 0; i < 9; i++ {
+		h.Observe(3)
+	}
+	h.Observe(1e6)
+	s := h.Snapshot()
+	if q := s.Quantile(0.5); q != 4 {
+		t.Fatalf("expected the median to be the upper bound of its bucket, got %v", q)
+	}
+	if q := s.Quantile(1); q != 1e6 {
+		t.Fatalf("expected the overflow bucket to be capped at the max, got %v", q)
+	}
+	if m := s.Mean(); m != (27+1e6)/10 {
+		t.Fatalf("unexpected mean %v", m)
+	}
+}
+
+func TestHistogramMetricsRecordsMessages(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	m := bufconn.NewHistogramMetrics()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	c.SetMetrics(m)
+	b.Write([]byte("abcd\nab\n"))
+	waitFor(t, "messages to be recorded", func() bool { return m.Sizes.Snapshot().Count == 2 })
+	if s := m.Sizes.Snapshot(); s.Sum != 6 {
+		t.Fatalf("expected 6 bytes in total, got %v", s.Sum)
+	}
+	if n := m.Durations.Snapshot().Count; n == 0 {
+		t.Fatal("expected handler durations to be recorded")
+	}
+}
@@ -0,0 +1,201 @@
+package bufconn
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// RateLimitAction is what a rate limited handler should do with a message which arrived too soon
+type RateLimitAction int
+
+const (
+	// RateLimitDelay waits until the message is allowed, then handles it. Nothing else on the connection runs while waiting
+	RateLimitDelay RateLimitAction = iota
+	// RateLimitDrop reads the message from the buffer and discards it
+	RateLimitDrop
+	// RateLimitDisconnect stops the connection
+	RateLimitDisconnect
+)
+
+// RateLimit wraps a message handler so that the remote can only send perSecond messages per second on average, with bursts of up to burst messages.
+// When a message goes over the limit, policy is called to decide what to do with it. If policy is nil, the message is delayed.
+// The limit is tracked inside the returned handler, so wrap the handler seperately for each connection. If perSecond is zero or less, there is no limit, and a burst below one is treated as one
+func RateLimit(perSecond float64, burst int, policy func(*C) RateLimitAction, handler func(*C)) func(*C) {
+	if handler == nil {
+		handler = func(c *C) {
+			c.ReadMsg(0)
+		}
+	}
+	if perSecond <= 0 {
+		return handler
+	}
+	bucket := newTokenBucket(perSecond, burst)
+	return func(c *C) {
+		if !bucket.take() {
+			action := RateLimitDelay
+			if policy != nil {
+				action = policy(c)
+			}
+			switch action {
+			case RateLimitDrop:
+				c.ReadMsg(0)
+				return
+			case RateLimitDisconnect:
+				c.Stop()
+				return
+			default:
+				bucket.wait()
+			}
+		}
+		handler(c)
+	}
+}
+
+// tokenBucket allows perSecond events per second on average, with bursts of up to burst events. It is safe for concurrent use
+type tokenBucket struct {
+	lock      sync.Mutex
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+}
+
+// rateLimiter returns the connection's shared rate limiter, or nil if it has none
+func (c *Conn) rateLimiter() *tokenBucket {
+	l, _ := c.limiter.Load().(*tokenBucket)
+	return l
+}
+
+// newTokenBucket creates a full bucket. perSecond must be above zero, and a burst below one is treated as one
+func newTokenBucket(perSecond float64, burst int) *tokenBucket {
+	if burst < 1 {
+		// The bucket could never hold a whole token otherwise, so nothing would ever be allowed
+		burst = 1
+	}
+	return &tokenBucket{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		last:      time.Now(),
+	}
+}
+
+// refill adds the tokens earned since the last refill. The lock must be held
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.perSecond
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// take uses up a token if one is available, without waiting
+func (b *tokenBucket) take() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// wait waits until a token is available, then uses it up
+func (b *tokenBucket) wait() {
+	for {
+		b.lock.Lock()
+		b.refill()
+		if b.tokens >= 1 {
+			b.tokens--
+			b.lock.Unlock()
+			return
+		}
+		wait := time.Duration((1 - b.tokens) / b.perSecond * float64(time.Second))
+		b.lock.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// ErrUnauthorized is returned when an authorizer denies a message
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Authorizer decides whether a remote with the given identity (see Conn.Identity) may send a message with the given command
+type Authorizer func(identity, command string) bool
+
+// Authorize wraps a message handler so that each message is only passed on if authz allows it. command extracts the command from a message, and if nil, the first word of the message is used.
+// Denied messages are read from the buffer and passed to onDeny, which may be nil. The check applies to every message the handler reads, not just the one it was called for
+func Authorize(authz Authorizer, command func(msg string) string, onDeny func(c *C, msg string), handler func(*C)) func(*C) {
+	if handler == nil {
+		handler = func(c *C) {
+			c.ReadMsg(0)
+		}
+	}
+	if command == nil {
+		command = firstWord
+	}
+	gate := &authzGate{authz: authz, command: command, onDeny: onDeny}
+	return func(c *C) {
+		// The handler is only called if the message it was called for is allowed
+		for {
+			msg, ok := c.peekMsg()
+			if !ok {
+				return
+			}
+			if gate.allows(c, msg) {
+				break
+			}
+			c.discardMsg()
+			if onDeny != nil {
+				onDeny(c, msg)
+			}
+		}
+		c.Conn.authzGates = append(c.Conn.authzGates, gate)
+		defer func() {
+			c.Conn.authzGates = c.Conn.authzGates[:len(c.Conn.authzGates)-1]
+		}()
+		handler(c)
+	}
+}
+
+// authzGate is the check made by an Authorize handler
+type authzGate struct {
+	authz   Authorizer
+	command func(msg string) string
+	onDeny  func(c *C, msg string)
+}
+
+// allows checks if the remote may send msg
+func (g *authzGate) allows(c *C, msg string) bool {
+	return g.authz(c.Identity(), g.command(msg))
+}
+
+// skipDenied takes the messages at the front of the buffer which a running Authorize handler denies, so that the next message peeked is the next one read
+func (c *C) skipDenied() {
+	for {
+		msg, ok := c.peekMsg()
+		if !ok {
+			return
+		}
+		g := c.Conn.deniedBy(c, msg)
+		if g == nil {
+			return
+		}
+		c.discardMsg()
+		if g.onDeny != nil {
+			g.onDeny(c, msg)
+		}
+	}
+}
+
+// deniedBy returns the check of a running Authorize handler which denies msg, or nil if they all allow it
+func (c *Conn) deniedBy(cc *C, msg string) *authzGate {
+	for _, g := range c.authzGates {
+		if !g.allows(cc, msg) {
+			return g
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package bufconn_test
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// auditLog records every audit record it is given
+type auditLog struct {
+	lock    sync.Mutex
+	records []bufconn.AuditRecord
+}
+
+func (a *auditLog) Audit(r bufconn.AuditRecord) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.records = append(a.records, r)
+}
+
+// outcomes returns "command:outcome" for every inbound record
+func (a *auditLog) outcomes() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var out []string
+	for _, r := range a.records {
+		if r.Direction == bufconn.Inbound {
+			out = append(out, r.Command+":"+r.Outcome)
+		}
+	}
+	return out
+}
+
+// authzConn creates a connection whose messages are authorized by denying the "secret" command, recording handled and denied messages in order
+type authzConn struct {
+	*bufconn.Conn
+	remote net.Conn
+	lock   sync.Mutex
+	events []string
+}
+
+func newAuthzConn(t *testing.T, handler func(record func(string)) func(*bufconn.C)) *authzConn {
+	a, b := net.Pipe()
+	ac := &authzConn{remote: b}
+	record := func(e string) {
+		ac.lock.Lock()
+		ac.events = append(ac.events, e)
+		ac.lock.Unlock()
+	}
+	authz := func(identity, command string) bool { return command != "secret" }
+	onDeny := func(c *bufconn.C, msg string) { record("denied " + msg) }
+	ac.Conn = bufconn.NewConn(a, bufconn.Authorize(authz, nil, onDeny, handler(record)), '\n')
+	t.Cleanup(func() {
+		ac.Stop()
+		b.Close()
+	})
+	return ac
+}
+
+// waitEvents waits for there to be n events, then returns them
+func (ac *authzConn) waitEvents(t *testing.T, n int) []string {
+	t.Helper()
+	waitFor(t, "events", func() bool {
+		ac.lock.Lock()
+		defer ac.lock.Unlock()
+		return len(ac.events) >= n
+	})
+	time.Sleep(20 * time.Millisecond)
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	return append([]string{}, ac.events...)
+}
+
+// drainHandler reads every buffered message each time it is called
+func drainHandler(record func(string)) func(*bufconn.C) {
+	return func(c *bufconn.C) {
+		for {
+			msg, ok := c.TryReadMsg()
+			if !ok {
+				return
+			}
+			record("handled " + msg)
+		}
+	}
+}
+
+func TestAuthorizeBurstKeepsOrder(t *testing.T) {
+	ac := newAuthzConn(t, drainHandler)
+	// In one write, so the handler is called for the first message and finds the rest already buffered
+	ac.remote.Write([]byte("hello 1\nsecret 2\nhello 3\nsecret 4\nhello 5\n"))
+	got := strings.Join(ac.waitEvents(t, 5), ",")
+	want := "handled hello 1,denied secret 2,handled hello 3,denied secret 4,handled hello 5"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestAuthorizeDeniedFirst(t *testing.T) {
+	ac := newAuthzConn(t, drainHandler)
+	ac.remote.Write([]byte("secret 1\nsecret 2\nhello 3\n"))
+	got := strings.Join(ac.waitEvents(t, 3), ",")
+	want := "denied secret 1,denied secret 2,handled hello 3"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestAuthorizeOneMessagePerCall(t *testing.T) {
+	ac := newAuthzConn(t, func(record func(string)) func(*bufconn.C) {
+		return func(c *bufconn.C) {
+			msg, _ := c.ReadMsg(0)
+			record("handled " + msg)
+		}
+	})
+	ac.remote.Write([]byte("hello 1\nsecret 2\nhello 3\n"))
+	got := strings.Join(ac.waitEvents(t, 3), ",")
+	want := "handled hello 1,denied secret 2,handled hello 3"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestAuthorizeBatchHandler(t *testing.T) {
+	ac := newAuthzConn(t, func(record func(string)) func(*bufconn.C) {
+		return bufconn.BatchHandler(func(c *bufconn.C, msgs []string) {
+			for _, msg := range msgs {
+				record("handled " + msg)
+			}
+		})
+	})
+	ac.remote.Write([]byte("hello 1\nsecret 2\nhello 3\n"))
+	for _, e := range ac.waitEvents(t, 3) {
+		if strings.HasPrefix(e, "handled secret") {
+			t.Fatalf("denied message reached the batch handler: %q", e)
+		}
+	}
+}
+
+func TestAuthorizeAuditsDenials(t *testing.T) {
+	ac := newAuthzConn(t, drainHandler)
+	log := &auditLog{}
+	ac.SetAuditor(log, nil)
+	ac.remote.Write([]byte("hello 1\nsecret 2\nhello 3\n"))
+	ac.waitEvents(t, 3)
+	got := strings.Join(log.outcomes(), ",")
+	if want := "hello:ok,secret:denied,hello:ok"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+// rateLimitedConn creates a connection whose handler is rate limited, sending every handled message on the returned channel
+func rateLimitedConn(t *testing.T, perSecond float64, burst int, action bufconn.RateLimitAction) (*bufconn.Conn, net.Conn, <-chan string) {
+	a, b := net.Pipe()
+	handled := make(chan string, 10)
+	policy := func(c *bufconn.C) bufconn.RateLimitAction { return action }
+	c := bufconn.NewConn(a, bufconn.RateLimit(perSecond, burst, policy, func(c *bufconn.C) {
+		if msg, ok := c.TryReadMsg(); ok {
+			handled <- msg
+		}
+	}), '\n')
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	return c, b, handled
+}
+
+func TestRateLimitDrop(t *testing.T) {
+	_, remote, handled := rateLimitedConn(t, 1, 2, bufconn.RateLimitDrop)
+	remote.Write([]byte("a\nb\nc\nd\n"))
+	expectLines(t, handled, "a", "b")
+	select {
+	case msg := <-handled:
+		t.Fatalf("expected messages over the limit to be dropped, got %q", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	_, remote, handled := rateLimitedConn(t, 50, 1, bufconn.RateLimitDelay)
+	start := time.Now()
+	remote.Write([]byte("a\nb\nc\n"))
+	expectLines(t, handled, "a", "b", "c")
+	// The first message uses the burst, and each of the other two waits for a token
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Fatalf("expected messages to be delayed, took %v", d)
+	}
+}
+
+func TestRateLimitDisconnect(t *testing.T) {
+	c, remote, handled := rateLimitedConn(t, 1, 1, bufconn.RateLimitDisconnect)
+	go remote.Write([]byte("a\nb\n"))
+	expectLines(t, handled, "a")
+	waitFor(t, "connection to stop", c.IsStopped)
+}
+
+func TestRateLimitZeroIsUnlimited(t *testing.T) {
+	_, remote, handled := rateLimitedConn(t, 0, 0, bufconn.RateLimitDisconnect)
+	go remote.Write([]byte("a\nb\nc\nd\n"))
+	expectLines(t, handled, "a", "b", "c", "d")
+}
@@ -0,0 +1,71 @@
+package bufconn
+
+import (
+	"net"
+	"time"
+)
+
+// socket is the net.Conn a connection is using, along with the remote's address from a PROXY header if there was one
+type socket struct {
+	net.Conn
+	proxyAddr net.Addr
+}
+
+// socket returns the socket the connection is currently using
+func (c *Conn) socket() *socket {
+	return c.sock.Load().(*socket)
+}
+
+// reader is one goroutine reading from the socket. quit is closed to tell it the socket is being replaced, and exited is closed once it has returned
+type reader struct {
+	quit   chan struct{}
+	exited chan struct{}
+}
+
+// MigrateTo moves the connection onto a new socket, such as after the remote's address changes because of NAT rebinding or moving between networks. The old socket is closed.
+// Everything else about the connection is kept: the buffer and any bytes already received, queued operations, handlers, metadata, sequence numbers and what was agreed in the handshake. Bytes the remote sent on the old socket which had not yet been received are lost.
+// It waits for the handler or operation currently running to finish, so it must not be called from within one. It returns ErrStopped if the connection stops first.
+// The remote must know to expect the move, for example by reconnecting with a session ID (see Sessions)
+func (c *Conn) MigrateTo(nc net.Conn) error {
+	moved := make(chan struct{})
+	stopped := func() error {
+		if err := c.Err(); err != nil {
+			return err
+		}
+		return ErrStopped
+	}
+	if c.IsStopped() {
+		return stopped()
+	}
+	c.QueueOperationPriority(func(c *C) {
+		c.migrate(nc)
+		close(moved)
+	}, PriorityHigh)
+	select {
+	case <-moved:
+		return nil
+	case <-c.done:
+		return stopped()
+	}
+}
+
+// migrate swaps the socket and starts reading from the new one, once the old reader has finished handing over what it already received
+func (c *C) migrate(nc net.Conn) {
+	old, r := c.Conn.socket(), c.Conn.reader
+	c.Conn.sock.Store(&socket{Conn: nc})
+	close(r.quit)
+	old.Close()
+	// The old reader may be waiting for room in the read channel, so keep emptying it until it has gone
+	for {
+		select {
+		case b := <-c.Conn.readChan:
+			c.Conn.appendByte(b)
+			continue
+		case <-r.exited:
+		case <-time.After(time.Second):
+			// A socket which does not unblock its reads when closed is left behind
+		}
+		break
+	}
+	c.Conn.startReader(nc)
+}
@@ -0,0 +1,81 @@
+package bufconn_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestMigrateTo(t *testing.T) {
+	a1, b1 := net.Pipe()
+	a2, b2 := net.Pipe()
+	defer b1.Close()
+	defer b2.Close()
+	got := make(chan string, 10)
+	c := bufconn.NewConn(a1, func(c *bufconn.C) {
+		for {
+			msg, ok := c.TryReadMsg()
+			if !ok {
+				return
+			}
+			got <- msg
+		}
+	}, '\n')
+	defer c.Stop()
+	// Other goroutines look at the socket while it is being replaced
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				c.RemoteAddr()
+				c.LocalAddr()
+				c.Identity()
+				c.ServerName()
+			}
+		}
+	}()
+	b1.Write([]byte("before\n"))
+	if msg := <-got; msg != "before" {
+		t.Fatalf("expected before, got %q", msg)
+	}
+	if err := c.MigrateTo(a2); err != nil {
+		t.Fatal(err)
+	}
+	go b2.Write([]byte("after\n"))
+	select {
+	case msg := <-got:
+		if msg != "after" {
+			t.Fatalf("expected after, got %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message received on the new socket")
+	}
+	if c.IsStopped() {
+		t.Fatal("expected the connection to keep running after migrating")
+	}
+	c.SendMsg("reply", bufconn.PriorityNormal)
+	buf := make([]byte, 6)
+	b2.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := b2.Read(buf); err != nil || string(buf) != "reply\n" {
+		t.Fatalf("expected the reply on the new socket, got %q, %v", buf, err)
+	}
+}
+
+func TestMigrateToStopped(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	c.Stop()
+	a2, b2 := net.Pipe()
+	defer a2.Close()
+	defer b2.Close()
+	if err := c.MigrateTo(a2); err != bufconn.ErrStopped {
+		t.Fatalf("expected ErrStopped, got %v", err)
+	}
+}
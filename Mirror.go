@@ -0,0 +1,114 @@
+package bufconn
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// mirrorQueueSize is how many messages can wait to be mirrored before more are dropped
+const mirrorQueueSize = 1000
+
+// mirroredMsg is an inbound message waiting to be copied to a mirror
+type mirroredMsg struct {
+	msg   string
+	delim byte
+}
+
+// mirror copies inbound messages to a sink from its own goroutine, so that a slow sink never holds up the connection
+type mirror struct {
+	msgs chan mirroredMsg
+	// stop is closed when the mirror is replaced. msgs is never closed, as the loop may still be sending on it
+	stop    chan struct{}
+	dropped uint64
+}
+
+// SetMirror passes a copy of every message read from the buffer to sink, for shadow testing a new handler against real traffic. Control frames and raw reads are not mirrored, and the copies are redacted (see SetRedactor).
+// sink is called from its own goroutine, one message at a time, so it can not slow down or change what the message handler sees. If it falls too far behind, messages are dropped rather than waiting (see MirrorDropped).
+// If sink is nil, mirroring is turned off. It is safe to call at any time
+func (c *Conn) SetMirror(sink func(msg string)) {
+	if sink == nil {
+		c.setMirror(nil)
+		return
+	}
+	c.setMirror(func(m mirroredMsg) {
+		sink(m.msg)
+	})
+}
+
+// Shadow creates a shadow connection which is sent a copy of every message this connection reads (see SetMirror), and passes them to handler. Anything the shadow writes is thrown away.
+// The shadow keeps the delimiter each message ended with, so extra delimiters can be set up on it with SetDelimHandler. It is stopped when this connection stops
+func (c *Conn) Shadow(handler func(*C)) *Conn {
+	local, remote := net.Pipe()
+	shadow := NewConn(local, handler, c.mainDelim())
+	c.goTracked("shadow discard", func() {
+		io.Copy(io.Discard, remote)
+	})
+	c.goTracked("shadow stop", func() {
+		select {
+		case <-c.done:
+		case <-shadow.done:
+		}
+		shadow.Stop()
+		remote.Close()
+	})
+	c.setMirror(func(m mirroredMsg) {
+		remote.Write(append([]byte(m.msg), m.delim))
+	})
+	return shadow
+}
+
+// MirrorDropped returns the number of messages which were not mirrored because the sink fell behind
+func (c *Conn) MirrorDropped() uint64 {
+	m := c.currentMirror()
+	if m == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&m.dropped)
+}
+
+// setMirror replaces the mirror with one which passes messages to sink, or removes it if sink is nil
+func (c *Conn) setMirror(sink func(mirroredMsg)) {
+	c.mirrorLock.Lock()
+	defer c.mirrorLock.Unlock()
+	if old := c.currentMirror(); old != nil {
+		close(old.stop)
+	}
+	if sink == nil {
+		c.mirror.Store((*mirror)(nil))
+		return
+	}
+	m := &mirror{msgs: make(chan mirroredMsg, mirrorQueueSize), stop: make(chan struct{})}
+	c.goTracked("mirror", func() {
+		c.labelGoroutine("mirror")
+		for {
+			select {
+			case msg := <-m.msgs:
+				sink(msg)
+			case <-m.stop:
+				return
+			case <-c.done:
+				return
+			}
+		}
+	})
+	c.mirror.Store(m)
+}
+
+// currentMirror returns the connection's mirror, or nil if it does not have one
+func (c *Conn) currentMirror() *mirror {
+	m, _ := c.mirror.Load().(*mirror)
+	return m
+}
+
+// send queues a message to be mirrored, dropping it if the queue is full. It does nothing to a nil mirror
+func (m *mirror) send(msg string, delim byte) {
+	if m == nil {
+		return
+	}
+	select {
+	case m.msgs <- mirroredMsg{msg, delim}:
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+	}
+}
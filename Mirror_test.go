@@ -0,0 +1,69 @@
+package bufconn_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// mirroredConn creates a connection whose handler reads every message and throws it away
+func mirroredConn(t *testing.T) (*bufconn.Conn, net.Conn) {
+	a, b := net.Pipe()
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		c.ReadMsg(0)
+	}, '\n')
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	return c, b
+}
+
+func TestMirrorGetsCopies(t *testing.T) {
+	c, remote := mirroredConn(t)
+	mirrored := make(chan string, 10)
+	c.SetMirror(func(msg string) { mirrored <- msg })
+	remote.Write([]byte("a\nb\n"))
+	expectLines(t, mirrored, "a", "b")
+	c.SetMirror(nil)
+	remote.Write([]byte("c\n"))
+	select {
+	case msg := <-mirrored:
+		t.Fatalf("expected mirroring to be off, got %q", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestMirrorDropsWhenSinkFallsBehind(t *testing.T) {
+	c, remote := mirroredConn(t)
+	release := make(chan struct{})
+	defer close(release)
+	c.SetMirror(func(msg string) { <-release })
+	remote.Write([]byte(strings.Repeat("x\n", 1100)))
+	waitFor(t, "messages to be dropped", func() bool { return c.MirrorDropped() >= 99 })
+	if c.MirrorDropped() > 100 {
+		t.Fatalf("expected at most 100 messages to be dropped, got %d", c.MirrorDropped())
+	}
+}
+
+func TestShadowHandlesCopies(t *testing.T) {
+	c, remote := mirroredConn(t)
+	c.SetDelimHandler(0, func(c *bufconn.C) { c.ReadMsg(0) })
+	got := make(chan string, 10)
+	shadow := c.Shadow(func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		c.WriteMsg("thrown away")
+		got <- msg
+	})
+	shadow.SetDelimHandler(0, func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		got <- "binary " + msg
+	})
+	remote.Write([]byte("a\nb\x00"))
+	expectLines(t, got, "a", "binary b")
+	c.Stop()
+	waitFor(t, "the shadow to stop", shadow.IsStopped)
+}
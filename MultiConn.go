@@ -0,0 +1,300 @@
+package bufconn
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoBackends is returned by a MultiConn when none of its connections are still alive
+var ErrNoBackends = errors.New("no live backends")
+
+// BalanceStrategy decides which connection a MultiConn uses for the next call
+type BalanceStrategy int
+
+const (
+	// RoundRobin uses each live connection in turn
+	RoundRobin BalanceStrategy = iota
+	// LeastLoaded uses the live connection with the fewest queued operations
+	LeastLoaded
+)
+
+// MultiConn holds connections to several backends and spreads messages and operations across them.
+// Connections which have stopped are skipped, so as long as one backend is alive calls will still succeed
+type MultiConn struct {
+	lock        sync.Mutex
+	conns       []*Conn
+	strategy    BalanceStrategy
+	next        int
+	network     string
+	addrs       []string
+	handler     func(*C)
+	delim       byte
+	nextAddr    int
+	minConns    int
+	maintaining bool
+	backoff     Backoff
+	wake        chan struct{}
+	stopOnce    sync.Once
+	stopChan    chan struct{}
+}
+
+// NewMultiConn creates a MultiConn which balances across the given connections using the strategy
+func NewMultiConn(conns []*Conn, strategy BalanceStrategy) *MultiConn {
+	m := &MultiConn{
+		strategy: strategy,
+		backoff:  ConstantBackoff(time.Second),
+		wake:     make(chan struct{}, 1),
+		stopChan: make(chan struct{}),
+	}
+	for _, c := range conns {
+		m.add(c)
+	}
+	return m
+}
+
+// DialMultiConn dials every address and creates a MultiConn from the connections that succeeded. It only returns an error if no address could be reached
+func DialMultiConn(network string, addrs []string, handler func(*C), delim byte, strategy BalanceStrategy) (*MultiConn, error) {
+	conns := make([]*Conn, 0, len(addrs))
+	var lastErr error
+	for _, addr := range addrs {
+		c, err := Dial(network, addr, handler, delim)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		conns = append(conns, c)
+	}
+	if len(conns) == 0 {
+		if lastErr == nil {
+			lastErr = ErrNoBackends
+		}
+		return nil, lastErr
+	}
+	m := NewMultiConn(conns, strategy)
+	m.network, m.addrs, m.handler, m.delim = network, addrs, handler, delim
+	return m, nil
+}
+
+// add starts balancing across c, and makes sure the pool is topped back up when it stops
+func (m *MultiConn) add(c *Conn) {
+	m.lock.Lock()
+	if m.stopped() {
+		m.lock.Unlock()
+		c.Stop()
+		return
+	}
+	m.conns = append(m.conns, c)
+	m.lock.Unlock()
+	go func() {
+		<-c.Done()
+		select {
+		case m.wake <- struct{}{}:
+		default:
+		}
+	}()
+}
+
+// SetMinConns keeps at least n live connections open at all times, spread across the addresses, dialing new ones in the background as soon as any drop.
+// This means calls do not have to wait for a connection to be established. It only has an effect on a MultiConn created with DialMultiConn
+func (m *MultiConn) SetMinConns(n int) {
+	m.lock.Lock()
+	m.minConns = n
+	start := !m.maintaining && len(m.addrs) > 0
+	if start {
+		m.maintaining = true
+	}
+	m.lock.Unlock()
+	if start {
+		go m.maintain()
+	}
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SetBackoff sets how long to wait before each attempt to top up the connections (see SetMinConns) while backends are unreachable. The default is one second between attempts
+func (m *MultiConn) SetBackoff(b Backoff) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.backoff = b
+}
+
+// maintain tops up the connections whenever one stops, retrying according to the backoff while backends are unreachable
+func (m *MultiConn) maintain() {
+	attempt := 0
+	for {
+		var retry <-chan time.Time
+		if m.topUp() {
+			attempt = 0
+		} else {
+			attempt++
+			m.lock.Lock()
+			b := m.backoff
+			m.lock.Unlock()
+			retry = time.After(b.Delay(attempt))
+		}
+		select {
+		case <-m.stopChan:
+			return
+		case <-m.wake:
+		case <-retry:
+		}
+	}
+}
+
+// topUp forgets stopped connections and dials new ones until there are at least minConns. It returns false if it could not reach enough.
+// Nothing is dialed once the MultiConn has been stopped
+func (m *MultiConn) topUp() bool {
+	m.lock.Lock()
+	if m.stopped() {
+		m.lock.Unlock()
+		return true
+	}
+	live := m.conns[:0]
+	for _, c := range m.conns {
+		if !c.IsStopped() {
+			live = append(live, c)
+		}
+	}
+	m.conns = live
+	need := m.minConns - len(m.conns)
+	m.lock.Unlock()
+	for ; need > 0 && !m.stopped(); need-- {
+		c, err := m.dialNext()
+		if err != nil {
+			return false
+		}
+		m.add(c)
+	}
+	return true
+}
+
+// stopped returns whether Stop has been called
+func (m *MultiConn) stopped() bool {
+	select {
+	case <-m.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// dialNext dials the addresses in turn, continuing from where the last dial left off, until one succeeds
+func (m *MultiConn) dialNext() (*Conn, error) {
+	var lastErr error = ErrNoBackends
+	for i := 0; i < len(m.addrs); i++ {
+		m.lock.Lock()
+		addr := m.addrs[m.nextAddr]
+		m.nextAddr = (m.nextAddr + 1) % len(m.addrs)
+		m.lock.Unlock()
+		c, err := Dial(m.network, addr, m.handler, m.delim)
+		if err == nil {
+			return c, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// pick chooses the next live connection according to the strategy, skipping any whose circuit breaker is open
+func (m *MultiConn) pick() (*Conn, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	switch m.strategy {
+	case LeastLoaded:
+		var best *Conn
+		for _, c := range m.conns {
+			if !c.available() {
+				continue
+			}
+			if best == nil || len(c.opSignal) < len(best.opSignal) {
+				best = c
+			}
+		}
+		if best == nil {
+			return nil, ErrNoBackends
+		}
+		return best, nil
+	default:
+		for i := 0; i < len(m.conns); i++ {
+			c := m.conns[(m.next+i)%len(m.conns)]
+			if c.available() {
+				m.next = (m.next + i + 1) % len(m.conns)
+				return c, nil
+			}
+		}
+		return nil, ErrNoBackends
+	}
+}
+
+// QueueOperation queues the operation on one of the live connections
+func (m *MultiConn) QueueOperation(o func(*C)) error {
+	c, err := m.pick()
+	if err != nil {
+		return err
+	}
+	c.QueueOperation(o)
+	return nil
+}
+
+// SendMsg queues a write of the message on one of the live connections
+func (m *MultiConn) SendMsg(msg string) error {
+	return m.QueueOperation(func(c *C) {
+		c.WriteMsg(msg)
+	})
+}
+
+// Request sends the message on one of the live connections and waits for the next message from that backend as the reply.
+// If the timeout is zero, then no timeout will be used
+func (m *MultiConn) Request(msg string, timeout time.Duration) (string, error) {
+	type result struct {
+		msg string
+		err error
+	}
+	c, err := m.pick()
+	if err != nil {
+		return "", err
+	}
+	res := make(chan result, 1)
+	c.QueueOperation(func(c *C) {
+		if _, err := c.WriteMsg(msg); err != nil {
+			res <- result{"", err}
+			return
+		}
+		reply, err := c.ReadMsg(timeout)
+		res <- result{reply, err}
+	})
+	select {
+	case r := <-res:
+		return r.msg, r.err
+	case <-c.Done():
+		return "", ErrStopped
+	}
+}
+
+// Conns returns the connections which are still alive
+func (m *MultiConn) Conns() []*Conn {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	live := make([]*Conn, 0, len(m.conns))
+	for _, c := range m.conns {
+		if !c.IsStopped() {
+			live = append(live, c)
+		}
+	}
+	return live
+}
+
+// Stop stops every connection, and stops any new ones being dialed
+func (m *MultiConn) Stop() {
+	m.stopOnce.Do(func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		close(m.stopChan)
+		for _, c := range m.conns {
+			c.Stop()
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// pipeBackends creates n connections over pipes, returning the lines written to each
+func pipeBackends(t *testing.T, n int) ([]*bufconn.Conn, []<-chan string) {
+	t.Helper()
+	conns := make([]*bufconn.Conn, n)
+	got := make([]<-chan string, n)
+	for i := range conns {
+		a, b := net.Pipe()
+		t.Cleanup(func() { b.Close() })
+		conns[i] = bufconn.NewConn(a, nil, '\n')
+		got[i] = lines(b)
+	}
+	return conns, got
+}
+
+func TestMultiConnRoundRobin(t *testing.T) {
+	conns, got := pipeBackends(t, 3)
+	m := bufconn.NewMultiConn(conns, bufconn.RoundRobin)
+	defer m.Stop()
+	for _, msg := range []string{"a", "b", "c", "d"} {
+		if err := m.SendMsg(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLines(t, got[0], "a", "d")
+	expectLines(t, got[1], "b")
+	expectLines(t, got[2], "c")
+}
+
+func TestMultiConnSkipsStoppedConns(t *testing.T) {
+	conns, got := pipeBackends(t, 2)
+	m := bufconn.NewMultiConn(conns, bufconn.RoundRobin)
+	defer m.Stop()
+	conns[0].Stop()
+	<-conns[0].Done()
+	m.SendMsg("a")
+	m.SendMsg("b")
+	expectLines(t, got[1], "a", "b")
+	if n := len(m.Conns()); n != 1 {
+		t.Fatalf("expected 1 live conn, got %d", n)
+	}
+	conns[1].Stop()
+	<-conns[1].Done()
+	if err := m.SendMsg("c"); !errors.Is(err, bufconn.ErrNoBackends) {
+		t.Fatalf("expected ErrNoBackends, got %v", err)
+	}
+}
+
+func TestMultiConnRequest(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	go func() {
+		buf := make([]byte, 16)
+		n, _ := b.Read(buf)
+		b.Write(append([]byte("re:"), buf[:n]...))
+	}()
+	m := bufconn.NewMultiConn([]*bufconn.Conn{bufconn.NewConn(a, nil, '\n')}, bufconn.LeastLoaded)
+	defer m.Stop()
+	reply, err := m.Request("ping", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "re:ping" {
+		t.Fatalf("expected %q, got %q", "re:ping", reply)
+	}
+}
+
+func TestMultiConnKeepsMinConns(t *testing.T) {
+	l, accepted := listener(t)
+	m, err := bufconn.DialMultiConn("tcp", []string{l.Addr().String()}, nil, '\n', bufconn.RoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	m.SetBackoff(bufconn.ConstantBackoff(10 * time.Millisecond))
+	m.SetMinConns(3)
+	waitFor(t, "three connections", func() bool { return len(m.Conns()) == 3 })
+	remotes := []net.Conn{accept(t, accepted), accept(t, accepted), accept(t, accepted)}
+	remotes[0].Close()
+	accept(t, accepted)
+	waitFor(t, "the dropped connection to be replaced", func() bool { return len(m.Conns()) == 3 })
+}
+
+func TestMultiConnStopEndsTopUp(t *testing.T) {
+	l, accepted := listener(t)
+	m, err := bufconn.DialMultiConn("tcp", []string{l.Addr().String()}, nil, '\n', bufconn.RoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accept(t, accepted)
+	m.Stop()
+	m.SetMinConns(2)
+	select {
+	case <-accepted:
+		t.Fatal("expected no connections to be dialed after Stop")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := len(m.Conns()); n != 0 {
+		t.Fatalf("expected no live connections, got %d", n)
+	}
+}
@@ -0,0 +1,139 @@
+package bufconn
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBufferLimit is the error a connection stops with when LimitBuffer aborts it
+var ErrBufferLimit = errors.New("inbound buffer over limit")
+
+// ErrWriteStalled is the error a connection stops with when DetectWriteStall aborts it
+var ErrWriteStalled = errors.New("write to remote stalled")
+
+// ErrHandlerTimeout is the error a connection stops with when LimitHandlerTime aborts it
+var ErrHandlerTimeout = errors.New("message handler took too long")
+
+// SlowReadError is the error a connection stops with when the remote takes too long to finish sending a message
+type SlowReadError struct {
+	// Buffered is how many bytes of the unfinished message had been received
+	Buffered int
+	// Window is how long the remote was allowed to take
+	Window time.Duration
+}
+
+func (e *SlowReadError) Error() string {
+	return fmt.Sprintf("remote did not finish sending a message within %v (%d bytes received)", e.Window, e.Buffered)
+}
+
+// checkInterval is how often a policy with the given grace period should check the connection
+func checkInterval(grace time.Duration) time.Duration {
+	if grace/4 < 10*time.Millisecond {
+		return 10 * time.Millisecond
+	}
+	return grace / 4
+}
+
+// LimitBuffer stops the connection if more than limit bytes sit unconsumed in its inbound buffer for longer than grace (for example, a remote streaming data with no delimiter).
+// If onAbuse is not nil, it is called with the number of buffered bytes just before the connection is stopped. The connection stops with ErrBufferLimit, which can be retrieved using Err
+func LimitBuffer(conn *Conn, limit int, grace time.Duration, onAbuse func(c *Conn, buffered int)) {
+	var overSince time.Time
+	conn.every(checkInterval(grace), func(c *C) {
+		buffered := len(c.readBuf) + len(c.readChan)
+		if buffered <= limit {
+			overSince = time.Time{}
+			return
+		}
+		if overSince.IsZero() {
+			overSince = time.Now()
+			return
+		}
+		if time.Since(overSince) >= grace {
+			if onAbuse != nil {
+				onAbuse(c.Conn, buffered)
+			}
+			c.stopWithErr(ErrBufferLimit)
+		}
+	})
+}
+
+// RequireProgress stops the connection if the remote starts sending a message but does not send the delimiter within window, protecting against peers which trickle bytes to hold connections open.
+// The connection stops with a *SlowReadError, which can be retrieved using Err. This should only be used with protocols where every byte is part of a delimited message
+func RequireProgress(conn *Conn, window time.Duration) {
+	conn.every(checkInterval(window), func(c *C) {
+		if c.partialSince.IsZero() || time.Since(c.partialSince) < window {
+			return
+		}
+		buffered := len(c.readBuf)
+		for i := len(c.readBuf) - 1; i >= 0; i-- {
+			if c.isDelim(c.readBuf[i]) {
+				buffered = len(c.readBuf) - i - 1
+				break
+			}
+		}
+		c.stopWithErr(&SlowReadError{buffered, window})
+	})
+}
+
+// DetectWriteStall calls onStall (if not nil) when a single write has been blocked for longer than limit, which usually means the remote has stopped reading.
+// While a write is blocked, nothing else on the connection can run. If abort is true, the connection is then closed, which unblocks the write, and stops with ErrWriteStalled
+func DetectWriteStall(conn *Conn, limit time.Duration, abort bool, onStall func(c *Conn, blocked time.Duration)) {
+	watchStart(conn, &conn.writeStart, limit, func(blocked time.Duration) bool {
+		if onStall != nil {
+			onStall(conn, blocked)
+		}
+		if abort {
+			// The loop is stuck in the write, so it can not close the socket itself
+			conn.stopWithErr(ErrWriteStalled)
+			conn.socket().Close()
+		}
+		return !abort
+	})
+}
+
+// LimitHandlerTime calls onTimeout (if not nil) when a single call of the message handler has been running for longer than limit, which usually means it is stuck.
+// While the handler runs, nothing else on the connection can run. If abort is true, the connection is then stopped with ErrHandlerTimeout, though the handler itself can not be interrupted
+func LimitHandlerTime(conn *Conn, limit time.Duration, abort bool, onTimeout func(c *Conn, running time.Duration)) {
+	watchStart(conn, &conn.handlerStart, limit, func(running time.Duration) bool {
+		if onTimeout != nil {
+			onTimeout(conn, running)
+		}
+		if abort {
+			conn.stopWithErr(ErrHandlerTimeout)
+			conn.socket().Close()
+		}
+		return !abort
+	})
+}
+
+// watchStart checks in the background for *start, a time in unix nanoseconds which is zero while nothing is running, to be older than limit, and passes how long it has been to f.
+// f is only called once for each start time, and watching ends when the connection stops or f returns false
+func watchStart(conn *Conn, start *int64, limit time.Duration, f func(running time.Duration) bool) {
+	conn.goTracked("time limit", func() {
+		t := time.NewTicker(checkInterval(limit))
+		defer conn.track("timer", "time limit ticker")()
+		defer t.Stop()
+		var reported int64
+		for {
+			select {
+			case <-conn.done:
+				return
+			case <-t.C:
+			}
+			started := atomic.LoadInt64(start)
+			if started == 0 || started == reported {
+				continue
+			}
+			running := time.Since(time.Unix(0, started))
+			if running < limit {
+				continue
+			}
+			reported = started
+			if !f(running) {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestLimitBuffer(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	abused := make(chan int, 1)
+	bufconn.LimitBuffer(c, 100, 50*time.Millisecond, func(c *bufconn.Conn, buffered int) {
+		abused <- buffered
+	})
+	// Data with no delimiter can never be read by a handler, so it sits in the buffer
+	go b.Write([]byte(strings.Repeat("x", 500)))
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the connection to stop")
+	}
+	if !errors.Is(c.Err(), bufconn.ErrBufferLimit) {
+		t.Fatalf("expected ErrBufferLimit, got %v", c.Err())
+	}
+	if n := <-abused; n <= 100 {
+		t.Fatalf("expected more than 100 bytes to be reported, got %d", n)
+	}
+}
+
+func TestLimitBufferAllowsShortBursts(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	bufconn.LimitBuffer(c, 100, 50*time.Millisecond, nil)
+	// Complete messages are read straight away, so the buffer never stays over the limit
+	for i := 0; i < 20; i++ {
+		b.Write([]byte(strings.Repeat("y", 50) + "\n"))
+	}
+	time.Sleep(150 * time.Millisecond)
+	if c.IsStopped() {
+		t.Fatalf("expected the connection to keep running, stopped with %v", c.Err())
+	}
+}
+
+func TestRequireProgress(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	bufconn.RequireProgress(c, 50*time.Millisecond)
+	b.Write([]byte("done\npart"))
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the connection to stop")
+	}
+	var slow *bufconn.SlowReadError
+	if !errors.As(c.Err(), &slow) || slow.Buffered != 4 {
+		t.Fatalf("expected a SlowReadError with 4 bytes buffered, got %v", c.Err())
+	}
+}
+
+func TestRequireProgressAllowsFinishedMessages(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	bufconn.RequireProgress(c, 50*time.Millisecond)
+	// Each message is finished well within the window, even though the stream never pauses for long
+	for i := 0; i < 10; i++ {
+		b.Write([]byte("part"))
+		time.Sleep(10 * time.Millisecond)
+		b.Write([]byte("ial\n"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.IsStopped() {
+		t.Fatalf("expected the connection to keep running, stopped with %v", c.Err())
+	}
+}
+
+func TestDetectWriteStallAborts(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	stalled := make(chan time.Duration, 1)
+	bufconn.DetectWriteStall(c, 30*time.Millisecond, true, func(c *bufconn.Conn, blocked time.Duration) { stalled <- blocked })
+	// Nothing reads the other end of the pipe, so the write blocks
+	c.SendMsg("hello", bufconn.PriorityNormal)
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the connection to stop")
+	}
+	if !errors.Is(c.Err(), bufconn.ErrWriteStalled) {
+		t.Fatalf("expected ErrWriteStalled, got %v", c.Err())
+	}
+	if blocked := <-stalled; blocked < 30*time.Millisecond {
+		t.Fatalf("expected to be told the write was blocked for at least the limit, got %v", blocked)
+	}
+}
+
+func TestDetectWriteStallReportsOnce(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	stalls := make(chan struct{}, 10)
+	bufconn.DetectWriteStall(c, 20*time.Millisecond, false, func(c *bufconn.Conn, blocked time.Duration) { stalls <- struct{}{} })
+	c.SendMsg("hello", bufconn.PriorityNormal)
+	time.Sleep(150 * time.Millisecond)
+	if c.IsStopped() {
+		t.Fatalf("expected the connection to keep running, stopped with %v", c.Err())
+	}
+	if n := len(stalls); n != 1 {
+		t.Fatalf("expected the stalled write to be reported once, got %d", n)
+	}
+	// Once the remote reads, the write finishes
+	got := lines(b)
+	expectLines(t, got, "hello")
+}
+
+func TestLimitHandlerTimeAborts(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	release := make(chan struct{})
+	defer close(release)
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		c.TryReadMsg()
+		<-release
+	}, '\n')
+	defer c.Stop()
+	timedOut := make(chan time.Duration, 1)
+	bufconn.LimitHandlerTime(c, 30*time.Millisecond, true, func(c *bufconn.Conn, running time.Duration) { timedOut <- running })
+	b.Write([]byte("stuck\n"))
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the connection to stop")
+	}
+	if !errors.Is(c.Err(), bufconn.ErrHandlerTimeout) {
+		t.Fatalf("expected ErrHandlerTimeout, got %v", c.Err())
+	}
+	if running := <-timedOut; running < 30*time.Millisecond {
+		t.Fatalf("expected to be told the handler ran for at least the limit, got %v", running)
+	}
+}
+
+func TestLimitHandlerTimeReportsEachCall(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		msg, _ := c.TryReadMsg()
+		if msg == "slow" {
+			time.Sleep(60 * time.Millisecond)
+		}
+	}, '\n')
+	defer c.Stop()
+	timeouts := make(chan struct{}, 10)
+	bufconn.LimitHandlerTime(c, 20*time.Millisecond, false, func(c *bufconn.Conn, running time.Duration) { timeouts <- struct{}{} })
+	b.Write([]byte("slow\nfast\nslow\n"))
+	time.Sleep(200 * time.Millisecond)
+	if c.IsStopped() {
+		t.Fatalf("expected the connection to keep running, stopped with %v", c.Err())
+	}
+	if n := len(timeouts); n != 2 {
+		t.Fatalf("expected each slow call to be reported once, got %d", n)
+	}
+}
@@ -0,0 +1,70 @@
+package bufconn
+
+import "sync/atomic"
+
+// maxPrefetchRead is the most bytes the reader asks the socket for at once
+const maxPrefetchRead = 4096
+
+// SetPrefetch sets how far the reader may pull from the socket ahead of the handler. Once window bytes are waiting to be read (see Buffered), nothing more is taken from the socket until the handler catches up, so the remote is slowed down by the transport instead.
+// A small window keeps as little as possible buffered, which suits latency sensitive protocols, and a large one lets the reader take big chunks at once for throughput.
+// The reader carries on past the window while everything buffered is part of one unfinished message, so that messages longer than the window can still be read.
+// If window is zero or less, which is the default, bytes are pulled one at a time with no limit. It is safe to call at any time from any goroutine
+func (c *Conn) SetPrefetch(window int) {
+	if window < 0 {
+		window = 0
+	}
+	atomic.StoreInt64(&c.prefetch, int64(window))
+	c.wakeReader()
+}
+
+// Prefetch returns the current prefetch window in bytes, or zero if there is none
+func (c *Conn) Prefetch() int {
+	return int(atomic.LoadInt64(&c.prefetch))
+}
+
+// readSize waits until the reader may pull from the socket, then returns how many bytes it should ask for. partial is the number of bytes read since the last delimiter.
+// It returns zero if the connection was stopped, or quit was closed, while waiting
+func (c *Conn) readSize(partial int, quit <-chan struct{}) int {
+	for {
+		window := c.Prefetch()
+		if window == 0 {
+			return 1
+		}
+		held := c.Buffered()
+		if held < window {
+			return minInt(window-held, maxPrefetchRead)
+		}
+		if held <= partial {
+			// Nothing buffered can be handled until more of the message arrives
+			return minInt(window, maxPrefetchRead)
+		}
+		select {
+		case <-c.prefetchWake:
+		case <-c.done:
+			return 0
+		case <-quit:
+			return 0
+		}
+	}
+}
+
+// consumed takes n from the count of buffered bytes, once they have been removed from the buffer, and lets the reader know there may be room to read more
+func (c *Conn) consumed(n int) {
+	atomic.AddInt64(&c.buffered, -int64(n))
+	c.wakeReader()
+}
+
+// wakeReader wakes the reader if it is waiting for room in the prefetch window
+func (c *Conn) wakeReader() {
+	select {
+	case c.prefetchWake <- struct{}{}:
+	default:
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
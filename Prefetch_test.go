@@ -0,0 +1,80 @@
+package bufconn_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// prefetchConn creates a connection with the prefetch window, whose handler is held up until the returned function is called. Received messages are sent on the returned channel
+func prefetchConn(t *testing.T, window int) (*bufconn.Conn, net.Conn, <-chan string, func()) {
+	a, b := net.Pipe()
+	got := make(chan string, 100)
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		got <- msg
+	}, '\n')
+	c.SetPrefetch(window)
+	release, started := make(chan struct{}), make(chan struct{})
+	c.QueueOperation(func(*bufconn.C) {
+		close(started)
+		<-release
+	})
+	<-started
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	return c, b, got, func() { close(release) }
+}
+
+func TestPrefetchLimitsBuffered(t *testing.T) {
+	c, remote, got, release := prefetchConn(t, 10)
+	written := make(chan struct{})
+	go func() {
+		remote.Write([]byte(strings.Repeat("abcd\n", 20)))
+		close(written)
+	}()
+	waitFor(t, "the window to fill", func() bool { return c.Buffered() == 10 })
+	select {
+	case <-written:
+		t.Fatal("expected the remote to be held up by the window")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := c.Buffered(); n != 10 {
+		t.Fatalf("expected 10 bytes buffered, got %d", n)
+	}
+	release()
+	for i := 0; i < 20; i++ {
+		expectLines(t, got, "abcd")
+	}
+	<-written
+}
+
+func TestPrefetchReadsMessagesLongerThanWindow(t *testing.T) {
+	_, remote, got, release := prefetchConn(t, 4)
+	go remote.Write([]byte(strings.Repeat("x", 50) + "\n"))
+	release()
+	expectLines(t, got, strings.Repeat("x", 50))
+}
+
+func TestPrefetchWindow(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	if c.Prefetch() != 0 {
+		t.Fatalf("expected no window by default, got %d", c.Prefetch())
+	}
+	c.SetPrefetch(-5)
+	if c.Prefetch() != 0 {
+		t.Fatalf("expected a negative window to mean none, got %d", c.Prefetch())
+	}
+	c.SetPrefetch(64)
+	if c.Prefetch() != 64 {
+		t.Fatalf("expected a window of 64, got %d", c.Prefetch())
+	}
+}
@@ -0,0 +1,127 @@
+package bufconn
+
+import "errors"
+
+// Priority decides which queued operations run first. Higher priorities run before lower ones, but a lower priority operation is never passed over more than starveLimit times in a row
+type Priority int
+
+const (
+	// PriorityLow is for bulk work which can wait
+	PriorityLow Priority = iota
+	// PriorityNormal is the priority of operations queued with QueueOperation
+	PriorityNormal
+	// PriorityHigh is for control traffic and small replies which should not wait behind bulk work
+	PriorityHigh
+	numPriorities = 3
+)
+
+// starveLimit is how many times a waiting operation can be passed over for a higher priority one before it is run anyway
+const starveLimit = 8
+
+// ErrQueueFull is returned when something could not be queued without waiting, because the connection's queue was full
+var ErrQueueFull = errors.New("operation queue full")
+
+// QueueOperationPriority adds an operation to the end of the queue for its priority, and it will be performed when possible. If the connection stops while waiting for room, the operation is dropped
+func (c *Conn) QueueOperationPriority(o func(*C), p Priority) {
+	c.queueOp(o, p, true)
+}
+
+// queueOp adds an operation to the queue for its priority. If wait is false and the queue is full, it returns ErrQueueFull rather than waiting for room.
+// It returns ErrStopped if the connection has stopped, as the operation would never run
+func (c *Conn) queueOp(o func(*C), p Priority, wait bool) error {
+	p = p.clamp()
+	select {
+	case <-c.done:
+		return ErrStopped
+	default:
+	}
+	if wait {
+		select {
+		case c.opLanes[p] <- o:
+		case <-c.done:
+			return ErrStopped
+		}
+	} else {
+		select {
+		case c.opLanes[p] <- o:
+		default:
+			return ErrQueueFull
+		}
+	}
+	// There is room in the signal channel for every queued operation, so this only waits while the loop catches up
+	select {
+	case c.opSignal <- struct{}{}:
+	case <-c.done:
+	}
+	return nil
+}
+
+// clamp returns the nearest valid priority
+func (p Priority) clamp() Priority {
+	if p < PriorityLow {
+		return PriorityLow
+	} else if p > PriorityHigh {
+		return PriorityHigh
+	}
+	return p
+}
+
+// SendMsg queues an operation which writes the message with the given priority. If the connection has a journal, the message is recorded in it until written.
+// If the connection's circuit breaker is open, nothing is queued and ErrCircuitOpen is returned. If the connection has a send queue which is full, what happens depends on its policy (see SetSendQueue).
+// If the connection stops before the message could be queued, ErrStopped is returned
+func (c *Conn) SendMsg(msg string, p Priority) error {
+	return c.sendMsg(msg, p, true)
+}
+
+// sendMsg is the same as SendMsg, but if wait is false, ErrQueueFull is returned rather than waiting for room in a full queue
+func (c *Conn) sendMsg(msg string, p Priority, wait bool) error {
+	s := c.settings()
+	if err := s.breaker.ready(); err != nil {
+		return err
+	}
+	if q := s.sendQueue; q != nil {
+		return q.push(c, msg, p.clamp(), wait)
+	}
+	j := s.journal
+	var seq uint64
+	if j != nil {
+		seq = j.record(c.id, msg)
+	}
+	err := c.queueOp(func(c *C) {
+		if _, err := c.writeMsg(msg, priorityFlags(p)); err == nil && j != nil {
+			j.done(seq)
+		}
+	}, p, wait)
+	if err != nil && j != nil {
+		j.done(seq)
+	}
+	return err
+}
+
+// nextOp takes the next operation to run. It must only be called after receiving from opSignal, which guarantees there is one waiting
+func (c *Conn) nextOp() func(*C) {
+	pick := -1
+	// Anything which has been passed over too many times goes first, lowest priority first as it has been waiting longest
+	for p := 0; p < numPriorities; p++ {
+		if len(c.opLanes[p]) > 0 && c.opSkipped[p] >= starveLimit {
+			pick = p
+			break
+		}
+	}
+	if pick < 0 {
+		for p := numPriorities - 1; p >= 0; p-- {
+			if len(c.opLanes[p]) > 0 {
+				pick = p
+				break
+			}
+		}
+	}
+	for p := 0; p < numPriorities; p++ {
+		if p == pick {
+			c.opSkipped[p] = 0
+		} else if p < pick && len(c.opLanes[p]) > 0 {
+			c.opSkipped[p]++
+		}
+	}
+	return <-c.opLanes[pick]
+}
@@ -0,0 +1,95 @@
+package bufconn_test
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// blockedConn creates a connection whose processing loop is busy until the returned function is called, and returns the remote end of its pipe
+func blockedConn(t *testing.T) (*bufconn.Conn, net.Conn, func()) {
+	a, b := net.Pipe()
+	c := bufconn.NewConn(a, nil, '\n')
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	release, started := make(chan struct{}), make(chan struct{})
+	c.QueueOperation(func(c *bufconn.C) {
+		close(started)
+		<-release
+	})
+	<-started
+	return c, b, func() { close(release) }
+}
+
+// orderRecorder records the names of operations in the order they run
+type orderRecorder struct {
+	lock  sync.Mutex
+	order []string
+}
+
+func (r *orderRecorder) op(name string) func(*bufconn.C) {
+	return func(*bufconn.C) {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.order = append(r.order, name)
+	}
+}
+
+func (r *orderRecorder) wait(t *testing.T, n int) []string {
+	t.Helper()
+	waitFor(t, "operations to run", func() bool {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return len(r.order) == n
+	})
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string{}, r.order...)
+}
+
+func TestPriorityHigherRunsFirst(t *testing.T) {
+	c, _, release := blockedConn(t)
+	r := &orderRecorder{}
+	c.QueueOperationPriority(r.op("low"), bufconn.PriorityLow)
+	c.QueueOperation(r.op("normal"))
+	c.QueueOperationPriority(r.op("high"), bufconn.PriorityHigh)
+	c.QueueOperationPriority(r.op("higher"), bufconn.PriorityHigh+5)
+	release()
+	if got := fmt.Sprint(r.wait(t, 4)); got != "[high higher normal low]" {
+		t.Fatalf("unexpected order %s", got)
+	}
+}
+
+func TestPriorityLowIsNotStarved(t *testing.T) {
+	c, _, release := blockedConn(t)
+	r := &orderRecorder{}
+	c.QueueOperationPriority(r.op("low"), bufconn.PriorityLow)
+	for i := 0; i < 10; i++ {
+		c.QueueOperationPriority(r.op("high"), bufconn.PriorityHigh)
+	}
+	release()
+	order := r.wait(t, 11)
+	for i, name := range order {
+		if name == "low" {
+			if i > 8 {
+				t.Fatalf("expected the low priority operation to be passed over at most 8 times, ran at %d", i)
+			}
+			return
+		}
+	}
+}
+
+func TestPrioritySendMsg(t *testing.T) {
+	c, remote, release := blockedConn(t)
+	got := lines(remote)
+	c.SendMsg("bulk", bufconn.PriorityLow)
+	c.SendMsg("reply", bufconn.PriorityNormal)
+	c.SendMsg("control", bufconn.PriorityHigh)
+	release()
+	expectLines(t, got, "control", "reply", "bulk")
+}
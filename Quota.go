@@ -0,0 +1,79 @@
+package bufconn
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Quota limits the number of bytes a connection may receive and send
+type Quota struct {
+	// In and Out are the most bytes which may be received and sent. Zero means no limit
+	In, Out int64
+	// Window is the period the limits apply to, starting again at the end of each one. If zero, the limits apply to the whole life of the connection
+	Window time.Duration
+}
+
+// QuotaError is the error a connection stops with when it goes over its quota
+type QuotaError struct {
+	Direction Direction
+	Limit     int64
+	Window    time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	dir := "received"
+	if e.Direction == Outbound {
+		dir = "sent"
+	}
+	if e.Window == 0 {
+		return fmt.Sprintf("quota of %d bytes %s exceeded", e.Limit, dir)
+	}
+	return fmt.Sprintf("quota of %d bytes %s per %v exceeded", e.Limit, dir, e.Window)
+}
+
+// quotaCounter counts bytes against a quota. It is safe for concurrent use
+type quotaCounter struct {
+	lock        sync.Mutex
+	quota       Quota
+	windowStart time.Time
+	in, out     int64
+}
+
+// SetQuota stops the connection with a *QuotaError once it receives or sends more than the quota allows. A write which would go over the quota is not made.
+// Bytes are counted from when the quota is set. If both limits are zero, the quota is removed
+func (c *Conn) SetQuota(q Quota) {
+	if q.In <= 0 && q.Out <= 0 {
+		c.quota.Store((*quotaCounter)(nil))
+		return
+	}
+	c.quota.Store(&quotaCounter{quota: q, windowStart: time.Now()})
+}
+
+// quotaCounter returns the connection's quota counter, or nil if it has no quota
+func (c *Conn) quotaCounter() *quotaCounter {
+	q, _ := c.quota.Load().(*quotaCounter)
+	return q
+}
+
+// add counts n bytes in the direction, returning a *QuotaError if that goes over the limit. Bytes which go over are not counted
+func (q *quotaCounter) add(dir Direction, n int) error {
+	if q == nil {
+		return nil
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.quota.Window > 0 && time.Since(q.windowStart) >= q.quota.Window {
+		q.windowStart = time.Now()
+		q.in, q.out = 0, 0
+	}
+	used, limit := &q.in, q.quota.In
+	if dir == Outbound {
+		used, limit = &q.out, q.quota.Out
+	}
+	if limit > 0 && *used+int64(n) > limit {
+		return &QuotaError{dir, limit, q.quota.Window}
+	}
+	*used += int64(n)
+	return nil
+}
@@ -0,0 +1,74 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestQuotaInbound(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	c.SetQuota(bufconn.Quota{In: 10})
+	b.Write([]byte("12345\n"))
+	b.Write([]byte("12345\n"))
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the connection to stop")
+	}
+	var quotaErr *bufconn.QuotaError
+	if !errors.As(c.Err(), &quotaErr) || quotaErr.Direction != bufconn.Inbound || quotaErr.Limit != 10 {
+		t.Fatalf("expected an inbound QuotaError, got %v", c.Err())
+	}
+}
+
+func TestQuotaOutboundWriteNotMade(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	c.SetQuota(bufconn.Quota{Out: 8})
+	got := lines(b)
+	if err := writeNow(c, "fits"); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, got, "fits")
+	var quotaErr *bufconn.QuotaError
+	if err := writeNow(c, "too long"); !errors.As(err, &quotaErr) || quotaErr.Direction != bufconn.Outbound {
+		t.Fatalf("expected an outbound QuotaError, got %v", err)
+	}
+	waitFor(t, "the connection to stop", c.IsStopped)
+	select {
+	case msg := <-got:
+		t.Fatalf("expected the write over the quota not to be made, got %q", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestQuotaWindowResets(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	c.SetQuota(bufconn.Quota{In: 6, Window: 50 * time.Millisecond})
+	for i := 0; i < 4; i++ {
+		b.Write([]byte("12345\n"))
+		time.Sleep(60 * time.Millisecond)
+	}
+	if c.IsStopped() {
+		t.Fatalf("expected each window to start again, stopped with %v", c.Err())
+	}
+}
+
+func TestQuotaErrorMessage(t *testing.T) {
+	e := &bufconn.QuotaError{Direction: bufconn.Outbound, Limit: 100, Window: time.Minute}
+	if e.Error() != "quota of 100 bytes sent per 1m0s exceeded" {
+		t.Fatalf("unexpected message %q", e.Error())
+	}
+}
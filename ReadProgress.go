@@ -0,0 +1,29 @@
+package bufconn
+
+import "time"
+
+// ReadMsgProgress is the same as ReadMsg, but while it waits, progress is called with how many bytes of the next message have arrived and how long it has been waiting, so a slow sender can be told apart from a dead one before the delimiter arrives.
+// progress is called whenever more bytes arrive, and also every interval while nothing does, unless interval is zero. It is not called once the message is complete.
+// Like ReadMsg, it returns the connection's error if it stops before the message is complete
+func (c *C) ReadMsgProgress(timeout, interval time.Duration, progress func(received int, elapsed time.Duration)) (string, error) {
+	start := time.Now()
+	received, reported := 0, start
+	for {
+		elapsed := time.Since(start)
+		if elapsed > timeout && timeout != 0 {
+			return "", c.Conn.readTimedOut()
+		}
+		stopped := c.Conn.IsStopped()
+		if msg, ok := c.TryReadMsg(); ok {
+			return msg, nil
+		}
+		if stopped {
+			return "", c.Conn.stoppedErr()
+		}
+		// With no complete message, everything buffered is part of the next one
+		if n := len(c.Conn.readBuf); n != received || (interval != 0 && time.Since(reported) >= interval) {
+			received, reported = n, time.Now()
+			progress(received, elapsed)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package bufconn_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// progressLog records each progress report
+type progressLog struct {
+	lock     sync.Mutex
+	received []int
+	elapsed  []time.Duration
+}
+
+func (p *progressLog) report(received int, elapsed time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.received = append(p.received, received)
+	p.elapsed = append(p.elapsed, elapsed)
+}
+
+func (p *progressLog) len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.received)
+}
+
+// readProgress reads a message with ReadMsgProgress from an operation, returning the message and error once it returns
+func readProgress(c *bufconn.Conn, timeout, interval time.Duration, p *progressLog) (<-chan string, <-chan error) {
+	msgs, errs := make(chan string, 1), make(chan error, 1)
+	c.QueueOperation(func(c *bufconn.C) {
+		msg, err := c.ReadMsgProgress(timeout, interval, p.report)
+		msgs <- msg
+		errs <- err
+	})
+	return msgs, errs
+}
+
+func TestReadMsgProgressReportsArrivals(t *testing.T) {
+	c, remote := pipeConn(t)
+	p := &progressLog{}
+	msgs, errs := readProgress(c, 0, 0, p)
+	remote.Write([]byte("abc"))
+	waitFor(t, "the first bytes to be reported", func() bool { return p.len() > 0 })
+	remote.Write([]byte("de"))
+	waitFor(t, "more bytes to be reported", func() bool {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return p.received[len(p.received)-1] == 5
+	})
+	remote.Write([]byte("f\n"))
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-msgs; msg != "abcdef" {
+		t.Fatalf("expected abcdef, got %q", msg)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i := 1; i < len(p.received); i++ {
+		if p.received[i] <= p.received[i-1] {
+			t.Fatalf("expected reports only when more bytes arrived, got %v", p.received)
+		}
+	}
+}
+
+func TestReadMsgProgressInterval(t *testing.T) {
+	c, remote := pipeConn(t)
+	p := &progressLog{}
+	msgs, errs := readProgress(c, time.Second, 10*time.Millisecond, p)
+	remote.Write([]byte("ab"))
+	time.Sleep(60 * time.Millisecond)
+	remote.Write([]byte("\n"))
+	if err := <-errs; err != nil || <-msgs != "ab" {
+		t.Fatalf("expected ab, got %v", err)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.received) < 3 || p.received[len(p.received)-1] != 2 || p.elapsed[len(p.elapsed)-1] < 40*time.Millisecond {
+		t.Fatalf("expected repeated reports while waiting, got %v at %v", p.received, p.elapsed)
+	}
+}
+
+func TestReadMsgProgressTimeout(t *testing.T) {
+	c, remote := pipeConn(t)
+	p := &progressLog{}
+	_, errs := readProgress(c, 30*time.Millisecond, 0, p)
+	remote.Write([]byte("slow"))
+	if err := <-errs; !errors.Is(err, bufconn.ErrReadTimeout) {
+		t.Fatalf("expected ErrReadTimeout, got %v", err)
+	}
+}
+
+func TestReadMsgProgressStopped(t *testing.T) {
+	c, _ := pipeConn(t)
+	_, errs := readProgress(c, 0, 0, &progressLog{})
+	time.Sleep(10 * time.Millisecond)
+	c.Stop()
+	if err := <-errs; !errors.Is(err, bufconn.ErrStopped) {
+		t.Fatalf("expected ErrStopped, got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package bufconn
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrReadTimeout is returned by ReadMsg when no message arrived in time. If the connection cancels reads on a timeout (see SetTimeoutCancelsRead), it is also the error the connection stops with
+var ErrReadTimeout = errors.New("message read timeout")
+
+// SetTimeoutCancelsRead makes a ReadMsg or Read timeout give up on the remote entirely. The socket's read deadline is set so that the goroutine blocked reading it returns straight away, even if the remote has silently gone, and the connection is stopped with ErrReadTimeout.
+// Without this, a timeout only returns the error, and the connection carries on waiting for the remote
+func (c *Conn) SetTimeoutCancelsRead(cancel bool) {
+	c.updateSettings(func(s *settings) {
+		s.timeoutCancelsRead = cancel
+	})
+}
+
+// readTimedOut is called when ReadMsg or Read times out, and cancels the socket read if the connection is set to
+func (c *Conn) readTimedOut() error {
+	if c.settings().timeoutCancelsRead {
+		c.socket().SetReadDeadline(time.Now())
+		c.stopWithErr(ErrReadTimeout)
+	}
+	return ErrReadTimeout
+}
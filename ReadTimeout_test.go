@@ -0,0 +1,91 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// deadlineConn records when its read deadline was last set
+type deadlineConn struct {
+	net.Conn
+	lock     sync.Mutex
+	deadline time.Time
+}
+
+func (d *deadlineConn) SetReadDeadline(t time.Time) error {
+	d.lock.Lock()
+	d.deadline = t
+	d.lock.Unlock()
+	return d.Conn.SetReadDeadline(t)
+}
+
+// readNow reads a message with the timeout from an operation and returns the error
+func readNow(c *bufconn.Conn, timeout time.Duration) error {
+	errs := make(chan error, 1)
+	c.QueueOperation(func(c *bufconn.C) {
+		_, err := c.ReadMsg(timeout)
+		errs <- err
+	})
+	return <-errs
+}
+
+func TestReadTimeoutKeepsConnection(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	if err := readNow(c, 20*time.Millisecond); !errors.Is(err, bufconn.ErrReadTimeout) {
+		t.Fatalf("expected ErrReadTimeout, got %v", err)
+	}
+	if c.IsStopped() {
+		t.Fatal("expected the connection to carry on")
+	}
+	go b.Write([]byte("late\n"))
+	if err := readNow(c, time.Second); err != nil {
+		t.Fatalf("expected the late message to be read, got %v", err)
+	}
+}
+
+func TestReadTimeoutCancelsRead(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	sock := &deadlineConn{Conn: a}
+	c := bufconn.NewConn(sock, nil, '\n')
+	defer c.Stop()
+	c.SetTimeoutCancelsRead(true)
+	if err := readNow(c, 20*time.Millisecond); !errors.Is(err, bufconn.ErrReadTimeout) {
+		t.Fatalf("expected ErrReadTimeout, got %v", err)
+	}
+	waitFor(t, "the connection to stop", c.IsStopped)
+	if !errors.Is(c.Err(), bufconn.ErrReadTimeout) {
+		t.Fatalf("expected the connection to stop with ErrReadTimeout, got %v", c.Err())
+	}
+	// The deadline unblocks the socket read straight away
+	sock.lock.Lock()
+	defer sock.lock.Unlock()
+	if sock.deadline.IsZero() || sock.deadline.After(time.Now()) {
+		t.Fatalf("expected the read deadline to be set to the time of the timeout, got %v", sock.deadline)
+	}
+}
+
+func TestReadTimeoutCancelsRawRead(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	c.SetTimeoutCancelsRead(true)
+	errs := make(chan error, 1)
+	c.QueueOperation(func(c *bufconn.C) {
+		_, err := c.Read(4, 20*time.Millisecond)
+		errs <- err
+	})
+	if err := <-errs; !errors.Is(err, bufconn.ErrReadTimeout) {
+		t.Fatalf("expected ErrReadTimeout, got %v", err)
+	}
+	waitFor(t, "the connection to stop", c.IsStopped)
+}
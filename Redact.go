@@ -0,0 +1,38 @@
+package bufconn
+
+import "regexp"
+
+// SetRedactor sets a function which is applied to message content before the package passes it anywhere outside of handlers: auditors, debug logs, and mirrors and shadows (see SetMirror).
+// Use it to stop credentials and other sensitive data reaching logs. If nil, content is passed on unchanged.
+// Journals and spools are not redacted, as they hold messages so that they can be delivered later, so their files should be kept somewhere only trusted users can read
+func (c *Conn) SetRedactor(f func(msg string) string) {
+	c.updateSettings(func(s *settings) {
+		s.redactor = f
+	})
+}
+
+// redact applies the redactor, if there is one, to message content
+func (c *Conn) redact(msg string) string {
+	redactor := c.settings().redactor
+	if redactor == nil {
+		return msg
+	}
+	return redactor(msg)
+}
+
+// RedactPatterns creates a redactor which replaces everything matching any of the patterns with "***". If a pattern has capture groups, only the first group is replaced,
+// so for example `password=(\S+)` masks the password but keeps the key
+func RedactPatterns(patterns ...*regexp.Regexp) func(msg string) string {
+	return func(msg string) string {
+		for _, p := range patterns {
+			msg = p.ReplaceAllStringFunc(msg, func(match string) string {
+				sub := p.FindStringSubmatchIndex(match)
+				if len(sub) < 4 || sub[2] < 0 {
+					return "***"
+				}
+				return match[:sub[2]] + "***" + match[sub[3]:]
+			})
+		}
+		return msg
+	}
+}
@@ -0,0 +1,48 @@
+package bufconn_test
+
+import (
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestRedactPatterns(t *testing.T) {
+	r := bufconn.RedactPatterns(regexp.MustCompile(`password=(\S+)`), regexp.MustCompile(`\d{16}`))
+	got := r("login password=hunter2 card 1234567812345678")
+	if want := "login password=*** card ***"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRedactorAppliesToMirror(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	handled := make(chan string, 1)
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		handled <- msg
+	}, '\n')
+	defer c.Stop()
+	c.SetRedactor(bufconn.RedactPatterns(regexp.MustCompile(`password=(\S+)`)))
+	mirrored := make(chan string, 1)
+	c.SetMirror(func(msg string) {
+		mirrored <- msg
+	})
+	b.Write([]byte("login password=hunter2\n"))
+	for _, want := range []struct {
+		from chan string
+		msg  string
+	}{{handled, "login password=hunter2"}, {mirrored, "login password=***"}} {
+		select {
+		case got := <-want.from:
+			if got != want.msg {
+				t.Fatalf("expected %q, got %q", want.msg, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %q, got nothing", want.msg)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package bufconn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrUnknownType is returned when a message has a type tag, or a value has a Go type, which has not been registered
+var ErrUnknownType = errors.New("unknown message type")
+
+// Envelope is a single message on the wire, with a tag saying what type its body is. It is encoded as a JSON object, so the delimiter should be a byte which JSON never contains unescaped, such as '\n'
+type Envelope struct {
+	Type string `json:"type"`
+	Body string `json:"body"`
+	// Trace is a correlation ID which follows a message, and everything written while handling it, across connections and hops
+	Trace string `json:"trace,omitempty"`
+	// ReceivedAt is when a received envelope arrived from the socket (see C.ReceivedAt). It is not sent
+	ReceivedAt time.Time `json:"-"`
+}
+
+// Codec encodes values of type T to message bodies and decodes them back
+type Codec[T any] struct {
+	Encode func(T) (string, error)
+	Decode func(string) (T, error)
+}
+
+// JSONCodec returns a Codec which encodes values as JSON
+func JSONCodec[T any]() Codec[T] {
+	return Codec[T]{
+		Encode: func(v T) (string, error) {
+			bs, err := json.Marshal(v)
+			return string(bs), err
+		},
+		Decode: func(s string) (T, error) {
+			var v T
+			err := json.Unmarshal([]byte(s), &v)
+			return v, err
+		},
+	}
+}
+
+// RouteLink is one step of the chain a registry passes each decoded message through before its handler (see Registry.Use). Calling next passes the message on and returns the error from the rest of the chain.
+// Returning without calling next drops the message, and any error returned is passed to the error handler
+type RouteLink func(c *C, env Envelope, next func() error) error
+
+// registration is everything a Registry knows about one type
+type registration struct {
+	tag    string
+	encode func(any) (string, error)
+	handle func(*C, string) error
+}
+
+// Registry maps type tags to Go types, so that many types of message can be sent over one connection and each received message is passed to a handler for its type.
+// Register types with Register or RegisterJSON, send values with Write, and use Handler as the connection's message handler
+type Registry struct {
+	lock    sync.RWMutex
+	byTag   map[string]*registration
+	byType  map[reflect.Type]*registration
+	onError func(*C, error)
+	authz   Authorizer
+	links   map[string][]RouteLink
+	stats   routeStats
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		byTag:  make(map[string]*registration),
+		byType: make(map[reflect.Type]*registration),
+		links:  make(map[string][]RouteLink),
+	}
+}
+
+// Use adds links to the end of the chain for messages with tag, which may be used before the tag is registered. If tag is empty, the links are for every message, and run before the links for any one tag
+func (r *Registry) Use(tag string, links ...RouteLink) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.links[tag] = append(r.links[tag], links...)
+}
+
+// Register adds the type T to the registry under tag. Received messages with that tag are decoded and passed to handler, which may be nil if the type is only ever sent.
+// Registering the same tag or type again replaces the old registration
+func Register[T any](r *Registry, tag string, codec Codec[T], handler func(*C, T)) {
+	reg := &registration{
+		tag: tag,
+		encode: func(v any) (string, error) {
+			return codec.Encode(v.(T))
+		},
+		handle: func(c *C, body string) error {
+			v, err := codec.Decode(body)
+			if err != nil {
+				return err
+			}
+			if handler != nil {
+				handler(c, v)
+			}
+			return nil
+		},
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.byTag[tag] = reg
+	r.byType[reflect.TypeOf((*T)(nil)).Elem()] = reg
+}
+
+// RegisterJSON adds the type T to the registry under tag, encoding it as JSON
+func RegisterJSON[T any](r *Registry, tag string, handler func(*C, T)) {
+	Register(r, tag, JSONCodec[T](), handler)
+}
+
+// SetErrorHandler sets a function which is called when a received message can not be decoded or has an unknown tag. If nil (the default), such messages are dropped
+func (r *Registry) SetErrorHandler(f func(*C, error)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.onError = f
+}
+
+// SetAuthorizer sets an authorizer which is asked, with the message's type tag as the command, whether each received message may be dispatched.
+// Denied messages are passed to the error handler as ErrUnauthorized. If nil (the default), every message is dispatched
+func (r *Registry) SetAuthorizer(authz Authorizer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.authz = authz
+}
+
+// Encode wraps a value in an envelope tagged with the tag its type was registered under
+func (r *Registry) Encode(v any) (Envelope, error) {
+	r.lock.RLock()
+	reg, ok := r.byType[reflect.TypeOf(v)]
+	r.lock.RUnlock()
+	if !ok {
+		return Envelope{}, fmt.Errorf("%w: %T", ErrUnknownType, v)
+	}
+	body, err := reg.encode(v)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{Type: reg.tag, Body: body}, nil
+}
+
+// Write encodes a value of a registered type and writes it as a message. If called while handling a message, the message's trace ID is carried over, and otherwise a new one is generated
+func (r *Registry) Write(c *C, v any) error {
+	return r.WriteTraced(c, v, c.TraceID())
+}
+
+// WriteTraced is like Write but uses the given trace ID, for example one carried over from a message received on another connection. If trace is empty, a new one is generated
+func (r *Registry) WriteTraced(c *C, v any, trace string) error {
+	env, err := r.Encode(v)
+	if err != nil {
+		return err
+	}
+	if trace == "" {
+		trace = c.Conn.newTraceID()
+	}
+	env.Trace = trace
+	prev := c.Conn.trace
+	c.Conn.trace = trace
+	defer func() { c.Conn.trace = prev }()
+	bs, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	_, err = c.WriteMsg(string(bs))
+	return err
+}
+
+// Dispatch decodes a received message and passes it to the handler registered for its tag. If the message has no trace ID, one is generated, and it can be read by the handler with C.TraceID.
+// msg should be the last message read, as its envelope is given that message's receive time
+func (r *Registry) Dispatch(c *C, msg string) error {
+	env, err := c.Conn.decodeEnvelope(msg)
+	if err != nil {
+		return err
+	}
+	env.ReceivedAt = c.ReceivedAt()
+	c.Conn.trace = env.Trace
+	defer func() { c.Conn.trace = "" }()
+	return r.dispatch(c, env)
+}
+
+// dispatch passes a decoded envelope to the handler registered for its tag, recording how it went in the route stats
+func (r *Registry) dispatch(c *C, env Envelope) error {
+	r.lock.RLock()
+	reg, ok := r.byTag[env.Type]
+	authz := r.authz
+	links := append(append([]RouteLink{}, r.links[""]...), r.links[env.Type]...)
+	r.lock.RUnlock()
+	if !ok {
+		// Unknown tags are not recorded, so a remote can not make the stats grow without limit
+		return fmt.Errorf("%w: %q", ErrUnknownType, env.Type)
+	}
+	start := time.Now()
+	var err error
+	if authz != nil && !authz(c.Identity(), env.Type) {
+		err = fmt.Errorf("%w: %q", ErrUnauthorized, env.Type)
+	} else {
+		err = runRoute(c, env, reg, links)
+	}
+	d := time.Since(start)
+	r.stats.record(env.Type, d, err)
+	if m, ok := c.Conn.settings().metrics.(RouteMetrics); ok {
+		m.RouteDone(env.Type, d, err)
+	}
+	return err
+}
+
+// runRoute passes the envelope to the first link, with a next function which runs the rest of the chain and then the registered handler
+func runRoute(c *C, env Envelope, reg *registration, links []RouteLink) error {
+	if len(links) == 0 {
+		return reg.handle(c, env.Body)
+	}
+	return links[0](c, env, func() error {
+		return runRoute(c, env, reg, links[1:])
+	})
+}
+
+// Handler returns a message handler which dispatches every received message using the registry.
+// Each message's trace ID is set before it is read from the buffer, so that it appears in audit records and debug logs for the message.
+// Only the message actually read is dispatched, so messages denied by an enclosing Authorize handler are never handled
+func (r *Registry) Handler() func(*C) {
+	return func(c *C) {
+		for {
+			c.skipDenied()
+			peeked, ok := c.peekMsg()
+			if !ok {
+				return
+			}
+			env, err := c.Conn.decodeEnvelope(peeked)
+			c.Conn.trace = env.Trace
+			msg, ok := c.TryReadMsg()
+			if !ok {
+				c.Conn.trace = ""
+				return
+			}
+			if msg != peeked {
+				env, err = c.Conn.decodeEnvelope(msg)
+				c.Conn.trace = env.Trace
+			}
+			if err == nil {
+				env.ReceivedAt = c.ReceivedAt()
+				err = r.dispatch(c, env)
+			}
+			c.Conn.trace = ""
+			if err != nil {
+				r.lock.RLock()
+				onError := r.onError
+				r.lock.RUnlock()
+				if onError != nil {
+					onError(c, err)
+				}
+			}
+		}
+	}
+}
+
+// decodeEnvelope unmarshals a received message, giving it a new trace ID if the sender did not set one
+func (c *Conn) decodeEnvelope(msg string) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal([]byte(msg), &env); err != nil {
+		return Envelope{}, err
+	}
+	if env.Trace == "" {
+		env.Trace = c.newTraceID()
+	}
+	return env, nil
+}
+
+// TraceID returns the trace ID of the message being handled by a Registry, or an empty string outside of registry handlers
+func (c *C) TraceID() string {
+	return c.Conn.trace
+}
@@ -0,0 +1,270 @@
+package bufconn_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+type greeting struct {
+	Name string
+}
+
+type secret struct {
+	Key string
+}
+
+// envelopeType returns the type tag of an encoded envelope, for authorizing registry messages
+func envelopeType(msg string) string {
+	var env bufconn.Envelope
+	json.Unmarshal([]byte(msg), &env)
+	return env.Type
+}
+
+func TestRegistryUnderAuthorizeSkipsDeniedInBurst(t *testing.T) {
+	var lock sync.Mutex
+	var handled, denied []string
+	r := bufconn.NewRegistry()
+	bufconn.RegisterJSON(r, "greeting", func(c *bufconn.C, g greeting) {
+		lock.Lock()
+		handled = append(handled, g.Name)
+		lock.Unlock()
+	})
+	bufconn.RegisterJSON(r, "secret", func(c *bufconn.C, s secret) {
+		lock.Lock()
+		handled = append(handled, "secret "+s.Key)
+		lock.Unlock()
+	})
+	authz := func(identity, command string) bool { return command != "secret" }
+	onDeny := func(c *bufconn.C, msg string) {
+		lock.Lock()
+		denied = append(denied, envelopeType(msg))
+		lock.Unlock()
+	}
+	a, b := net.Pipe()
+	c := bufconn.NewConn(a, bufconn.Authorize(authz, envelopeType, onDeny, r.Handler()), '\n')
+	defer c.Stop()
+	defer b.Close()
+	burst := []string{
+		`{"type":"greeting","body":"{\"Name\":\"first\"}"}`,
+		`{"type":"secret","body":"{\"Key\":\"hunter2\"}"}`,
+		`{"type":"greeting","body":"{\"Name\":\"second\"}"}`,
+	}
+	// All three arrive in one write, so the handler finds the denied message behind the allowed one it was called for
+	if _, err := b.Write([]byte(strings.Join(burst, "\n") + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		lock.Lock()
+		done := len(handled) == 2 && len(denied) == 1
+		lock.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if strings.Join(handled, ",") != "first,second" {
+		t.Fatalf("expected first and second to be handled, got %q", handled)
+	}
+	if strings.Join(denied, ",") != "secret" {
+		t.Fatalf("expected the secret to be denied, got %q", denied)
+	}
+}
+
+func TestRegistryRoundTrip(t *testing.T) {
+	r := bufconn.NewRegistry()
+	got := make(chan greeting, 1)
+	bufconn.RegisterJSON(r, "greeting", func(c *bufconn.C, g greeting) { got <- g })
+	a, b := net.Pipe()
+	server := bufconn.NewConn(a, r.Handler(), '\n')
+	defer server.Stop()
+	client := bufconn.NewConn(b, nil, '\n')
+	defer client.Stop()
+	errs := make(chan error, 1)
+	client.QueueOperation(func(c *bufconn.C) { errs <- r.Write(c, greeting{"bob"}) })
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case g := <-got:
+		if g.Name != "bob" {
+			t.Fatalf("expected bob, got %q", g.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the greeting to be handled")
+	}
+}
+
+func TestRegistryEncodeUnknownType(t *testing.T) {
+	r := bufconn.NewRegistry()
+	if _, err := r.Encode(secret{}); !errors.Is(err, bufconn.ErrUnknownType) {
+		t.Fatalf("expected ErrUnknownType, got %v", err)
+	}
+	bufconn.RegisterJSON[secret](r, "secret", nil)
+	env, err := r.Encode(secret{"k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Type != "secret" || env.Body != `{"Key":"k"}` {
+		t.Fatalf("unexpected envelope %+v", env)
+	}
+}
+
+func TestRegistryErrorHandler(t *testing.T) {
+	r := bufconn.NewRegistry()
+	bufconn.RegisterJSON(r, "greeting", func(c *bufconn.C, g greeting) {})
+	errs := make(chan error, 3)
+	r.SetErrorHandler(func(c *bufconn.C, err error) { errs <- err })
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, r.Handler(), '\n')
+	defer c.Stop()
+	b.Write([]byte("not json\n" + `{"type":"other","body":"{}"}` + "\n" + `{"type":"greeting","body":"nope"}` + "\n"))
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			if i == 1 && !errors.Is(err, bufconn.ErrUnknownType) {
+				t.Fatalf("expected ErrUnknownType for the unknown tag, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected 3 errors, got %d", i)
+		}
+	}
+}
+
+func TestRegistryRepliesCarryTrace(t *testing.T) {
+	r := bufconn.NewRegistry()
+	bufconn.RegisterJSON(r, "greeting", func(c *bufconn.C, g greeting) {
+		r.Write(c, greeting{"re " + g.Name})
+	})
+	a, b := net.Pipe()
+	defer b.Close()
+	log := &auditLog{}
+	c := bufconn.NewConn(a, r.Handler(), '\n')
+	defer c.Stop()
+	c.SetAuditor(log, envelopeType)
+	got := lines(b)
+	b.Write([]byte(`{"type":"greeting","body":"{\"Name\":\"bob\"}","trace":"abc"}` + "\n"))
+	b.Write([]byte(`{"type":"greeting","body":"{\"Name\":\"eve\"}"}` + "\n"))
+	var envs []bufconn.Envelope
+	for i := 0; i < 2; i++ {
+		var env bufconn.Envelope
+		select {
+		case msg := <-got:
+			json.Unmarshal([]byte(msg), &env)
+		case <-time.After(time.Second):
+			t.Fatal("expected a reply")
+		}
+		envs = append(envs, env)
+	}
+	if envs[0].Trace != "abc" {
+		t.Fatalf("expected the reply to carry the trace, got %q", envs[0].Trace)
+	}
+	if envs[1].Trace == "" || envs[1].Trace == "abc" {
+		t.Fatalf("expected a message without a trace to be given a new one, got %q", envs[1].Trace)
+	}
+	// The reply is audited once its write returns, which may be after the remote has read it
+	waitFor(t, "every message to be audited", func() bool {
+		log.lock.Lock()
+		defer log.lock.Unlock()
+		return len(log.records) == 4
+	})
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	if log.records[0].Trace != "abc" || log.records[1].Trace != "abc" {
+		t.Fatalf("expected the audit records for the first message and its reply to have its trace, got %+v", log.records)
+	}
+}
+
+func TestRegistryWriteOutsideHandlerStartsTrace(t *testing.T) {
+	r := bufconn.NewRegistry()
+	bufconn.RegisterJSON[greeting](r, "greeting", nil)
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	got := lines(b)
+	traces := make(chan string, 1)
+	c.QueueOperation(func(c *bufconn.C) {
+		r.Write(c, greeting{"one"})
+		r.WriteTraced(c, greeting{"two"}, "given")
+		traces <- c.TraceID()
+	})
+	var first, second bufconn.Envelope
+	json.Unmarshal([]byte(<-got), &first)
+	json.Unmarshal([]byte(<-got), &second)
+	if first.Trace == "" || second.Trace != "given" {
+		t.Fatalf("expected a new trace and the given one, got %q and %q", first.Trace, second.Trace)
+	}
+	if trace := <-traces; trace != "" {
+		t.Fatalf("expected no trace outside of a handler, got %q", trace)
+	}
+}
+
+func TestRegistryUseRunsLinks(t *testing.T) {
+	r := bufconn.NewRegistry()
+	got := make(chan string, 20)
+	link := func(name string) bufconn.RouteLink {
+		return func(c *bufconn.C, env bufconn.Envelope, next func() error) error {
+			got <- name + " " + env.Type
+			return next()
+		}
+	}
+	// Links for one tag can be added before it is registered, and run after the links for every message
+	r.Use("greeting", link("greeting"))
+	r.Use("", link("all"))
+	bufconn.RegisterJSON(r, "greeting", func(c *bufconn.C, g greeting) { got <- "hello " + g.Name })
+	bufconn.RegisterJSON(r, "secret", func(c *bufconn.C, s secret) { got <- "secret " + s.Key })
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, r.Handler(), '\n')
+	defer c.Stop()
+	b.Write([]byte(`{"type":"greeting","body":"{\"Name\":\"bob\"}"}` + "\n" + `{"type":"secret","body":"{\"Key\":\"k\"}"}` + "\n"))
+	expectLines(t, got, "all greeting", "greeting greeting", "hello bob", "all secret", "secret k")
+}
+
+func TestRegistryUseLinkDropsAndFails(t *testing.T) {
+	r := bufconn.NewRegistry()
+	handled := make(chan string, 10)
+	bufconn.RegisterJSON(r, "greeting", func(c *bufconn.C, g greeting) { handled <- g.Name })
+	denied := errors.New("denied")
+	r.Use("greeting", func(c *bufconn.C, env bufconn.Envelope, next func() error) error {
+		switch {
+		case strings.Contains(env.Body, "drop"):
+			return nil
+		case strings.Contains(env.Body, "fail"):
+			return denied
+		}
+		return next()
+	})
+	errs := make(chan error, 10)
+	r.SetErrorHandler(func(c *bufconn.C, err error) { errs <- err })
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, r.Handler(), '\n')
+	defer c.Stop()
+	for _, name := range []string{"drop", "fail", "bob"} {
+		b.Write([]byte(`{"type":"greeting","body":"{\"Name\":\"` + name + `\"}"}` + "\n"))
+	}
+	expectLines(t, handled, "bob")
+	select {
+	case err := <-errs:
+		if !errors.Is(err, denied) {
+			t.Fatalf("expected the link's error, got %v", err)
+		}
+	default:
+		t.Fatal("expected the link's error to be passed to the error handler")
+	}
+	if len(errs) != 0 {
+		t.Fatalf("expected one error, got %v", <-errs)
+	}
+}
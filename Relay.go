@@ -0,0 +1,174 @@
+package bufconn
+
+import (
+	"net"
+	"sync"
+)
+
+// RelayHook is called for every message passing through a Relay. It returns the message to forward (which may be modified), and whether it should be forwarded at all
+type RelayHook func(msg string) (string, bool)
+
+// Relay accepts connections on one address and forwards each message to a backend address, and each reply back to the client.
+// Every accepted client gets its own connection to the backend. Messages can be inspected, modified or dropped using hooks
+type Relay struct {
+	listener    net.Listener
+	network     string
+	backendAddr string
+	delim       byte
+	lock        sync.Mutex
+	clientHook  RelayHook
+	backendHook RelayHook
+}
+
+// NewRelay creates a new Relay listening on listenAddr, which will forward messages (seperated by delim) to backendAddr. Call Serve to start accepting connections
+func NewRelay(network, listenAddr, backendAddr string, delim byte) (*Relay, error) {
+	l, err := net.Listen(network, listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &Relay{
+		listener:    l,
+		network:     network,
+		backendAddr: backendAddr,
+		delim:       delim,
+	}, nil
+}
+
+// SetClientHook sets the hook which is called for messages travelling from a client to the backend. If nil, messages are forwarded unchanged
+func (r *Relay) SetClientHook(h RelayHook) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.clientHook = h
+}
+
+// SetBackendHook sets the hook which is called for messages travelling from the backend to a client. If nil, messages are forwarded unchanged
+func (r *Relay) SetBackendHook(h RelayHook) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.backendHook = h
+}
+
+// Serve accepts connections until the relay is closed. It always returns a non-nil error
+func (r *Relay) Serve() error {
+	for {
+		c, err := r.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go r.handle(c)
+	}
+}
+
+// Close stops the relay from accepting new connections. Existing connections are not stopped
+func (r *Relay) Close() error {
+	return r.listener.Close()
+}
+
+// Addr returns the address the relay is listening on
+func (r *Relay) Addr() net.Addr {
+	return r.listener.Addr()
+}
+
+func (r *Relay) handle(c net.Conn) {
+	b, err := net.Dial(r.network, r.backendAddr)
+	if err != nil {
+		c.Close()
+		return
+	}
+	client := newConn(c, nil, r.delim)
+	backend := newConn(b, nil, r.delim)
+	// The handlers must be in place before either side starts, so that no message goes to the default handler
+	client.SetMessageHandler(r.forwardHandler(&relayLink{dst: backend}, func() RelayHook {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return r.clientHook
+	}))
+	backend.SetMessageHandler(r.forwardHandler(&relayLink{dst: client}, func() RelayHook {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return r.backendHook
+	}))
+	client.start()
+	backend.start()
+	// If either side goes away, there is no point keeping the other open
+	select {
+	case <-client.Done():
+		backend.Stop()
+	case <-backend.Done():
+		client.Stop()
+	}
+}
+
+// forwardHandler creates a message handler which passes every complete message through the hook and forwards it over link
+func (r *Relay) forwardHandler(link *relayLink, hook func() RelayHook) func(*C) {
+	return func(c *C) {
+		h := hook()
+		for _, msg := range c.readBurst() {
+			if h != nil {
+				var keep bool
+				msg, keep = h(msg)
+				if !keep {
+					continue
+				}
+			}
+			link.forward(msg)
+		}
+	}
+}
+
+// relayLink queues messages to be written on one side of a relayed connection. It never blocks, so that two sides which are both busy can not each wait forever for the other's queue to have room.
+// Once the queue is full, messages wait in memory and are queued in order by a goroutine as room is made. It is safe for concurrent use
+type relayLink struct {
+	dst      *Conn
+	lock     sync.Mutex
+	backlog  []string
+	draining bool
+}
+
+// forward queues msg to be written to the destination, after any messages already waiting
+func (l *relayLink) forward(msg string) {
+	select {
+	case <-l.dst.done:
+		return
+	default:
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.draining {
+		err := l.dst.queueOp(writeOp(msg), PriorityNormal, false)
+		if err != ErrQueueFull {
+			return
+		}
+		l.draining = true
+		l.dst.goTracked("relay backlog", l.drain)
+	}
+	l.backlog = append(l.backlog, msg)
+}
+
+// drain queues the waiting messages in order, waiting for room for each one, until there are none left or the destination stops
+func (l *relayLink) drain() {
+	for {
+		l.lock.Lock()
+		if len(l.backlog) == 0 {
+			l.draining = false
+			l.lock.Unlock()
+			return
+		}
+		msg := l.backlog[0]
+		l.backlog = l.backlog[1:]
+		l.lock.Unlock()
+		if l.dst.queueOp(writeOp(msg), PriorityNormal, true) != nil {
+			l.lock.Lock()
+			l.backlog = nil
+			l.lock.Unlock()
+			return
+		}
+	}
+}
+
+// writeOp creates an operation which writes msg
+func writeOp(msg string) func(*C) {
+	return func(c *C) {
+		c.WriteMsg(msg)
+	}
+}
@@ -0,0 +1,109 @@
+package bufconn_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// echoServer accepts connections on a local port and answers every line with "echo:" and the line
+func echoServer(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				s := bufio.NewScanner(c)
+				for s.Scan() {
+					fmt.Fprintf(c, "echo:%s\n", s.Text())
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+// startRelay starts a relay on a local port in front of backend
+func startRelay(t *testing.T, backend net.Listener) *bufconn.Relay {
+	t.Helper()
+	r, err := bufconn.NewRelay("tcp", "127.0.0.1:0", backend.Addr().String(), '\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Serve()
+	return r
+}
+
+func TestRelayForwardsBothWays(t *testing.T) {
+	backend := echoServer(t)
+	defer backend.Close()
+	r := startRelay(t, backend)
+	defer r.Close()
+	c, err := net.Dial("tcp", r.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	got := lines(c)
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(c, "msg%d\n", i)
+	}
+	for i := 0; i < 50; i++ {
+		expectLines(t, got, fmt.Sprintf("echo:msg%d", i))
+	}
+}
+
+func TestRelayHooksModifyAndDrop(t *testing.T) {
+	backend := echoServer(t)
+	defer backend.Close()
+	r := startRelay(t, backend)
+	defer r.Close()
+	r.SetClientHook(func(msg string) (string, bool) {
+		return strings.ToUpper(msg), msg != "drop"
+	})
+	r.SetBackendHook(func(msg string) (string, bool) {
+		return msg + "!", true
+	})
+	c, err := net.Dial("tcp", r.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	got := lines(c)
+	fmt.Fprint(c, "a\ndrop\nb\n")
+	expectLines(t, got, "echo:A!", "echo:B!")
+}
+
+func TestRelayStopsClientWhenBackendCloses(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	r := startRelay(t, l)
+	defer r.Close()
+	c, err := net.Dial("tcp", r.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn := bufconn.NewConn(c, nil, '\n')
+	waitFor(t, "client to be stopped", conn.IsStopped)
+}
@@ -0,0 +1,124 @@
+package bufconn
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GapError is passed to a reorder buffer's error handler when messages are given up on, so that later messages can be delivered
+type GapError struct {
+	// From and To are the first and last sequence numbers which were skipped
+	From, To uint64
+}
+
+func (e *GapError) Error() string {
+	if e.From == e.To {
+		return fmt.Sprintf("message %d missing", e.From)
+	}
+	return fmt.Sprintf("messages %d to %d missing", e.From, e.To)
+}
+
+// Sequenced prefixes a message with its sequence number, in the format read by Reorder
+func Sequenced(seq uint64, msg string) string {
+	return strconv.FormatUint(seq, 10) + " " + msg
+}
+
+// reorderBuffer holds messages which arrived ahead of their turn
+type reorderBuffer struct {
+	next    uint64
+	held    map[uint64]string
+	waiting time.Time
+	window  int
+	timeout time.Duration
+	onError func(*C, error)
+	handler func(c *C, seq uint64, msg string)
+}
+
+// Reorder sets the connection's message handler to one which expects every message to start with a sequence number (see Sequenced), counting up from zero, and passes them to handler in sequence order.
+// This is for transports which can deliver messages out of order. Messages which arrive early are held until the ones before them arrive.
+// If more than window messages are being held, or the oldest has been held for longer than timeout, the missing messages are given up on and reported to onError as a *GapError. Messages which arrive after they were given up on, or twice, are dropped.
+// Messages without a sequence number are also reported to onError. onError may be nil
+func Reorder(conn *Conn, window int, timeout time.Duration, onError func(*C, error), handler func(c *C, seq uint64, msg string)) {
+	r := &reorderBuffer{
+		held:    make(map[uint64]string),
+		window:  window,
+		timeout: timeout,
+		onError: onError,
+		handler: handler,
+	}
+	conn.SetMessageHandler(func(c *C) {
+		for _, msg := range c.readBurst() {
+			r.receive(c, msg)
+		}
+	})
+	if timeout > 0 {
+		conn.every(checkInterval(timeout), func(c *C) {
+			if len(r.held) > 0 && time.Since(r.waiting) >= timeout {
+				r.skip(c)
+			}
+		})
+	}
+}
+
+// receive delivers or holds one message
+func (r *reorderBuffer) receive(c *C, msg string) {
+	seqStr, body, ok := strings.Cut(msg, " ")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if !ok || err != nil {
+		r.fail(c, fmt.Errorf("message has no sequence number: %q", msg))
+		return
+	}
+	if _, dup := r.held[seq]; seq < r.next || dup {
+		return
+	}
+	if len(r.held) == 0 {
+		r.waiting = time.Now()
+	}
+	r.held[seq] = body
+	r.flush(c)
+	for len(r.held) > r.window {
+		r.skip(c)
+	}
+}
+
+// flush delivers held messages for as long as the next one is there
+func (r *reorderBuffer) flush(c *C) {
+	for {
+		body, ok := r.held[r.next]
+		if !ok {
+			return
+		}
+		delete(r.held, r.next)
+		seq := r.next
+		r.next++
+		// The oldest held message has changed, so the timeout starts again
+		r.waiting = time.Now()
+		r.handler(c, seq, body)
+	}
+}
+
+// skip gives up on the messages missing before the earliest held one, then delivers what it can
+func (r *reorderBuffer) skip(c *C) {
+	first := uint64(0)
+	found := false
+	for seq := range r.held {
+		if !found || seq < first {
+			first, found = seq, true
+		}
+	}
+	if !found {
+		return
+	}
+	r.fail(c, &GapError{r.next, first - 1})
+	r.next = first
+	r.flush(c)
+}
+
+// fail passes an error to the error handler, if there is one
+func (r *reorderBuffer) fail(c *C, err error) {
+	if r.onError != nil {
+		r.onError(c, err)
+	}
+}
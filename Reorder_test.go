@@ -0,0 +1,83 @@
+package bufconn_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// reorderConn creates a connection using Reorder, sending each delivered message as "seq:msg" and each error on the returned channels
+func reorderConn(t *testing.T, window int, timeout time.Duration) (net.Conn, <-chan string, <-chan error) {
+	a, b := net.Pipe()
+	c := bufconn.NewConn(a, nil, '\n')
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	got, errs := make(chan string, 20), make(chan error, 20)
+	bufconn.Reorder(c, window, timeout, func(c *bufconn.C, err error) { errs <- err }, func(c *bufconn.C, seq uint64, msg string) {
+		got <- fmt.Sprintf("%d:%s", seq, msg)
+	})
+	return b, got, errs
+}
+
+// sendSequenced writes each message with the sequence number before it
+func sendSequenced(remote net.Conn, seqs ...uint64) {
+	var sb strings.Builder
+	for _, seq := range seqs {
+		sb.WriteString(bufconn.Sequenced(seq, fmt.Sprint("m", seq)) + "\n")
+	}
+	remote.Write([]byte(sb.String()))
+}
+
+func TestReorderDeliversInOrder(t *testing.T) {
+	remote, got, _ := reorderConn(t, 10, 0)
+	sendSequenced(remote, 2, 0, 3, 1, 1)
+	expectLines(t, got, "0:m0", "1:m1", "2:m2", "3:m3")
+	select {
+	case msg := <-got:
+		t.Fatalf("expected the duplicate to be dropped, got %q", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestReorderWindowSkipsGap(t *testing.T) {
+	remote, got, errs := reorderConn(t, 2, 0)
+	sendSequenced(remote, 0, 3, 4, 5)
+	expectLines(t, got, "0:m0", "3:m3", "4:m4", "5:m5")
+	var gap *bufconn.GapError
+	if err := <-errs; !errors.As(err, &gap) || gap.From != 1 || gap.To != 2 {
+		t.Fatalf("expected messages 1 to 2 to be missing, got %v", err)
+	}
+	// Messages arriving after they were given up on are dropped
+	sendSequenced(remote, 1, 6)
+	expectLines(t, got, "6:m6")
+}
+
+func TestReorderTimeoutSkipsGap(t *testing.T) {
+	remote, got, errs := reorderConn(t, 10, 30*time.Millisecond)
+	sendSequenced(remote, 1)
+	expectLines(t, got, "1:m1")
+	var gap *bufconn.GapError
+	if err := <-errs; !errors.As(err, &gap) || gap.Error() != "message 0 missing" {
+		t.Fatalf("expected message 0 to be missing, got %v", err)
+	}
+}
+
+func TestReorderRejectsUnsequenced(t *testing.T) {
+	remote, _, errs := reorderConn(t, 10, 0)
+	remote.Write([]byte("hello\n"))
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the message without a sequence number to be reported")
+	}
+}
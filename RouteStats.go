@@ -0,0 +1,72 @@
+package bufconn
+
+import (
+	"sync"
+	"time"
+)
+
+// RouteMetrics can be implemented by a Metrics to also be told about every message a Registry dispatches, as well as what the connection reports
+type RouteMetrics interface {
+	// RouteDone is called with the message's type tag, how long its links and handler took, and the error they returned, if any
+	RouteDone(tag string, d time.Duration, err error)
+}
+
+// RouteStats is the usage of one type tag in a Registry, across every connection using it
+type RouteStats struct {
+	// Count is the number of messages dispatched with the tag, and Errors how many of them returned an error or were denied
+	Count  uint64
+	Errors uint64
+	// Durations are how long the links and handler took, in seconds
+	Durations HistogramSnapshot
+}
+
+// routeCounter counts the use of one tag
+type routeCounter struct {
+	count     uint64
+	errors    uint64
+	durations *Histogram
+}
+
+// routeStats holds the counters of every registered tag which has been dispatched. It is safe for concurrent use
+type routeStats struct {
+	lock  sync.Mutex
+	byTag map[string]*routeCounter
+}
+
+// record counts one dispatch of the tag
+func (s *routeStats) record(tag string, d time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.byTag == nil {
+		s.byTag = make(map[string]*routeCounter)
+	}
+	rc, ok := s.byTag[tag]
+	if !ok {
+		// The same buckets as NewHistogramMetrics uses for durations
+		rc = &routeCounter{durations: NewHistogram(1e-6, 2, 31)}
+		s.byTag[tag] = rc
+	}
+	rc.count++
+	if err != nil {
+		rc.errors++
+	}
+	rc.durations.Observe(d.Seconds())
+}
+
+// RouteStats returns the usage of every registered tag which has had messages dispatched, so the commands which make up most of the load can be found
+func (r *Registry) RouteStats() map[string]RouteStats {
+	r.stats.lock.Lock()
+	defer r.stats.lock.Unlock()
+	out := make(map[string]RouteStats, len(r.stats.byTag))
+	for tag, rc := range r.stats.byTag {
+		out[tag] = RouteStats{Count: rc.count, Errors: rc.errors, Durations: rc.durations.Snapshot()}
+	}
+	return out
+}
+
+// ResetRouteStats forgets the usage of every tag
+func (r *Registry) ResetRouteStats() {
+	r.stats.lock.Lock()
+	defer r.stats.lock.Unlock()
+	r.stats.byTag = nil
+}
@@ -0,0 +1,83 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// routeMetrics records each route reported to it as "tag" or "tag error"
+type routeMetrics struct {
+	countingMetrics
+	lock   sync.Mutex
+	routes []string
+}
+
+func (m *routeMetrics) RouteDone(tag string, d time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err != nil {
+		tag += " error"
+	}
+	m.routes = append(m.routes, tag)
+}
+
+// routedConn creates a connection dispatching with a registry which handles greetings and fails every secret, and sends it the messages
+func routedConn(t *testing.T, r *bufconn.Registry, m bufconn.Metrics, msgs ...string) {
+	bufconn.RegisterJSON(r, "greeting", func(c *bufconn.C, g greeting) {})
+	bufconn.RegisterJSON(r, "secret", func(c *bufconn.C, s secret) {})
+	r.Use("secret", func(c *bufconn.C, env bufconn.Envelope, next func() error) error {
+		return errors.New("no secrets")
+	})
+	handled := make(chan struct{}, len(msgs))
+	r.SetErrorHandler(func(c *bufconn.C, err error) {})
+	h := r.Handler()
+	a, b := net.Pipe()
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		h(c)
+		handled <- struct{}{}
+	}, '\n')
+	c.SetMetrics(m)
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	for _, msg := range msgs {
+		b.Write([]byte(`{"type":"` + msg + `","body":"{}"}` + "\n"))
+		<-handled
+	}
+}
+
+func TestRouteStats(t *testing.T) {
+	r := bufconn.NewRegistry()
+	routedConn(t, r, nil, "greeting", "greeting", "secret", "unknown")
+	stats := r.RouteStats()
+	if len(stats) != 2 {
+		t.Fatalf("expected stats for the two registered tags, got %v", stats)
+	}
+	if s := stats["greeting"]; s.Count != 2 || s.Errors != 0 || s.Durations.Count != 2 {
+		t.Fatalf("unexpected greeting stats %+v", s)
+	}
+	if s := stats["secret"]; s.Count != 1 || s.Errors != 1 || s.Durations.Count != 1 {
+		t.Fatalf("unexpected secret stats %+v", s)
+	}
+	r.ResetRouteStats()
+	if stats := r.RouteStats(); len(stats) != 0 {
+		t.Fatalf("expected no stats after a reset, got %v", stats)
+	}
+}
+
+func TestRouteMetrics(t *testing.T) {
+	m := &routeMetrics{}
+	routedConn(t, bufconn.NewRegistry(), m, "secret", "greeting", "unknown")
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if got := strings.Join(m.routes, ","); got != "secret error,greeting" {
+		t.Fatalf("expected a greeting and a failed secret, got %s", got)
+	}
+}
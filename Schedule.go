@@ -0,0 +1,73 @@
+package bufconn
+
+// SchedulePolicy decides whether the processing loop handles incoming messages or queued operations first, when both are waiting
+type SchedulePolicy int
+
+const (
+	// ScheduleRandom picks at random between messages and operations. This is the default
+	ScheduleRandom SchedulePolicy = iota
+	// ScheduleReadsFirst always handles waiting messages before running operations
+	ScheduleReadsFirst
+	// ScheduleOpsFirst always runs waiting operations before handling messages
+	ScheduleOpsFirst
+	// ScheduleWeighted takes turns between messages and operations in the ratio set by SetScheduleWeights
+	ScheduleWeighted
+)
+
+// SetSchedule sets how the processing loop chooses between incoming messages and queued operations. It will come into effect after the current operation or handler
+func (c *Conn) SetSchedule(p SchedulePolicy) {
+	c.updateSettings(func(s *settings) {
+		s.schedule = p
+	})
+}
+
+// SetScheduleWeights switches to ScheduleWeighted, handling up to reads messages for every ops operations while both are waiting.
+// Weights below one are treated as one
+func (c *Conn) SetScheduleWeights(reads, ops int) {
+	if reads < 1 {
+		reads = 1
+	}
+	if ops < 1 {
+		ops = 1
+	}
+	c.updateSettings(func(s *settings) {
+		s.readWeight, s.opWeight = reads, ops
+		s.schedule = ScheduleWeighted
+	})
+}
+
+// runPreferred handles a waiting byte or operation if the schedule prefers one, returning false if there was nothing preferred waiting.
+// Only bytes which complete a message and operations count towards the weights, as these are what the schedule is choosing between
+func (c *Conn) runPreferred() bool {
+	preferReads := false
+	s := c.settings()
+	switch s.schedule {
+	case ScheduleReadsFirst:
+		preferReads = true
+	case ScheduleOpsFirst:
+	case ScheduleWeighted:
+		preferReads = c.scheduled%(s.readWeight+s.opWeight) < s.readWeight
+	default:
+		return false
+	}
+	if preferReads {
+		if c.pending {
+			c.handlePending()
+			return true
+		}
+		select {
+		case b := <-c.readChan:
+			c.handleByte(b)
+			return true
+		default:
+		}
+	} else {
+		select {
+		case <-c.opSignal:
+			c.runOp()
+			return true
+		default:
+		}
+	}
+	return false
+}
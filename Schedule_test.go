@@ -0,0 +1,58 @@
+package bufconn_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// scheduleOrder handles three messages and three operations which were all waiting at once, and returns the order they ran in
+func scheduleOrder(t *testing.T, setup func(c *bufconn.Conn)) string {
+	t.Helper()
+	a, b := net.Pipe()
+	defer b.Close()
+	r := &orderRecorder{}
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		if msg, ok := c.TryReadMsg(); ok {
+			r.op(msg)(c)
+		}
+	}, '\n')
+	defer c.Stop()
+	setup(c)
+	release, started := make(chan struct{}), make(chan struct{})
+	c.QueueOperation(func(*bufconn.C) {
+		close(started)
+		<-release
+	})
+	<-started
+	go b.Write([]byte("a\nb\nc\n"))
+	waitFor(t, "messages to arrive", func() bool { return c.Buffered() == 6 })
+	for i := 0; i < 3; i++ {
+		c.QueueOperation(r.op("op"))
+	}
+	close(release)
+	return fmt.Sprint(r.wait(t, 6))
+}
+
+func TestScheduleReadsFirst(t *testing.T) {
+	got := scheduleOrder(t, func(c *bufconn.Conn) { c.SetSchedule(bufconn.ScheduleReadsFirst) })
+	if got != "[a b c op op op]" {
+		t.Fatalf("expected messages first, got %s", got)
+	}
+}
+
+func TestScheduleOpsFirst(t *testing.T) {
+	got := scheduleOrder(t, func(c *bufconn.Conn) { c.SetSchedule(bufconn.ScheduleOpsFirst) })
+	if got != "[op op op a b c]" {
+		t.Fatalf("expected operations first, got %s", got)
+	}
+}
+
+func TestScheduleWeightsTakeTurns(t *testing.T) {
+	got := scheduleOrder(t, func(c *bufconn.Conn) { c.SetScheduleWeights(1, 1) })
+	if got != "[op a op b op c]" && got != "[a op b op c op]" {
+		t.Fatalf("expected messages and operations to take turns, got %s", got)
+	}
+}
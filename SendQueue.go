@@ -0,0 +1,199 @@
+package bufconn
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrMessageDropped is returned by SendMsg when the message was dropped because the send queue was full
+var ErrMessageDropped = errors.New("message dropped from full send queue")
+
+// QueuePolicy is what SendMsg does with a message when the send queue for its priority is full
+type QueuePolicy int
+
+const (
+	// QueueBlock waits for there to be room in the queue. This is the default
+	QueueBlock QueuePolicy = iota
+	// QueueDropOldest drops the message which has been waiting longest to make room
+	QueueDropOldest
+	// QueueDropNewest drops the message being sent, and SendMsg returns ErrMessageDropped
+	QueueDropNewest
+)
+
+// SendQueueStats describes the messages waiting to be written by a connection with a send queue
+type SendQueueStats struct {
+	// Queued is the number of messages waiting now, and HighWater the most there have ever been
+	Queued    int
+	HighWater int
+	// Dropped is the number of messages dropped because the queue was full
+	Dropped uint64
+	// OldestWait is how long the message which has been waiting longest has been queued, or zero if there are none
+	OldestWait time.Duration
+}
+
+// queuedMsg is a message waiting in a send queue
+type queuedMsg struct {
+	msg    string
+	seq    uint64
+	queued time.Time
+}
+
+// sendQueue holds messages from SendMsg until the processing loop writes them, so that a full queue can drop messages rather than always blocking. It is safe for concurrent use
+type sendQueue struct {
+	lock      sync.Mutex
+	space     *sync.Cond
+	limit     int
+	policy    QueuePolicy
+	msgs      [numPriorities][]queuedMsg
+	flushing  [numPriorities]bool
+	dropped   uint64
+	highWater int
+}
+
+// SetSendQueue limits the number of messages from SendMsg which can wait to be written at each priority to limit, with policy deciding what happens to messages sent once it is full.
+// Without a send queue, every message is queued as its own operation, and SendMsg blocks once the operation queue is full. Messages already queued are not affected.
+// With QueueBlock, a handler or operation calling SendMsg on its own connection will wait forever if the queue is full, as the messages can only be written once it returns.
+// If limit is zero or less, the send queue is removed if it is empty
+func (c *Conn) SetSendQueue(limit int, policy QueuePolicy) {
+	c.updateSettings(func(s *settings) {
+		if limit <= 0 {
+			if q := s.sendQueue; q != nil && q.len() == 0 {
+				s.sendQueue = nil
+			}
+			return
+		}
+		if q := s.sendQueue; q != nil {
+			q.lock.Lock()
+			q.limit, q.policy = limit, policy
+			q.space.Broadcast()
+			q.lock.Unlock()
+			return
+		}
+		q := &sendQueue{limit: limit, policy: policy}
+		q.space = sync.NewCond(&q.lock)
+		c.goTracked("send queue", func() {
+			// Senders waiting for room need waking when the connection stops, as there will never be any
+			<-c.done
+			q.lock.Lock()
+			q.space.Broadcast()
+			q.lock.Unlock()
+		})
+		s.sendQueue = q
+	})
+}
+
+// SendQueueStats returns the state of the connection's send queue, or zeros if it does not have one
+func (c *Conn) SendQueueStats() SendQueueStats {
+	q := c.settings().sendQueue
+	if q == nil {
+		return SendQueueStats{}
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	s := SendQueueStats{HighWater: q.highWater, Dropped: q.dropped}
+	for _, msgs := range q.msgs {
+		s.Queued += len(msgs)
+		if len(msgs) > 0 && time.Since(msgs[0].queued) > s.OldestWait {
+			s.OldestWait = time.Since(msgs[0].queued)
+		}
+	}
+	return s
+}
+
+// len returns the number of messages waiting at every priority. The lock must not be held
+func (q *sendQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.lenLocked()
+}
+
+// push adds a message to the queue for its priority, applying the policy if it is full, and makes sure an operation is queued to write it.
+// If wait is false, a full queue with QueueBlock returns ErrQueueFull rather than waiting
+func (q *sendQueue) push(c *Conn, msg string, p Priority, wait bool) error {
+	j := c.settings().journal
+	q.lock.Lock()
+	for len(q.msgs[p]) >= q.limit {
+		switch q.policy {
+		case QueueDropNewest:
+			q.dropped++
+			q.lock.Unlock()
+			return ErrMessageDropped
+		case QueueDropOldest:
+			if j != nil {
+				j.done(q.msgs[p][0].seq)
+			}
+			q.msgs[p] = q.msgs[p][1:]
+			q.dropped++
+		default:
+			if c.IsStopped() {
+				q.lock.Unlock()
+				return ErrStopped
+			}
+			if !wait {
+				q.lock.Unlock()
+				return ErrQueueFull
+			}
+			q.space.Wait()
+		}
+	}
+	var seq uint64
+	if j != nil {
+		seq = j.record(c.id, msg)
+	}
+	q.msgs[p] = append(q.msgs[p], queuedMsg{msg, seq, time.Now()})
+	if n := q.lenLocked(); n > q.highWater {
+		q.highWater = n
+	}
+	flush := !q.flushing[p]
+	q.flushing[p] = true
+	q.lock.Unlock()
+	if flush && wait {
+		c.QueueOperationPriority(q.flusher(p), p)
+	} else if flush && c.queueOp(q.flusher(p), p, false) == ErrQueueFull {
+		// The message is already queued, so rather than fail, the flusher is queued once there is room
+		c.goTracked("send queue flush", func() {
+			c.QueueOperationPriority(q.flusher(p), p)
+		})
+	}
+	return nil
+}
+
+// lenLocked is the same as len, but the lock must be held
+func (q *sendQueue) lenLocked() int {
+	n := 0
+	for _, msgs := range q.msgs {
+		n += len(msgs)
+	}
+	return n
+}
+
+// flusher creates an operation which writes the messages waiting at the priority. It writes at most one queue's worth at a time, then queues itself again so other work is not held up
+func (q *sendQueue) flusher(p Priority) func(*C) {
+	return func(c *C) {
+		j := c.settings().journal
+		for written := 0; ; written++ {
+			q.lock.Lock()
+			if len(q.msgs[p]) == 0 {
+				q.flushing[p] = false
+				q.lock.Unlock()
+				return
+			}
+			if written >= q.limit {
+				q.lock.Unlock()
+				// Queuing from the processing loop could block it if the operation queue is full
+				c.goTracked("send queue requeue", func() {
+					c.QueueOperationPriority(q.flusher(p), p)
+				})
+				return
+			}
+			m := q.msgs[p][0]
+			q.msgs[p] = q.msgs[p][1:]
+			q.space.Signal()
+			q.lock.Unlock()
+			if _, err := c.writeMsg(m.msg, priorityFlags(p)); err == nil && j != nil {
+				j.done(m.seq)
+			}
+		}
+	}
+}
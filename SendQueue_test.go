@@ -0,0 +1,93 @@
+package bufconn_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestSendQueueDropOldest(t *testing.T) {
+	c, remote, release := blockedConn(t)
+	c.SetSendQueue(3, bufconn.QueueDropOldest)
+	for i := 0; i < 5; i++ {
+		if err := c.SendMsg(fmt.Sprint("m", i), bufconn.PriorityNormal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	s := c.SendQueueStats()
+	if s.Queued != 3 || s.HighWater != 3 || s.Dropped != 2 || s.OldestWait < 10*time.Millisecond {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	got := lines(remote)
+	release()
+	expectLines(t, got, "m2", "m3", "m4")
+	waitFor(t, "the queue to empty", func() bool { return c.SendQueueStats().Queued == 0 })
+	if s := c.SendQueueStats(); s.HighWater != 3 || s.OldestWait != 0 {
+		t.Fatalf("unexpected stats once empty %+v", s)
+	}
+}
+
+func TestSendQueueDropNewest(t *testing.T) {
+	c, remote, release := blockedConn(t)
+	c.SetSendQueue(2, bufconn.QueueDropNewest)
+	for i := 0; i < 3; i++ {
+		err := c.SendMsg(fmt.Sprint("m", i), bufconn.PriorityNormal)
+		if i < 2 && err != nil {
+			t.Fatal(err)
+		} else if i == 2 && !errors.Is(err, bufconn.ErrMessageDropped) {
+			t.Fatalf("expected ErrMessageDropped, got %v", err)
+		}
+	}
+	// Each priority has its own queue
+	if err := c.SendMsg("high", bufconn.PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	got := lines(remote)
+	release()
+	expectLines(t, got, "high", "m0", "m1")
+	if s := c.SendQueueStats(); s.Dropped != 1 {
+		t.Fatalf("expected one message dropped, got %+v", s)
+	}
+}
+
+func TestSendQueueBlock(t *testing.T) {
+	c, remote, release := blockedConn(t)
+	c.SetSendQueue(1, bufconn.QueueBlock)
+	c.SendMsg("first", bufconn.PriorityNormal)
+	sent := make(chan error, 1)
+	go func() { sent <- c.SendMsg("second", bufconn.PriorityNormal) }()
+	select {
+	case err := <-sent:
+		t.Fatalf("expected SendMsg to wait for room, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	got := lines(remote)
+	release()
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, got, "first", "second")
+}
+
+func TestSendQueueBlockedSenderStopped(t *testing.T) {
+	c, _, release := blockedConn(t)
+	defer release()
+	c.SetSendQueue(1, bufconn.QueueBlock)
+	c.SendMsg("first", bufconn.PriorityNormal)
+	sent := make(chan error, 1)
+	go func() { sent <- c.SendMsg("second", bufconn.PriorityNormal) }()
+	time.Sleep(10 * time.Millisecond)
+	c.Stop()
+	select {
+	case err := <-sent:
+		if !errors.Is(err, bufconn.ErrStopped) {
+			t.Fatalf("expected ErrStopped, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the waiting sender to give up")
+	}
+}
@@ -0,0 +1,280 @@
+package bufconn
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrUnknownSession is returned when resuming a session whose token was never issued or has expired
+var ErrUnknownSession = errors.New("unknown or expired session")
+
+// Session is the state of one remote peer which outlives any single connection. When the connection is lost, the session waits for the remote to reconnect and resume it with its token
+type Session struct {
+	token    string
+	store    *SessionStore
+	lock     sync.Mutex
+	sendLock sync.Mutex
+	conn     *Conn
+	// live is the connection messages are written to as they are sent. It is only set once bind has resent the messages which were waiting, so they stay in order
+	live    *Conn
+	meta    map[string]any
+	groups  map[string]bool
+	unacked []sessionMsg
+	lastSeq uint64
+	expiry  *time.Timer
+}
+
+// sessionMsg is a message sent on a session which has not been written yet
+type sessionMsg struct {
+	seq uint64
+	msg string
+	// on is the connection the message was queued on, or nil if it has not been queued
+	on *Conn
+}
+
+// Token returns the opaque token the remote must present to resume the session. Send it to the remote once the session is started
+func (s *Session) Token() string {
+	return s.token
+}
+
+// Conn returns the connection the session is bound to, or nil if it is waiting for the remote to reconnect
+func (s *Session) Conn() *Conn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.conn
+}
+
+// SetMetadata stores a value against the session under key. It is copied onto every connection the session is bound to
+func (s *Session) SetMetadata(key string, value any) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.meta[key] = value
+	if s.conn != nil {
+		s.conn.SetMetadata(key, value)
+	}
+}
+
+// Metadata returns the value stored against the session under key, and whether there was one
+func (s *Session) Metadata(key string) (any, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	v, ok := s.meta[key]
+	return v, ok
+}
+
+// Join subscribes the session to the Manager group with the tag. The session's connection is rejoined to the group every time the session is resumed
+func (s *Session) Join(tag string) {
+	s.lock.Lock()
+	s.groups[tag] = true
+	conn := s.conn
+	s.lock.Unlock()
+	// The manager calls presence listeners, which may use the session
+	if conn != nil && s.store.manager != nil {
+		s.store.manager.Join(conn, tag)
+	}
+}
+
+// Leave unsubscribes the session from the Manager group with the tag
+func (s *Session) Leave(tag string) {
+	s.lock.Lock()
+	delete(s.groups, tag)
+	conn := s.conn
+	s.lock.Unlock()
+	if conn != nil && s.store.manager != nil {
+		s.store.manager.Leave(conn, tag)
+	}
+}
+
+// Send queues the message to be written to the remote. The message is kept until it has been written, so if the connection is lost first, or the session is waiting for the remote to reconnect, it is written once the session is resumed.
+// A message being written just as the connection is replaced may be written to both connections
+func (s *Session) Send(msg string) {
+	// sendLock keeps messages in order without holding up the session's other methods while queuing waits for room
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	s.lock.Lock()
+	s.lastSeq++
+	m := sessionMsg{seq: s.lastSeq, msg: msg, on: s.live}
+	s.unacked = append(s.unacked, m)
+	s.lock.Unlock()
+	if m.on != nil {
+		s.write(m)
+	}
+}
+
+// write queues an operation on the connection the message is for, which forgets the message once it has been written.
+// If the connection stops first, the operation never runs, and the message is written by the next connection the session is bound to
+func (s *Session) write(m sessionMsg) {
+	m.on.QueueOperation(func(c *C) {
+		if _, err := c.WriteMsg(m.msg); err == nil {
+			s.ack(m.seq)
+		}
+	})
+}
+
+// ack forgets a message which has been written
+func (s *Session) ack(seq uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, m := range s.unacked {
+		if m.seq == seq {
+			s.unacked = append(s.unacked[:i], s.unacked[i+1:]...)
+			return
+		}
+	}
+}
+
+// SessionStore issues sessions and rebinds them to new connections when remotes reconnect.
+// If it has a Manager, the connections are added to it and session groups are joined on it
+type SessionStore struct {
+	lock     sync.Mutex
+	manager  *Manager
+	grace    time.Duration
+	sessions map[string]*Session
+}
+
+// NewSessionStore creates a session store. A session is forgotten once it has been without a connection for longer than grace. manager may be nil
+func NewSessionStore(manager *Manager, grace time.Duration) *SessionStore {
+	return &SessionStore{
+		manager:  manager,
+		grace:    grace,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Start creates a new session with a fresh token and binds it to the connection
+func (st *SessionStore) Start(c *Conn) *Session {
+	s := &Session{
+		token:  newSessionToken(),
+		store:  st,
+		conn:   c,
+		meta:   make(map[string]any),
+		groups: make(map[string]bool),
+	}
+	st.lock.Lock()
+	st.sessions[s.token] = s
+	st.lock.Unlock()
+	s.bind(c)
+	return s
+}
+
+// Resume binds the session with the token to the connection, restoring its metadata and groups and writing any messages sent while it had no connection.
+// If the session is still bound to an older connection (because the remote reconnected before the loss was noticed), the older connection is stopped.
+// If there is no such session, ErrUnknownSession is returned
+func (st *SessionStore) Resume(c *Conn, token string) (*Session, error) {
+	// The session is attached to the connection while the store is locked, so that it can not expire once it has been found
+	st.lock.Lock()
+	s, ok := st.sessions[token]
+	if !ok {
+		st.lock.Unlock()
+		return nil, ErrUnknownSession
+	}
+	s.lock.Lock()
+	old := s.conn
+	s.conn, s.live = c, nil
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	s.lock.Unlock()
+	st.lock.Unlock()
+	if old != nil && old != c {
+		old.Stop()
+	}
+	s.bind(c)
+	return s, nil
+}
+
+// Get returns the session with the token, if it exists
+func (st *SessionStore) Get(token string) (*Session, bool) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	s, ok := st.sessions[token]
+	return s, ok
+}
+
+// Len returns the number of sessions, including those waiting for their remote to reconnect
+func (st *SessionStore) Len() int {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	return len(st.sessions)
+}
+
+// bind sets the session up on the connection it has been attached to, writes the messages which are waiting, and detaches it again when the connection stops.
+// Nothing is locked while the manager is called or messages are queued, so a slow connection does not hold up the session or the store
+func (s *Session) bind(c *Conn) {
+	m := s.store.manager
+	if m != nil {
+		m.Add(c)
+	}
+	c.goTracked("session detach", func() {
+		<-c.Done()
+		s.detach(c)
+	})
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	s.lock.Lock()
+	if s.conn != c {
+		// The session was resumed on another connection in the meantime
+		s.lock.Unlock()
+		return
+	}
+	meta := make(map[string]any, len(s.meta))
+	for k, v := range s.meta {
+		meta[k] = v
+	}
+	groups := make([]string, 0, len(s.groups))
+	for tag := range s.groups {
+		groups = append(groups, tag)
+	}
+	var resend []sessionMsg
+	for i := range s.unacked {
+		if s.unacked[i].on != c {
+			s.unacked[i].on = c
+			resend = append(resend, s.unacked[i])
+		}
+	}
+	s.live = c
+	s.lock.Unlock()
+	for k, v := range meta {
+		c.SetMetadata(k, v)
+	}
+	if m != nil {
+		for _, tag := range groups {
+			m.Join(c, tag)
+		}
+	}
+	for _, msg := range resend {
+		s.write(msg)
+	}
+}
+
+// detach unbinds the session from the connection, if it is still bound to it, and forgets the session if it is not resumed within the grace window
+func (s *Session) detach(c *Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn != c {
+		return
+	}
+	s.conn, s.live = nil, nil
+	s.expiry = time.AfterFunc(s.store.grace, func() {
+		s.store.lock.Lock()
+		defer s.store.lock.Unlock()
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.conn == nil {
+			delete(s.store.sessions, s.token)
+		}
+	})
+}
+
+// newSessionToken creates a random token which can not be guessed
+func newSessionToken() string {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bs)
+}
@@ -0,0 +1,126 @@
+package bufconn_test
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// lines reads newline delimited messages written to the remote end of a pipe
+func lines(remote net.Conn) <-chan string {
+	out := make(chan string, 10)
+	go func() {
+		s := bufio.NewScanner(remote)
+		for s.Scan() {
+			out <- s.Text()
+		}
+	}()
+	return out
+}
+
+// expectLines fails the test unless the next messages are want, in order
+func expectLines(t *testing.T, got <-chan string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case msg := <-got:
+			if msg != w {
+				t.Fatalf("expected %q, got %q", w, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %q, got nothing", w)
+		}
+	}
+}
+
+func TestSessionResumeWritesMessagesSentWhileAway(t *testing.T) {
+	st := bufconn.NewSessionStore(nil, time.Minute)
+	a1, b1 := net.Pipe()
+	c1 := bufconn.NewConn(a1, nil, '\n')
+	s := st.Start(c1)
+	got1 := lines(b1)
+	s.Send("one")
+	expectLines(t, got1, "one")
+	b1.Close()
+	<-c1.Done()
+	s.Send("two")
+	s.Send("three")
+	a2, b2 := net.Pipe()
+	defer b2.Close()
+	c2 := bufconn.NewConn(a2, nil, '\n')
+	defer c2.Stop()
+	if _, err := st.Resume(c2, s.Token()); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, lines(b2), "two", "three")
+}
+
+func TestSessionKeepsMessagesWhichWereNeverWritten(t *testing.T) {
+	st := bufconn.NewSessionStore(nil, time.Minute)
+	a1, b1 := net.Pipe()
+	c1 := bufconn.NewConn(a1, nil, '\n')
+	s := st.Start(c1)
+	// Nothing reads b1, so the write blocks until the pipe is closed, and fails
+	s.Send("stuck")
+	time.Sleep(20 * time.Millisecond)
+	b1.Close()
+	<-c1.Done()
+	a2, b2 := net.Pipe()
+	defer b2.Close()
+	c2 := bufconn.NewConn(a2, nil, '\n')
+	defer c2.Stop()
+	if _, err := st.Resume(c2, s.Token()); err != nil {
+		t.Fatal(err)
+	}
+	got := lines(b2)
+	expectLines(t, got, "stuck")
+	s.Send("after")
+	expectLines(t, got, "after")
+}
+
+func TestSessionResumeUnknown(t *testing.T) {
+	st := bufconn.NewSessionStore(nil, time.Minute)
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	if _, err := st.Resume(c, "nope"); err != bufconn.ErrUnknownSession {
+		t.Fatalf("expected ErrUnknownSession, got %v", err)
+	}
+}
+
+func TestSessionPresenceListenerCanUseSession(t *testing.T) {
+	m := bufconn.NewManager()
+	st := bufconn.NewSessionStore(m, time.Minute)
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	s := st.Start(c)
+	s.SetMetadata("name", "alice")
+	seen := make(chan any, 2)
+	m.OnPresence(func(e bufconn.PresenceEvent) {
+		// Listeners are called without the session locked, so they can read it
+		name, _ := s.Metadata("name")
+		seen <- name
+	})
+	done := make(chan struct{})
+	go func() {
+		s.Join("room")
+		s.Leave("room")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Join or Leave deadlocked with the presence listener")
+	}
+	for i := 0; i < 2; i++ {
+		if name := <-seen; name != "alice" {
+			t.Fatalf("expected alice, got %v", name)
+		}
+	}
+}
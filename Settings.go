@@ -0,0 +1,38 @@
+package bufconn
+
+// settings are the options which can be changed from any goroutine while the connection is running, such as from a handler or just after NewConn returns.
+// They are guarded by settingsLock, so they are read with settings and changed with updateSettings
+type settings struct {
+	schedule     SchedulePolicy
+	readWeight   int
+	opWeight     int
+	metrics      Metrics
+	auditor      Auditor
+	auditCommand func(string) string
+	redactor     func(string) string
+	journal      *Journal
+	breaker      *breaker
+	emptyPolicy  EmptyPolicy
+	text         textDecoding
+	sendQueue    *sendQueue
+	writeRetry   WriteRetry
+	// timeoutCancelsRead is whether a ReadMsg timeout also stops the connection (see SetTimeoutCancelsRead)
+	timeoutCancelsRead bool
+	// ids generates the connection's message IDs and random bytes (see SetIDSource)
+	ids      IDSource
+	identity string
+}
+
+// settings returns a copy of the connection's current settings
+func (c *Conn) settings() settings {
+	c.settingsLock.RLock()
+	defer c.settingsLock.RUnlock()
+	return c.opts
+}
+
+// updateSettings changes the connection's settings with f, which is called with the lock held
+func (c *Conn) updateSettings(f func(s *settings)) {
+	c.settingsLock.Lock()
+	defer c.settingsLock.Unlock()
+	f(&c.opts)
+}
@@ -0,0 +1,56 @@
+package bufconn
+
+import (
+	"io"
+	"time"
+)
+
+// WriteRetry decides whether to carry on writing after a write to the socket wrote only some of the bytes. stalled is the number of writes in a row which have written nothing, and err is the error from the last write, which may be nil.
+// It returns whether to write the rest, and how long to wait first
+type WriteRetry func(stalled int, err error) (bool, time.Duration)
+
+// RetryShortWrites is the default WriteRetry. It writes the rest straight away as long as there was no error, giving up after three writes in a row which write nothing
+func RetryShortWrites(stalled int, err error) (bool, time.Duration) {
+	return err == nil && stalled < 3, 0
+}
+
+// SetWriteRetry sets what happens when a write to the socket only writes some of the bytes. Without retrying, the remote is left with part of a message, which corrupts the stream.
+// If r is nil, each write is tried once and a short write is returned as it is. It takes effect from the next write
+func (c *Conn) SetWriteRetry(r WriteRetry) {
+	c.updateSettings(func(s *settings) {
+		s.writeRetry = r
+	})
+}
+
+// writeFull writes bs to the socket, retrying short writes as the connection's WriteRetry allows. If it gives up after a short write without an error, io.ErrShortWrite is returned
+func (c *Conn) writeFull(bs []byte) (int, error) {
+	retryWrite := c.settings().writeRetry
+	sock := c.socket()
+	written, stalled := 0, 0
+	for {
+		n, err := sock.Write(bs[written:])
+		written += n
+		if written >= len(bs) || retryWrite == nil {
+			return written, err
+		}
+		if n == 0 {
+			stalled++
+		} else {
+			stalled = 0
+		}
+		retry, wait := retryWrite(stalled, err)
+		if !retry {
+			if err == nil {
+				err = io.ErrShortWrite
+			}
+			return written, err
+		}
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-c.done:
+				return written, ErrStopped
+			}
+		}
+	}
+}
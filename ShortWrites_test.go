@@ -0,0 +1,121 @@
+package bufconn_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// shortConn writes at most 3 bytes at a time, or nothing at all while stuck is set
+type shortConn struct {
+	net.Conn
+	stuck int32
+}
+
+func (s *shortConn) Write(bs []byte) (int, error) {
+	if atomic.LoadInt32(&s.stuck) != 0 {
+		return 0, nil
+	}
+	if len(bs) > 3 {
+		bs = bs[:3]
+	}
+	return s.Conn.Write(bs)
+}
+
+// shortWriteConn creates a connection over a shortConn, returning what the remote receives on the channel
+func shortWriteConn(t *testing.T) (*bufconn.Conn, *shortConn, <-chan string) {
+	a, b := net.Pipe()
+	short := &shortConn{Conn: a}
+	c := bufconn.NewConn(short, nil, '\n')
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	return c, short, lines(b)
+}
+
+// writeMsgNow is the same as writeNow, but also returns the number of bytes written
+func writeMsgNow(c *bufconn.Conn, msg string) (int, error) {
+	type result struct {
+		n   int
+		err error
+	}
+	results := make(chan result, 1)
+	c.QueueOperation(func(c *bufconn.C) {
+		n, err := c.WriteMsg(msg)
+		results <- result{n, err}
+	})
+	r := <-results
+	return r.n, r.err
+}
+
+func TestShortWritesRetriedByDefault(t *testing.T) {
+	c, _, got := shortWriteConn(t)
+	if n, err := writeMsgNow(c, "hello world"); n != 12 || err != nil {
+		t.Fatalf("expected the whole message to be written, got %d, %v", n, err)
+	}
+	expectLines(t, got, "hello world")
+}
+
+func TestShortWritesGiveUpWhenStalled(t *testing.T) {
+	c, short, _ := shortWriteConn(t)
+	atomic.StoreInt32(&short.stuck, 1)
+	if _, err := writeMsgNow(c, "hello"); !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("expected io.ErrShortWrite, got %v", err)
+	}
+}
+
+func TestShortWritesWithoutRetry(t *testing.T) {
+	c, _, _ := shortWriteConn(t)
+	c.SetWriteRetry(nil)
+	if n, err := writeMsgNow(c, "hello"); n != 3 || err != nil {
+		t.Fatalf("expected the short write to be returned as it is, got %d, %v", n, err)
+	}
+}
+
+func TestShortWritesCustomRetry(t *testing.T) {
+	c, short, got := shortWriteConn(t)
+	var calls int32
+	c.SetWriteRetry(func(stalled int, err error) (bool, time.Duration) {
+		if atomic.AddInt32(&calls, 1) == 2 {
+			atomic.StoreInt32(&short.stuck, 0)
+		}
+		return err == nil, 10 * time.Millisecond
+	})
+	atomic.StoreInt32(&short.stuck, 1)
+	start := time.Now()
+	if _, err := writeMsgNow(c, "abcd"); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, got, "abcd")
+	// Two stalled writes and one short one each waited before trying again
+	if atomic.LoadInt32(&calls) != 3 || time.Since(start) < 30*time.Millisecond {
+		t.Fatalf("expected 3 retries with waits, got %d in %v", calls, time.Since(start))
+	}
+}
+
+func TestShortWritesStopWhileWaiting(t *testing.T) {
+	c, short, _ := shortWriteConn(t)
+	atomic.StoreInt32(&short.stuck, 1)
+	c.SetWriteRetry(func(stalled int, err error) (bool, time.Duration) { return true, time.Hour })
+	errs := make(chan error, 1)
+	c.QueueOperation(func(c *bufconn.C) {
+		_, err := c.WriteMsg("hello")
+		errs <- err
+	})
+	time.Sleep(10 * time.Millisecond)
+	c.Stop()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, bufconn.ErrStopped) {
+			t.Fatalf("expected ErrStopped, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the write to give up when the connection stopped")
+	}
+}
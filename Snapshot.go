@@ -0,0 +1,120 @@
+package bufconn
+
+import (
+	"compress/flate"
+	"io"
+	"time"
+)
+
+// snapshotChunk is the size of the chunks a snapshot is sent in
+const snapshotChunk = 64 * 1024
+
+// SendSnapshot sends everything from r to the remote as one compressed, hash verified snapshot, for syncing full state before streaming changes as ordinary messages. It returns the number of uncompressed bytes sent.
+// progress, which may be nil, is called with the number of uncompressed bytes sent so far after each chunk. This should only be called within an operation, and nothing else can be written until it returns.
+// The remote must have called OnSnapshot. Snapshots are compressed with DEFLATE, as the standard library has no zstd
+func (c *C) SendSnapshot(r io.Reader, progress func(sent int64)) (int64, error) {
+	if err := c.writeControl("snapshot", ""); err != nil {
+		return 0, err
+	}
+	pr, pw := io.Pipe()
+	counted := &countingReader{r: r, progress: progress}
+	go func() {
+		zw, _ := flate.NewWriter(pw, flate.DefaultCompression)
+		if _, err := io.Copy(zw, counted); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(zw.Close())
+	}()
+	_, err := c.SendStream(pr, snapshotChunk)
+	// If the stream failed part way, the compressing goroutine must not be left blocked
+	pr.Close()
+	return counted.n, err
+}
+
+// OnSnapshot makes the connection understand snapshots from the remote (see SendSnapshot). When one arrives, f is called with a reader of its uncompressed contents, and no messages are handled until it returns.
+// If the snapshot fails verification, the reader returns a *StreamHashError once it reaches the end, so f should not apply what it read until then.
+// If f returns before reading everything, the rest of the snapshot is thrown away. timeout applies to each chunk as with ReceiveStream. If f is nil, snapshots are no longer understood
+func (c *Conn) OnSnapshot(f func(c *C, r io.Reader), timeout time.Duration) {
+	if f == nil {
+		c.onControl("snapshot", nil)
+		return
+	}
+	c.onControl("snapshot", func(c *C, _ string) {
+		pr, pw := io.Pipe()
+		result := make(chan error, 1)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			zr := flate.NewReader(pr)
+			f(c, &snapshotReader{r: zr, pr: pr, result: result})
+			zr.Close()
+			// Let the rest of the stream be written, so it is taken out of the buffer
+			pr.CloseWithError(io.ErrClosedPipe)
+		}()
+		_, err := c.ReceiveStream(&ignoreClosed{w: pw}, timeout)
+		result <- err
+		pw.CloseWithError(err)
+		<-done
+	})
+}
+
+// snapshotReader reads the uncompressed contents of a snapshot, only reporting the end once the snapshot's hash has been checked
+type snapshotReader struct {
+	r      io.Reader
+	pr     *io.PipeReader
+	result chan error
+	err    error
+}
+
+func (s *snapshotReader) Read(bs []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.r.Read(bs)
+	if err == io.EOF {
+		// Anything after the compressed data is not needed, but the stream must still be read to its end for the hash
+		s.pr.CloseWithError(io.ErrClosedPipe)
+		s.err = io.EOF
+		if hashErr := <-s.result; hashErr != nil {
+			s.err = hashErr
+		}
+		return n, s.err
+	}
+	if err != nil {
+		s.err = err
+	}
+	return n, err
+}
+
+// countingReader counts the bytes read through it, passing the total to progress after each read
+type countingReader struct {
+	r        io.Reader
+	n        int64
+	progress func(int64)
+}
+
+func (r *countingReader) Read(bs []byte) (int, error) {
+	n, err := r.r.Read(bs)
+	r.n += int64(n)
+	if n > 0 && r.progress != nil {
+		r.progress(r.n)
+	}
+	return n, err
+}
+
+// ignoreClosed writes to w until a write fails, after which everything is thrown away, so that a stream can still be read to its end when nothing wants the rest
+type ignoreClosed struct {
+	w      io.Writer
+	closed bool
+}
+
+func (w *ignoreClosed) Write(bs []byte) (int, error) {
+	if w.closed {
+		return len(bs), nil
+	}
+	if _, err := w.w.Write(bs); err != nil {
+		w.closed = true
+	}
+	return len(bs), nil
+}
@@ -0,0 +1,82 @@
+package bufconn_test
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// snapshotPair connects a sender to a receiver which passes snapshots to f and sends ordinary messages on the returned channel
+func snapshotPair(t *testing.T, f func(c *bufconn.C, r io.Reader)) (*bufconn.Conn, <-chan string) {
+	a, b := net.Pipe()
+	sender := bufconn.NewConn(a, nil, '\n')
+	got := make(chan string, 10)
+	receiver := bufconn.NewConn(b, func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		got <- msg
+	}, '\n')
+	receiver.OnSnapshot(f, time.Second)
+	t.Cleanup(func() {
+		sender.Stop()
+		receiver.Stop()
+	})
+	return sender, got
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	type result struct {
+		data string
+		err  error
+	}
+	results := make(chan result, 1)
+	sender, got := snapshotPair(t, func(c *bufconn.C, r io.Reader) {
+		data, err := io.ReadAll(r)
+		results <- result{string(data), err}
+	})
+	want := strings.Repeat("state line\n", 2000)
+	var progress []int64
+	sent := make(chan int64, 1)
+	sender.QueueOperation(func(c *bufconn.C) {
+		n, err := c.SendSnapshot(strings.NewReader(want), func(n int64) { progress = append(progress, n) })
+		if err != nil {
+			t.Error(err)
+		}
+		c.WriteMsg("after")
+		sent <- n
+	})
+	select {
+	case r := <-results:
+		if r.err != nil || r.data != want {
+			t.Fatalf("expected %d bytes to arrive intact, got %d, %v", len(want), len(r.data), r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the snapshot to arrive")
+	}
+	if n := <-sent; n != int64(len(want)) || len(progress) == 0 || progress[len(progress)-1] != n {
+		t.Fatalf("expected %d bytes sent and reported, got %d and %v", len(want), n, progress)
+	}
+	// Messages after the snapshot are handled as usual
+	expectLines(t, got, "after")
+}
+
+func TestSnapshotPartlyRead(t *testing.T) {
+	firsts := make(chan string, 1)
+	sender, got := snapshotPair(t, func(c *bufconn.C, r io.Reader) {
+		buf := make([]byte, 5)
+		io.ReadFull(r, buf)
+		firsts <- string(buf)
+	})
+	sender.QueueOperation(func(c *bufconn.C) {
+		c.SendSnapshot(strings.NewReader(strings.Repeat("abcde", 1000)), nil)
+		c.WriteMsg("after")
+	})
+	if first := <-firsts; first != "abcde" {
+		t.Fatalf("expected the start of the snapshot, got %q", first)
+	}
+	// The rest is thrown away, rather than being handled as messages
+	expectLines(t, got, "after")
+}
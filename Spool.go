@@ -0,0 +1,132 @@
+package bufconn
+
+import (
+	"bufio"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// spool holds received bytes in a temporary file once the in-memory buffer is full. Bytes are read back in the order they were written
+type spool struct {
+	threshold int
+	file      *os.File
+	w         *bufio.Writer
+	readOff   int64
+	writeOff  int64
+}
+
+// SetSpool makes the connection move received data to a temporary file in dir once more than threshold bytes are buffered in memory, rather than growing the buffer without limit.
+// The data is read back into memory as the handler catches up, so handlers see no difference. Only the in-memory part is counted by Buffered.
+// If dir is empty, the default temporary directory is used. If threshold is zero or less, spooling is turned off if the spool is empty.
+// The change is made by the processing loop, so data received before it gets there is buffered as before
+func (c *Conn) SetSpool(threshold int, dir string) error {
+	if threshold <= 0 {
+		c.QueueOperationPriority(func(c *C) {
+			if s := c.Conn.spool; s != nil && s.len() == 0 {
+				s.close()
+				c.Conn.spool = nil
+			}
+		}, PriorityHigh)
+		return nil
+	}
+	// The file is created here so its error can be returned, and thrown away if the connection already has a spool
+	f, err := os.CreateTemp(dir, "bufconn-spool-*")
+	if err != nil {
+		return err
+	}
+	created := &spool{threshold: threshold, file: f, w: bufio.NewWriter(f)}
+	// Whichever of the operation and the connection stopping comes first decides what happens to the file, so it is removed even if the operation never runs
+	var handled sync.Once
+	c.goTracked("spool cleanup", func() {
+		<-c.done
+		handled.Do(created.close)
+	})
+	c.QueueOperationPriority(func(c *C) {
+		handled.Do(func() {
+			if s := c.Conn.spool; s != nil {
+				s.threshold = threshold
+				created.close()
+				return
+			}
+			c.Conn.spool = created
+		})
+	}, PriorityHigh)
+	return nil
+}
+
+// len returns the number of bytes in the spool
+func (s *spool) len() int64 {
+	return s.writeOff - s.readOff
+}
+
+// write adds a byte to the end of the spool
+func (s *spool) write(b byte) error {
+	if err := s.w.WriteByte(b); err != nil {
+		return err
+	}
+	s.writeOff++
+	return nil
+}
+
+// read removes up to n bytes from the start of the spool
+func (s *spool) read(n int) ([]byte, error) {
+	if err := s.w.Flush(); err != nil {
+		return nil, err
+	}
+	if l := s.len(); int64(n) > l {
+		n = int(l)
+	}
+	bs := make([]byte, n)
+	if _, err := s.file.ReadAt(bs, s.readOff); err != nil {
+		return nil, err
+	}
+	s.readOff += int64(n)
+	if s.len() == 0 {
+		// Start the file again so it does not grow forever
+		s.readOff, s.writeOff = 0, 0
+		if err := s.file.Truncate(0); err != nil {
+			return nil, err
+		}
+		if _, err := s.file.Seek(0, 0); err != nil {
+			return nil, err
+		}
+	}
+	return bs, nil
+}
+
+// close closes and removes the spool file. It does nothing to a nil spool
+func (s *spool) close() {
+	if s == nil {
+		return
+	}
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// spooling checks if newly received bytes should go to the spool rather than the in-memory buffer
+func (c *Conn) spooling() bool {
+	return c.spool != nil && (c.spool.len() > 0 || len(c.readBuf) >= c.spool.threshold)
+}
+
+// unspool moves bytes from the spool back into the in-memory buffer until it is full again. It carries on past full if there is not yet a complete message in memory, so that messages longer than the threshold can still be read
+func (c *Conn) unspool() {
+	s := c.spool
+	if s == nil {
+		return
+	}
+	for s.len() > 0 && (len(c.readBuf) < s.threshold || c.nextDelim() < 0) {
+		n := s.threshold - len(c.readBuf)
+		if n <= 0 {
+			// Looking for the end of a long message
+			n = 4096
+		}
+		bs, err := s.read(n)
+		if err != nil {
+			c.stopWithErr(err)
+			return
+		}
+		c.readBuf = append(c.readBuf, bs...)
+		atomic.AddInt64(&c.buffered, int64(len(bs)))
+	}
+}
@@ -0,0 +1,85 @@
+package bufconn_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestSpoolHoldsDataOnDiskUntilRead(t *testing.T) {
+	dir := t.TempDir()
+	a, b := net.Pipe()
+	defer b.Close()
+	var open int32
+	got := make(chan string, 30)
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		// Nothing is read until the gate opens, so received data piles up
+		if atomic.LoadInt32(&open) == 0 {
+			return
+		}
+		for {
+			msg, ok := c.TryReadMsg()
+			if !ok {
+				return
+			}
+			got <- msg
+		}
+	}, '\n')
+	defer c.Stop()
+	if err := c.SetSpool(32, dir); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i := 0; i < 20; i++ {
+		msg := fmt.Sprintf("msg-%05d", i)
+		want = append(want, msg)
+		b.Write([]byte(msg + "\n"))
+	}
+	waitFor(t, "data to be spooled", func() bool { return c.Buffered() <= 32 })
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("expected one spool file, got %d", len(files))
+	}
+	atomic.StoreInt32(&open, 1)
+	b.Write([]byte("end\n"))
+	expectLines(t, got, append(want, "end")...)
+	c.Stop()
+	waitFor(t, "the spool file to be removed", func() bool {
+		files, _ := os.ReadDir(dir)
+		return len(files) == 0
+	})
+}
+
+func TestSpoolLongMessage(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	var open int32
+	got := make(chan string, 2)
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		if atomic.LoadInt32(&open) == 0 {
+			return
+		}
+		for {
+			msg, ok := c.TryReadMsg()
+			if !ok {
+				return
+			}
+			got <- msg
+		}
+	}, '\n')
+	defer c.Stop()
+	if err := c.SetSpool(8, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	long := fmt.Sprintf("%0100d", 7)
+	b.Write([]byte("short\n" + long + "\n"))
+	time.Sleep(20 * time.Millisecond)
+	atomic.StoreInt32(&open, 1)
+	b.Write([]byte("end\n"))
+	// A message longer than the threshold is read back whole
+	expectLines(t, got, "short", long, "end")
+}
@@ -0,0 +1,100 @@
+package bufconn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxStreamChunk is the largest chunk ReceiveStream will accept, so that a corrupt length can not make it allocate huge buffers
+const maxStreamChunk = 1 << 24
+
+// defaultStreamChunk is the chunk size SendStream uses when it is not given one, the same as io.Copy's buffer
+const defaultStreamChunk = 32 * 1024
+
+// StreamHashError is returned by ReceiveStream when the data received does not match the hash the sender computed
+type StreamHashError struct {
+	Expected, Actual []byte
+	// Size is the number of bytes received
+	Size int64
+}
+
+func (e *StreamHashError) Error() string {
+	return fmt.Sprintf("stream of %d bytes failed verification: sha256 %x, expected %x", e.Size, e.Actual, e.Expected)
+}
+
+// SendStream writes everything from r to the remote in chunks of up to chunkSize bytes, followed by the SHA-256 hash of the whole stream. It returns the number of bytes sent.
+// If chunkSize is zero or less, 32KiB chunks are used, and chunks are never larger than ReceiveStream accepts.
+// The stream is raw bytes rather than messages, so this should only be called within an operation, and the remote must be told (for example with a message written just before) to read it with ReceiveStream
+func (c *C) SendStream(r io.Reader, chunkSize int) (int64, error) {
+	if chunkSize <= 0 {
+		chunkSize = defaultStreamChunk
+	} else if chunkSize > maxStreamChunk {
+		chunkSize = maxStreamChunk
+	}
+	hash := sha256.New()
+	buf := make([]byte, 4+chunkSize)
+	var total int64
+	for {
+		n, err := r.Read(buf[4:])
+		if n > 0 {
+			hash.Write(buf[4 : 4+n])
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := c.Write(buf[:4+n]); err != nil {
+				return total, err
+			}
+			total += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	// A zero length chunk ends the stream and is followed by the hash
+	end := append(make([]byte, 4), hash.Sum(nil)...)
+	_, err := c.Write(end)
+	return total, err
+}
+
+// ReceiveStream reads a stream sent with SendStream, writing it to w as it arrives, and returns the number of bytes received. This should only be called within a handler or operation.
+// Once the stream ends, its hash is checked, and if it does not match a *StreamHashError is returned. The data has already been written to w by then, so it should be thrown away.
+// The timeout applies to each chunk rather than the whole stream. If the timeout is zero, then no timeout will be used
+func (c *C) ReceiveStream(w io.Writer, timeout time.Duration) (int64, error) {
+	hash := sha256.New()
+	var total int64
+	for {
+		header, err := c.Read(4, timeout)
+		if err != nil {
+			return total, err
+		}
+		n := binary.BigEndian.Uint32(header)
+		if n == 0 {
+			break
+		}
+		if n > maxStreamChunk {
+			return total, fmt.Errorf("stream chunk of %d bytes is too large", n)
+		}
+		chunk, err := c.Read(int(n), timeout)
+		if err != nil {
+			return total, err
+		}
+		hash.Write(chunk)
+		if _, err := w.Write(chunk); err != nil {
+			return total, err
+		}
+		total += int64(n)
+	}
+	expected, err := c.Read(sha256.Size, timeout)
+	if err != nil {
+		return total, err
+	}
+	if actual := hash.Sum(nil); !bytes.Equal(actual, expected) {
+		return total, &StreamHashError{expected, actual, total}
+	}
+	return total, nil
+}
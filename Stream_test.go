@@ -0,0 +1,96 @@
+package bufconn_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// receiveStream receives a stream on c from an operation, returning the data and error once it ends
+func receiveStream(c *bufconn.Conn) (<-chan []byte, <-chan error) {
+	data, errs := make(chan []byte, 1), make(chan error, 1)
+	c.QueueOperation(func(c *bufconn.C) {
+		var buf bytes.Buffer
+		_, err := c.ReceiveStream(&buf, time.Second)
+		data <- buf.Bytes()
+		errs <- err
+	})
+	return data, errs
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	a, b := net.Pipe()
+	ca := bufconn.NewConn(a, nil, '\n')
+	defer ca.Stop()
+	cb := bufconn.NewConn(b, nil, '\n')
+	defer cb.Stop()
+	want := make([]byte, 4500)
+	rand.New(rand.NewSource(1)).Read(want)
+	data, errs := receiveStream(cb)
+	sent := make(chan int64, 1)
+	ca.QueueOperation(func(c *bufconn.C) {
+		n, _ := c.SendStream(bytes.NewReader(want), 1000)
+		sent <- n
+	})
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if got := <-data; !bytes.Equal(got, want) {
+		t.Fatalf("expected %d bytes to arrive intact, got %d", len(want), len(got))
+	}
+	if n := <-sent; n != int64(len(want)) {
+		t.Fatalf("expected %d bytes sent, got %d", len(want), n)
+	}
+}
+
+func TestStreamDetectsCorruption(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	_, errs := receiveStream(c)
+	// One chunk of "hello", then the end of the stream with a hash of all zeros
+	stream := []byte{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o', 0, 0, 0, 0}
+	b.Write(append(stream, make([]byte, 32)...))
+	var hashErr *bufconn.StreamHashError
+	if err := <-errs; !errors.As(err, &hashErr) || hashErr.Size != 5 {
+		t.Fatalf("expected a StreamHashError for 5 bytes, got %v", err)
+	}
+}
+
+func TestStreamRejectsHugeChunk(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	_, errs := receiveStream(c)
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, 1<<30)
+	b.Write(header)
+	if err := <-errs; err == nil {
+		t.Fatal("expected an error for a chunk over the limit")
+	}
+}
+
+func TestStreamEndsWhenStopped(t *testing.T) {
+	a, b := net.Pipe()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	_, errs := receiveStream(c)
+	b.Write([]byte{0, 0, 0, 5, 'h'})
+	b.Close()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected an error when the stream is cut short")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected ReceiveStream to return once the connection stopped")
+	}
+}
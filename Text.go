@@ -0,0 +1,69 @@
+package bufconn
+
+import (
+	"bytes"
+	"errors"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is the error a connection stops with when it receives a message which is not valid UTF-8 while using InvalidReject
+var ErrInvalidUTF8 = errors.New("message is not valid UTF-8")
+
+// Charset is the character set a connection's remote sends its text in
+type Charset int
+
+const (
+	// CharsetUTF8 is for remotes which send UTF-8. This is the default
+	CharsetUTF8 Charset = iota
+	// CharsetLatin1 is for remotes which send ISO-8859-1. Every message is converted to UTF-8 as it is read
+	CharsetLatin1
+)
+
+// InvalidText is what a connection does with received messages which are not valid UTF-8
+type InvalidText int
+
+const (
+	// InvalidKeep passes invalid messages on unchanged. This is the default
+	InvalidKeep InvalidText = iota
+	// InvalidReplace replaces every invalid byte sequence with the Unicode replacement character
+	InvalidReplace
+	// InvalidReject stops the connection with ErrInvalidUTF8
+	InvalidReject
+)
+
+// textDecoding is how a connection converts and validates received text
+type textDecoding struct {
+	charset Charset
+	invalid InvalidText
+}
+
+// SetTextDecoding makes received messages be converted from the charset to UTF-8 and then checked, so that handlers can assume they are always given clean strings when talking to older systems.
+// Messages which are still not valid UTF-8 are dealt with as invalid says. Outgoing messages are not changed
+func (c *Conn) SetTextDecoding(charset Charset, invalid InvalidText) {
+	c.updateSettings(func(s *settings) {
+		s.text = textDecoding{charset, invalid}
+	})
+}
+
+// decode converts a message to clean UTF-8, returning false if it was rejected
+func (t textDecoding) decode(msg []byte) ([]byte, bool) {
+	if t.charset == CharsetLatin1 {
+		msg = latin1ToUTF8(msg)
+	}
+	if t.invalid == InvalidKeep || utf8.Valid(msg) {
+		return msg, true
+	}
+	if t.invalid == InvalidReject {
+		return nil, false
+	}
+	return bytes.ToValidUTF8(msg, []byte(string(utf8.RuneError))), true
+}
+
+// latin1ToUTF8 converts ISO-8859-1 text, where every byte is the code point of the same value, to UTF-8
+func latin1ToUTF8(msg []byte) []byte {
+	out := make([]byte, 0, len(msg))
+	for _, b := range msg {
+		out = utf8.AppendRune(out, rune(b))
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+package bufconn_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// waitFor polls cond until it is true, failing the test if it is not within a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTextDecodingLatin1(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	got := make(chan string, 1)
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		got <- msg
+	}, '\n')
+	defer c.Stop()
+	c.SetTextDecoding(bufconn.CharsetLatin1, bufconn.InvalidReject)
+	b.Write([]byte("caf\xe9\n"))
+	select {
+	case msg := <-got:
+		if msg != "café" {
+			t.Fatalf("expected café, got %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestTextDecodingReplace(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	got := make(chan string, 1)
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		got <- msg
+	}, '\n')
+	defer c.Stop()
+	c.SetTextDecoding(bufconn.CharsetUTF8, bufconn.InvalidReplace)
+	b.Write([]byte("a\xffb\n"))
+	select {
+	case msg := <-got:
+		if msg != "a�b" {
+			t.Fatalf("expected the invalid byte to be replaced, got %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestInvalidRejectEndsBlockedRead(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	errs := make(chan error, 1)
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		_, err := c.ReadMsg(0)
+		errs <- err
+	}, '\n')
+	defer c.Stop()
+	c.SetTextDecoding(bufconn.CharsetUTF8, bufconn.InvalidReject)
+	b.Write([]byte("\xff\n"))
+	select {
+	case err := <-errs:
+		if !errors.Is(err, bufconn.ErrInvalidUTF8) {
+			t.Fatalf("expected ErrInvalidUTF8, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadMsg did not return once the connection stopped")
+	}
+	if !errors.Is(c.Err(), bufconn.ErrInvalidUTF8) {
+		t.Fatalf("expected the connection to stop with ErrInvalidUTF8, got %v", c.Err())
+	}
+}
+
+func TestInvalidRejectStopsDefaultHandler(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	c.SetTextDecoding(bufconn.CharsetUTF8, bufconn.InvalidReject)
+	b.Write([]byte("\xff\n"))
+	// The default handler waits with ReadMsg, so the processing loop only exits if ReadMsg gives up once the connection stops
+	waitFor(t, "the processing loop to exit", func() bool {
+		d := c.Debug()
+		return d.Stopped && d.LoopState == ""
+	})
+}
@@ -0,0 +1,73 @@
+package bufconn
+
+import (
+	"sync"
+	"time"
+)
+
+// rateWindow is the longest window, in seconds, that rates are kept for
+const rateWindow = 60
+
+// rateCounter counts bytes in one second buckets, so rates over recent windows can be found. It is safe for concurrent use
+type rateCounter struct {
+	lock    sync.Mutex
+	buckets [rateWindow]uint64
+	seconds [rateWindow]int64
+	total   uint64
+}
+
+// add counts n bytes as having happened now
+func (r *rateCounter) add(n int) {
+	sec := time.Now().Unix()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	i := sec % rateWindow
+	if r.seconds[i] != sec {
+		r.seconds[i] = sec
+		r.buckets[i] = 0
+	}
+	r.buckets[i] += uint64(n)
+	r.total += uint64(n)
+}
+
+// rate returns the average bytes per second over the last window seconds, including the current one
+func (r *rateCounter) rate(window int64) float64 {
+	sec := time.Now().Unix()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var sum uint64
+	for i := range r.buckets {
+		if r.seconds[i] > sec-window {
+			sum += r.buckets[i]
+		}
+	}
+	return float64(sum) / float64(window)
+}
+
+// totalBytes returns the number of bytes counted since the counter was created
+func (r *rateCounter) totalBytes() uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.total
+}
+
+// Throughput is how much data a connection has moved, as rolling averages in bytes per second and lifetime totals in bytes
+type Throughput struct {
+	In1s, In10s, In60s    float64
+	Out1s, Out10s, Out60s float64
+	TotalIn, TotalOut     uint64
+}
+
+// Throughput returns the connection's recent read and write rates. Reads are counted as bytes arrive from the socket, not when they are read from the buffer
+func (c *Conn) Throughput() Throughput {
+	return Throughput{
+		In1s:     c.bytesIn.rate(1),
+		In10s:    c.bytesIn.rate(10),
+		In60s:    c.bytesIn.rate(60),
+		Out1s:    c.bytesOut.rate(1),
+		Out10s:   c.bytesOut.rate(10),
+		Out60s:   c.bytesOut.rate(60),
+		TotalIn:  c.bytesIn.totalBytes(),
+		TotalOut: c.bytesOut.totalBytes(),
+	}
+}
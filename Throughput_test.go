@@ -0,0 +1,34 @@
+package bufconn_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestThroughputCountsBothDirections(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	got := lines(b)
+	b.Write([]byte(strings.Repeat("x", 99) + "\n"))
+	c.SendMsg(strings.Repeat("y", 49), bufconn.PriorityNormal)
+	expectLines(t, got, strings.Repeat("y", 49))
+	waitFor(t, "bytes to be counted", func() bool {
+		tp := c.Throughput()
+		return tp.TotalIn == 100 && tp.TotalOut == 50
+	})
+	tp := c.Throughput()
+	if tp.In10s != 10 || tp.In60s != 100.0/60 {
+		t.Fatalf("expected rolling inbound rates of 10 and %v, got %v and %v", 100.0/60, tp.In10s, tp.In60s)
+	}
+	if tp.Out10s != 5 || tp.Out60s != 50.0/60 {
+		t.Fatalf("expected rolling outbound rates of 5 and %v, got %v and %v", 50.0/60, tp.Out10s, tp.Out60s)
+	}
+	if tp.In1s > 100 || tp.Out1s > 50 {
+		t.Fatalf("unexpected one second rates %+v", tp)
+	}
+}
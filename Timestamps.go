@@ -0,0 +1,53 @@
+package bufconn
+
+import (
+	"sync"
+	"time"
+)
+
+// arrivals holds when each complete message in the buffer was received from the socket, oldest first. It is safe for concurrent use
+type arrivals struct {
+	lock  sync.Mutex
+	times []time.Time
+}
+
+// push records that a message was received at t
+func (a *arrivals) push(t time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.times = append(a.times, t)
+}
+
+// pop returns when the oldest message was received, and forgets it. It returns the zero time if there are none
+func (a *arrivals) pop() time.Time {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.times) == 0 {
+		return time.Time{}
+	}
+	t := a.times[0]
+	a.times = a.times[1:]
+	return t
+}
+
+// ReceivedAt returns when the last message read from the buffer was received from the socket, as a time with a monotonic clock reading.
+// time.Since(c.ReceivedAt()) is how long the message waited inside the package before the handler got it, including time spent behind other handlers and operations.
+// Bytes read from the socket in one go share a time. It returns the zero time if no message has been read
+func (c *C) ReceivedAt() time.Time {
+	return c.Conn.receivedAt
+}
+
+// takeArrival records when the message just taken from the buffer was received
+func (c *Conn) takeArrival() {
+	c.receivedAt = c.arrivals.pop()
+}
+
+// rawTaken keeps arrival times and message counts in step when a raw read takes bs from the buffer, as any delimiters in it were counted as messages when they arrived
+func (c *Conn) rawTaken(bs []byte) {
+	for _, b := range bs {
+		if c.isDelim(b) {
+			c.msgsTaken++
+			c.arrivals.pop()
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package bufconn_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// arrival is a message and when the handler was told it was received
+type arrival struct {
+	msg string
+	at  time.Time
+}
+
+// arrivalConn creates a connection whose handler sends each message with its receive time on the returned channel
+func arrivalConn(t *testing.T) (*bufconn.Conn, net.Conn, <-chan arrival) {
+	a, b := net.Pipe()
+	got := make(chan arrival, 10)
+	c := bufconn.NewConn(a, func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		got <- arrival{msg, c.ReceivedAt()}
+	}, '\n')
+	t.Cleanup(func() {
+		c.Stop()
+		b.Close()
+	})
+	return c, b, got
+}
+
+func TestReceivedAtIsWhenMessageArrived(t *testing.T) {
+	c, remote, got := arrivalConn(t)
+	release, started := make(chan struct{}), make(chan struct{})
+	c.QueueOperation(func(*bufconn.C) {
+		close(started)
+		<-release
+	})
+	<-started
+	before := time.Now()
+	remote.Write([]byte("a\n"))
+	time.Sleep(30 * time.Millisecond)
+	between := time.Now()
+	remote.Write([]byte("b\n"))
+	waitFor(t, "messages to arrive", func() bool { return c.Buffered() == 4 })
+	time.Sleep(30 * time.Millisecond)
+	handled := time.Now()
+	close(release)
+	a, b := <-got, <-got
+	if a.msg != "a" || a.at.Before(before) || !a.at.Before(between) {
+		t.Fatalf("expected a to be received between %v and %v, got %v", before, between, a.at)
+	}
+	if b.msg != "b" || b.at.Before(between) || !b.at.Before(handled) {
+		t.Fatalf("expected b to be received between %v and %v, got %v", between, handled, b.at)
+	}
+}
+
+func TestReceivedAtAfterRawRead(t *testing.T) {
+	c, remote, got := arrivalConn(t)
+	release, started := make(chan struct{}), make(chan struct{})
+	c.QueueOperation(func(c *bufconn.C) {
+		close(started)
+		<-release
+		c.Read(2, 0)
+	})
+	<-started
+	remote.Write([]byte("x\n"))
+	time.Sleep(30 * time.Millisecond)
+	sent := time.Now()
+	remote.Write([]byte("y\n"))
+	close(release)
+	// The raw read took the first message, so its time must not be given to the second
+	if y := <-got; y.msg != "y" || y.at.Before(sent) {
+		t.Fatalf("expected y to be received after %v, got %v", sent, y.at)
+	}
+}
+
+func TestEnvelopeReceivedAt(t *testing.T) {
+	r := bufconn.NewRegistry()
+	got := make(chan time.Time, 1)
+	r.Use("", func(c *bufconn.C, env bufconn.Envelope, next func() error) error {
+		got <- env.ReceivedAt
+		return next()
+	})
+	bufconn.RegisterJSON(r, "greeting", func(c *bufconn.C, g greeting) {})
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, r.Handler(), '\n')
+	defer c.Stop()
+	before := time.Now()
+	b.Write([]byte(`{"type":"greeting","body":"{}"}` + "\n"))
+	if at := <-got; at.Before(before) || at.After(time.Now()) {
+		t.Fatalf("expected the envelope to be received after %v, got %v", before, at)
+	}
+}
@@ -0,0 +1,112 @@
+package bufconn
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	upgradePropose = "BUFCONN-UPGRADE "
+	upgradeAccept  = "BUFCONN-UPGRADE-OK "
+	upgradeReject  = "BUFCONN-UPGRADE-NO "
+)
+
+// ErrUpgradeRejected is returned by ProposeUpgrade when the remote does not accept the upgrade
+var ErrUpgradeRejected = errors.New("upgrade rejected by remote")
+
+// Upgrade is a change to how a connection frames and handles messages, which both ends switch to at the same point in the stream
+type Upgrade struct {
+	// Name identifies the upgrade to the remote. It must not contain spaces or either delimiter
+	Name string
+	// Delim is the delimiter used for messages after the upgrade
+	Delim byte
+	// Handler is the message handler used after the upgrade. If nil, the current handler is kept
+	Handler func(*C)
+}
+
+// ProposeUpgrade asks the remote to switch to the upgrade, and switches this end too if it agrees. This should only be called from within an operation.
+// While waiting for the remote to answer, nothing is written, and any messages the remote sent before it saw the proposal are passed to the current handler, just as the processing loop would pass them (so rate limits and metrics still apply).
+// If the connection stops while waiting, the error it stopped with is returned, or ErrStopped if there was none.
+// The remote must be using UpgradeHandler to answer. If both ends propose an upgrade at the same time, both are rejected. If the timeout is zero, then no timeout will be used
+func (c *C) ProposeUpgrade(u Upgrade, timeout time.Duration) error {
+	if _, err := c.WriteMsg(upgradePropose + u.Name); err != nil {
+		return err
+	}
+	now := time.Now()
+	for {
+		if time.Since(now) > timeout && timeout != 0 {
+			return errors.New("upgrade answer timeout")
+		}
+		stopped := c.Conn.IsStopped()
+		msg, ok := c.peekMsg()
+		if !ok {
+			if stopped {
+				return c.Conn.stoppedErr()
+			}
+			continue
+		}
+		switch {
+		case msg == upgradeAccept+u.Name:
+			c.TryReadMsg()
+			c.applyUpgrade(u)
+			return nil
+		case msg == upgradeReject+u.Name:
+			c.TryReadMsg()
+			return ErrUpgradeRejected
+		case strings.HasPrefix(msg, upgradePropose):
+			// The remote is proposing its own upgrade at the same time, so neither is accepted
+			c.TryReadMsg()
+			if _, err := c.WriteMsg(upgradeReject + strings.TrimPrefix(msg, upgradePropose)); err != nil {
+				return err
+			}
+		default:
+			if !c.Conn.callHandler() {
+				return errors.New("message handler did not read a message while waiting for upgrade answer")
+			}
+		}
+	}
+}
+
+// UpgradeHandler wraps a message handler so that it answers upgrades proposed by the remote. All other messages are passed to next.
+// accept is called with the name of each proposed upgrade, and returns the upgrade to switch to and whether to accept it.
+// The handler installed by an upgrade should also be wrapped with UpgradeHandler if further upgrades are expected
+func UpgradeHandler(accept func(name string) (Upgrade, bool), next func(*C)) func(*C) {
+	if next == nil {
+		next = func(c *C) {
+			c.ReadMsg(0)
+		}
+	}
+	return func(c *C) {
+		msg, _ := c.peekMsg()
+		if !strings.HasPrefix(msg, upgradePropose) {
+			next(c)
+			return
+		}
+		c.TryReadMsg()
+		name := strings.TrimPrefix(msg, upgradePropose)
+		u, ok := accept(name)
+		if !ok {
+			c.WriteMsg(upgradeReject + name)
+			return
+		}
+		// The remote writes nothing between its proposal and our answer, so everything after the answer uses the new framing
+		if _, err := c.WriteMsg(upgradeAccept + name); err != nil {
+			return
+		}
+		c.applyUpgrade(u)
+	}
+}
+
+// applyUpgrade switches to the upgrade's framing and handler. Any messages already buffered in the new framing are handled by the processing loop once the current handler or operation returns
+func (c *C) applyUpgrade(u Upgrade) {
+	atomic.StoreUint32(&c.msgDelim, uint32(u.Delim))
+	if u.Handler != nil {
+		c.SetMessageHandler(u.Handler)
+	}
+	c.partialSince = time.Time{}
+	if n := len(c.readBuf); n > 0 && !c.isDelim(c.readBuf[n-1]) {
+		c.partialSince = time.Now()
+	}
+}
@@ -0,0 +1,123 @@
+package bufconn_test
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// countingMetrics counts the messages read and handler calls reported to it
+type countingMetrics struct {
+	reads, handlers int64
+}
+
+func (m *countingMetrics) MessageRead(int) { atomic.AddInt64(&m.reads, 1) }
+
+func (m *countingMetrics) HandlerDone(time.Duration) { atomic.AddInt64(&m.handlers, 1) }
+
+func TestUpgradeBetweenConns(t *testing.T) {
+	a, b := net.Pipe()
+	upgraded := make(chan string, 1)
+	accept := func(name string) (bufconn.Upgrade, bool) {
+		return bufconn.Upgrade{Name: name, Delim: 0, Handler: func(c *bufconn.C) {
+			msg, _ := c.ReadMsg(0)
+			upgraded <- msg
+		}}, name == "binary"
+	}
+	server := bufconn.NewConn(b, bufconn.UpgradeHandler(accept, nil), '\n')
+	defer server.Stop()
+	client := bufconn.NewConn(a, nil, '\n')
+	defer client.Stop()
+	errs := make(chan error, 1)
+	client.QueueOperation(func(c *bufconn.C) {
+		err := c.ProposeUpgrade(bufconn.Upgrade{Name: "binary", Delim: 0}, time.Second)
+		if err == nil {
+			_, err = c.WriteMsg("after\nupgrade")
+		}
+		errs <- err
+	})
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-upgraded:
+		if msg != "after\nupgrade" {
+			t.Fatalf("expected the message framed with the new delimiter, got %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message received after the upgrade")
+	}
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	a, b := net.Pipe()
+	server := bufconn.NewConn(b, bufconn.UpgradeHandler(func(string) (bufconn.Upgrade, bool) { return bufconn.Upgrade{}, false }, nil), '\n')
+	defer server.Stop()
+	client := bufconn.NewConn(a, nil, '\n')
+	defer client.Stop()
+	errs := make(chan error, 1)
+	client.QueueOperation(func(c *bufconn.C) {
+		errs <- c.ProposeUpgrade(bufconn.Upgrade{Name: "binary", Delim: 0}, time.Second)
+	})
+	if err := <-errs; !errors.Is(err, bufconn.ErrUpgradeRejected) {
+		t.Fatalf("expected ErrUpgradeRejected, got %v", err)
+	}
+}
+
+func TestUpgradeHandlesEarlierMessagesLikeTheLoop(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	handled := make(chan string, 1)
+	client := bufconn.NewConn(a, func(c *bufconn.C) {
+		msg, _ := c.ReadMsg(0)
+		handled <- msg
+	}, '\n')
+	defer client.Stop()
+	m := &countingMetrics{}
+	client.SetMetrics(m)
+	errs := make(chan error, 1)
+	client.QueueOperation(func(c *bufconn.C) {
+		errs <- c.ProposeUpgrade(bufconn.Upgrade{Name: "v2", Delim: '\n'}, time.Second)
+	})
+	// The remote sends a message before it has seen the proposal, then accepts it
+	r := bufio.NewReader(b)
+	if line, err := r.ReadString('\n'); err != nil || line != "BUFCONN-UPGRADE v2\n" {
+		t.Fatalf("expected the proposal, got %q, %v", line, err)
+	}
+	b.Write([]byte("early\nBUFCONN-UPGRADE-OK v2\n"))
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-handled; msg != "early" {
+		t.Fatalf("expected early to be handled, got %q", msg)
+	}
+	if n := atomic.LoadInt64(&m.handlers); n != 1 {
+		t.Fatalf("expected the handler call to be reported to the metrics, got %d", n)
+	}
+}
+
+func TestUpgradeEndsWhenStopped(t *testing.T) {
+	a, b := net.Pipe()
+	client := bufconn.NewConn(a, nil, '\n')
+	defer client.Stop()
+	errs := make(chan error, 1)
+	client.QueueOperation(func(c *bufconn.C) {
+		errs <- c.ProposeUpgrade(bufconn.Upgrade{Name: "v2", Delim: '\n'}, 0)
+	})
+	bufio.NewReader(b).ReadString('\n')
+	b.Close()
+	// The loop is busy with the operation, so the reader gives it a second to notice before stopping the connection itself
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected an error once the connection stopped")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("ProposeUpgrade did not return once the connection stopped")
+	}
+}
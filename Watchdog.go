@@ -0,0 +1,103 @@
+package bufconn
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// WatchdogReport describes a processing loop which has been stuck on one thing for too long
+type WatchdogReport struct {
+	ConnID uint64
+	// StuckOn is what the loop is doing: "handler" or "operation", followed by " (writing)" if it is blocked in a write to the socket
+	StuckOn string
+	// Running is how long it has been doing it
+	Running time.Duration
+	// QueuedOps is the number of operations waiting behind it
+	QueuedOps int
+	// ReadBacklog is the number of received bytes waiting to be added to the buffer
+	ReadBacklog int
+	// Stack is the stack trace of the processing loop's goroutine, showing where it is stuck
+	Stack string
+}
+
+func (r WatchdogReport) String() string {
+	return fmt.Sprintf("conn %d stuck on %s for %v (%d operations queued, %d bytes waiting)\n%s", r.ConnID, r.StuckOn, r.Running, r.QueuedOps, r.ReadBacklog, r.Stack)
+}
+
+// Watchdog calls report whenever the connection's processing loop has spent longer than limit on a single handler call or operation, so that a connection which has stopped responding can be debugged.
+// Each stuck call is only reported once. The connection is not stopped: use LimitHandlerTime or DetectWriteStall for that
+func Watchdog(conn *Conn, limit time.Duration, report func(WatchdogReport)) {
+	conn.goTracked("watchdog", func() {
+		t := time.NewTicker(checkInterval(limit))
+		defer conn.track("timer", "watchdog ticker")()
+		defer t.Stop()
+		var reported int64
+		for {
+			select {
+			case <-conn.done:
+				return
+			case <-t.C:
+			}
+			stuckOn, started := "handler", atomic.LoadInt64(&conn.handlerStart)
+			if started == 0 {
+				stuckOn, started = "operation", atomic.LoadInt64(&conn.opStart)
+			}
+			if started == 0 || started == reported {
+				continue
+			}
+			running := time.Since(time.Unix(0, started))
+			if running < limit {
+				continue
+			}
+			reported = started
+			if atomic.LoadInt64(&conn.writeStart) != 0 {
+				stuckOn += " (writing)"
+			}
+			report(WatchdogReport{
+				ConnID:      conn.id,
+				StuckOn:     stuckOn,
+				Running:     running,
+				QueuedOps:   len(conn.opSignal),
+				ReadBacklog: len(conn.readChan),
+				Stack:       goroutineStack(atomic.LoadInt64(&conn.loopGoroutine)),
+			})
+		}
+	})
+}
+
+// goroutineID returns the ID of the calling goroutine, as shown in stack traces
+func goroutineID() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	// The trace starts with "goroutine 123 ["
+	fields := bytes.Fields(buf)
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseInt(string(fields[1]), 10, 64)
+	return id
+}
+
+// goroutineStack returns the stack trace of the goroutine with the ID, or an empty string if it can not be found
+func goroutineStack(id int64) string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	header := []byte("goroutine " + strconv.FormatInt(id, 10) + " [")
+	for _, trace := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(trace, header) {
+			return string(trace)
+		}
+	}
+	return ""
+}
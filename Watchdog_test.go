@@ -0,0 +1,65 @@
+package bufconn_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// stuckOperation blocks until release is closed, so it shows up by name in the watchdog's stack trace
+func stuckOperation(release chan struct{}) func(*bufconn.C) {
+	return func(*bufconn.C) {
+		<-release
+	}
+}
+
+func TestWatchdogReportsStuckOperation(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	reports := make(chan bufconn.WatchdogReport, 10)
+	bufconn.Watchdog(c, 30*time.Millisecond, func(r bufconn.WatchdogReport) { reports <- r })
+	release := make(chan struct{})
+	c.QueueOperation(stuckOperation(release))
+	c.QueueOperation(func(*bufconn.C) {})
+	var r bufconn.WatchdogReport
+	select {
+	case r = <-reports:
+	case <-time.After(time.Second):
+		t.Fatal("expected a report")
+	}
+	close(release)
+	if r.ConnID != c.ID() || r.StuckOn != "operation" || r.QueuedOps != 1 || r.Running < 30*time.Millisecond {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	if !strings.Contains(r.Stack, "stuckOperation") {
+		t.Fatalf("expected the stack to show where the loop is stuck, got:\n%s", r.Stack)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := len(reports); n != 0 {
+		t.Fatalf("expected the stuck call to be reported once, got %d more", n)
+	}
+}
+
+func TestWatchdogReportsBlockedWrite(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := bufconn.NewConn(a, nil, '\n')
+	defer c.Stop()
+	reports := make(chan bufconn.WatchdogReport, 10)
+	bufconn.Watchdog(c, 30*time.Millisecond, func(r bufconn.WatchdogReport) { reports <- r })
+	// Nothing reads the other end of the pipe
+	c.SendMsg("hello", bufconn.PriorityNormal)
+	select {
+	case r := <-reports:
+		if r.StuckOn != "operation (writing)" {
+			t.Fatalf("expected the report to say the loop is writing, got %q", r.StuckOn)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a report")
+	}
+}
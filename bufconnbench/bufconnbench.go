@@ -0,0 +1,295 @@
+// Package bufconnbench provides an echo server and a load generating client built on bufconn, for measuring the capacity of a deployment and comparing bufconn's framing modes
+package bufconnbench
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// Delim is the delimiter used by both the server and the client
+const Delim = '\n'
+
+// Framing is how messages are sent between the client and the server
+type Framing int
+
+const (
+	// FramingPlain sends each message as it is, followed by the delimiter
+	FramingPlain Framing = iota
+	// FramingHeaders adds a one byte header to every message (see bufconn.Handshake.MessageHeaders)
+	FramingHeaders
+	// FramingCompressed compresses every message with a dictionary (see bufconn.Handshake.Dictionaries)
+	FramingCompressed
+)
+
+func (f Framing) String() string {
+	switch f {
+	case FramingPlain:
+		return "plain"
+	case FramingHeaders:
+		return "headers"
+	case FramingCompressed:
+		return "compressed"
+	default:
+		return "Framing(" + strconv.Itoa(int(f)) + ")"
+	}
+}
+
+// dictionary is the compression dictionary both ends have, which matches the padding of the messages the client sends
+var dictionary = bufconn.Dictionary{ID: "bufconnbench", Data: []byte(strings.Repeat(padding, 4))}
+
+// padding is repeated to fill each message up to its size
+const padding = "the quick brown fox jumps over the lazy dog "
+
+// handshake returns the handshake the client performs, which only enables what the framing needs
+func (f Framing) handshake() bufconn.Handshake {
+	h := bufconn.Handshake{Versions: []int{1}}
+	switch f {
+	case FramingHeaders:
+		h.MessageHeaders = true
+	case FramingCompressed:
+		h.Dictionaries = []bufconn.Dictionary{dictionary}
+	}
+	return h
+}
+
+// serverHandshake supports every framing, so the client's handshake decides which is used
+var serverHandshake = bufconn.Handshake{
+	Versions:       []int{1},
+	Dictionaries:   []bufconn.Dictionary{dictionary},
+	MessageHeaders: true,
+}
+
+// Serve accepts connections from l and echoes every message received on them back to the remote, with whichever framing the client asked for. It returns the listener's error once l fails or is closed
+func Serve(l net.Listener) error {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go bufconn.NewConnHandshake(nc, echo, Delim, serverHandshake)
+	}
+}
+
+// echo is the message handler of the server's connections
+func echo(c *bufconn.C) {
+	msg, _ := c.ReadMsg(0)
+	for {
+		c.WriteMsg(msg)
+		var ok bool
+		if msg, ok = c.TryReadMsg(); !ok {
+			return
+		}
+	}
+}
+
+// Config is the load the client generates
+type Config struct {
+	// Addr is the address of the server, which is dialled over TCP
+	Addr string
+	// Dial, if not nil, is used to connect to the server instead of dialling Addr, for example to benchmark over net.Pipe
+	Dial func() (net.Conn, error)
+	// Framing is how messages are sent
+	Framing Framing
+	// Size is the size of each message in bytes, not including the delimiter. Messages are never shorter than their sequence number and send time
+	Size int
+	// Connections is the number of connections to the server, each sending on its own. If zero, one is used
+	Connections int
+	// Rate is the number of messages each connection sends per second. If zero, each connection sends as fast as InFlight allows
+	Rate float64
+	// InFlight is the most messages each connection sends before their echoes have come back. If zero, there is no limit when Rate is set, and one otherwise
+	InFlight int
+	// Duration is how long to send for. Once it is over, echoes of the messages already sent are waited for, for up to a second
+	Duration time.Duration
+}
+
+// Result is what happened during a run
+type Result struct {
+	Framing Framing
+	// Sent and Received are the numbers of messages sent and echoed back, and Bytes is the number of bytes they contained each way
+	Sent     uint64
+	Received uint64
+	Bytes    uint64
+	// Elapsed is how long sending went on for
+	Elapsed time.Duration
+	// Latency is how long each message took to be echoed back, in seconds
+	Latency bufconn.HistogramSnapshot
+}
+
+// MessagesPerSecond returns the rate at which echoes came back
+func (r Result) MessagesPerSecond() float64 {
+	return float64(r.Received) / r.Elapsed.Seconds()
+}
+
+// BytesPerSecond returns the rate at which echoed bytes came back
+func (r Result) BytesPerSecond() float64 {
+	return float64(r.Bytes) / r.Elapsed.Seconds()
+}
+
+func (r Result) String() string {
+	ms := func(q float64) float64 { return r.Latency.Quantile(q) * 1000 }
+	return fmt.Sprintf(
+		"%s: sent %d, received %d in %v (%.0f msg/s, %.2f MB/s), latency p50 %.3fms p90 %.3fms p99 %.3fms max %.3fms",
+		r.Framing, r.Sent, r.Received, r.Elapsed, r.MessagesPerSecond(), r.BytesPerSecond()/1e6, ms(0.5), ms(0.9), ms(0.99), r.Latency.Max*1000,
+	)
+}
+
+// Run connects to an echo server (see Serve) and sends messages to it as described by cfg, returning once every connection is done
+func Run(cfg Config) (Result, error) {
+	if cfg.Duration <= 0 {
+		return Result{}, errors.New("duration must be above zero")
+	}
+	if cfg.Connections <= 0 {
+		cfg.Connections = 1
+	}
+	if cfg.InFlight <= 0 && cfg.Rate <= 0 {
+		cfg.InFlight = 1
+	}
+	dial := cfg.Dial
+	if dial == nil {
+		dial = func() (net.Conn, error) { return net.Dial("tcp", cfg.Addr) }
+	}
+	r := &run{cfg: cfg, latency: bufconn.NewHistogram(1e-6, 2, 31)}
+	conns := make([]*bufconn.Conn, cfg.Connections)
+	windows := make([]chan struct{}, cfg.Connections)
+	for i := range conns {
+		nc, err := dial()
+		if err != nil {
+			stopAll(conns)
+			return Result{}, err
+		}
+		if cfg.InFlight > 0 {
+			windows[i] = make(chan struct{}, cfg.InFlight)
+		}
+		if conns[i], err = bufconn.NewConnHandshake(nc, r.handler(windows[i]), Delim, cfg.Framing.handshake()); err != nil {
+			stopAll(conns)
+			return Result{}, err
+		}
+	}
+	start := time.Now()
+	end := start.Add(cfg.Duration)
+	var wg sync.WaitGroup
+	for i, c := range conns {
+		wg.Add(1)
+		go func(c *bufconn.Conn, window chan struct{}) {
+			defer wg.Done()
+			r.send(c, window, end)
+		}(c, windows[i])
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	// Wait for the echoes of what was sent
+	for wait := time.Now(); time.Since(wait) < time.Second; time.Sleep(time.Millisecond) {
+		if atomic.LoadUint64(&r.received) >= atomic.LoadUint64(&r.sent) {
+			break
+		}
+	}
+	stopAll(conns)
+	return Result{
+		Framing:  cfg.Framing,
+		Sent:     atomic.LoadUint64(&r.sent),
+		Received: atomic.LoadUint64(&r.received),
+		Bytes:    atomic.LoadUint64(&r.bytes),
+		Elapsed:  elapsed,
+		Latency:  r.latency.Snapshot(),
+	}, nil
+}
+
+// run is the state of one call to Run, shared by its connections
+type run struct {
+	cfg      Config
+	sent     uint64
+	received uint64
+	bytes    uint64
+	latency  *bufconn.Histogram
+}
+
+// send sends messages on c until end. If window is not nil, a slot is taken from it for each message, and given back by the handler when the echo arrives
+func (r *run) send(c *bufconn.Conn, window chan struct{}, end time.Time) {
+	var tick <-chan time.Time
+	if r.cfg.Rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / r.cfg.Rate))
+		defer t.Stop()
+		tick = t.C
+	}
+	timeout := time.After(time.Until(end))
+	for seq := uint64(0); ; seq++ {
+		if window != nil {
+			select {
+			case window <- struct{}{}:
+			case <-timeout:
+				return
+			case <-c.Done():
+				return
+			}
+		}
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-timeout:
+				return
+			case <-c.Done():
+				return
+			}
+		}
+		if time.Now().After(end) {
+			return
+		}
+		msg := r.message(seq)
+		c.QueueOperation(func(c *bufconn.C) {
+			c.WriteMsg(msg)
+		})
+		atomic.AddUint64(&r.sent, 1)
+	}
+}
+
+// message creates a message of the configured size, starting with its sequence number and send time
+func (r *run) message(seq uint64) string {
+	msg := strconv.FormatUint(seq, 10) + " " + strconv.FormatInt(time.Now().UnixNano(), 10) + " "
+	if fill := r.cfg.Size - len(msg); fill > 0 {
+		msg += strings.Repeat(padding, fill/len(padding)+1)[:fill]
+	}
+	return msg
+}
+
+// handler returns the message handler of a client connection, which records the latency of each echo and frees its slot in window
+func (r *run) handler(window chan struct{}) func(*bufconn.C) {
+	return func(c *bufconn.C) {
+		for {
+			msg, ok := c.TryReadMsg()
+			if !ok {
+				return
+			}
+			fields := strings.SplitN(msg, " ", 3)
+			if len(fields) >= 2 {
+				if sent, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+					r.latency.Observe(time.Since(time.Unix(0, sent)).Seconds())
+				}
+			}
+			atomic.AddUint64(&r.received, 1)
+			atomic.AddUint64(&r.bytes, uint64(len(msg)))
+			if window != nil {
+				select {
+				case <-window:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// stopAll stops every connection which was created
+func stopAll(conns []*bufconn.Conn) {
+	for _, c := range conns {
+		if c != nil {
+			c.Stop()
+		}
+	}
+}
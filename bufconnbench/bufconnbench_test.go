@@ -0,0 +1,67 @@
+package bufconnbench
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// server starts an echo server on a local TCP port, closed when the test ends
+func server(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go Serve(l)
+	return l.Addr().String()
+}
+
+func TestRunEachFraming(t *testing.T) {
+	addr := server(t)
+	for _, f := range []Framing{FramingPlain, FramingHeaders, FramingCompressed} {
+		r, err := Run(Config{Addr: addr, Framing: f, Size: 100, Connections: 2, InFlight: 4, Duration: 100 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Sent == 0 || r.Received != r.Sent || r.Bytes != r.Received*100 || r.Latency.Count != r.Received {
+			t.Fatalf("expected every message of 100 bytes to be echoed with %v, got %+v", f, r)
+		}
+		if !strings.HasPrefix(r.String(), f.String()+": sent") {
+			t.Fatalf("unexpected summary %q", r.String())
+		}
+	}
+}
+
+func TestRunAtRate(t *testing.T) {
+	r, err := Run(Config{Addr: server(t), Rate: 100, Duration: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Sent < 10 || r.Sent > 21 || r.Received != r.Sent {
+		t.Fatalf("expected about 20 messages to be sent and echoed, got %+v", r)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	if _, err := Run(Config{Addr: server(t)}); err == nil {
+		t.Fatal("expected an error for a run with no duration")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	if _, err := Run(Config{Addr: addr, Duration: time.Second}); err == nil {
+		t.Fatal("expected an error when the server can not be reached")
+	}
+}
+
+func TestFramingString(t *testing.T) {
+	if s := Framing(7).String(); s != "Framing(7)" {
+		t.Fatalf("unexpected name %q", s)
+	}
+}
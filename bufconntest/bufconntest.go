@@ -0,0 +1,202 @@
+// Package bufconntest provides helpers for testing code built on bufconn
+package bufconntest
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+// Conn is a bufconn.Conn which records every message it receives, so that tests can assert on them
+type Conn struct {
+	*bufconn.Conn
+	msgs chan string
+}
+
+// Wrap creates a recording Conn from a net.Conn
+func Wrap(c net.Conn, delim byte) *Conn {
+	return wrapWith(c, delim, nil)
+}
+
+// wrapWith creates a Conn from a net.Conn with handler as its message handler, or recording its messages if handler is nil.
+// The handler is given to the connection as it is created, so that no message can arrive before it is in place
+func wrapWith(c net.Conn, delim byte, handler func(*bufconn.C)) *Conn {
+	rc := &Conn{msgs: make(chan string, 1000)}
+	if handler == nil {
+		handler = rc.record
+	}
+	rc.Conn = bufconn.NewConn(c, handler, delim)
+	return rc
+}
+
+// record is the message handler of a recording Conn
+func (c *Conn) record(bc *bufconn.C) {
+	msg, _ := bc.ReadMsg(0)
+	c.msgs <- msg
+	for {
+		msg, ok := bc.TryReadMsg()
+		if !ok {
+			return
+		}
+		c.msgs <- msg
+	}
+}
+
+// Pipe creates two recording Conns connected to each other in memory. They are stopped when the test finishes
+func Pipe(t testing.TB, delim byte) (*Conn, *Conn) {
+	a, b := net.Pipe()
+	ca, cb := Wrap(a, delim), Wrap(b, delim)
+	t.Cleanup(func() {
+		ca.Stop()
+		cb.Stop()
+	})
+	return ca, cb
+}
+
+// Send queues a message to be written to the remote
+func (c *Conn) Send(msg string) {
+	c.QueueOperation(func(bc *bufconn.C) {
+		bc.WriteMsg(msg)
+	})
+}
+
+// Next waits for the next message received. If the timeout is reached, it returns an error
+func (c *Conn) Next(timeout time.Duration) (string, error) {
+	select {
+	case msg := <-c.msgs:
+		return msg, nil
+	case <-time.After(timeout):
+		return "", errors.New("no message received within " + timeout.String())
+	}
+}
+
+// AssertReceives fails the test if the next message the conn receives, within the timeout, is not want
+func AssertReceives(t testing.TB, c *Conn, want string, timeout time.Duration) {
+	t.Helper()
+	got, err := c.Next(timeout)
+	if err != nil {
+		t.Fatalf("expected message %q: %v", want, err)
+	}
+	if got != want {
+		t.Fatalf("expected message %q, got %q", want, got)
+	}
+}
+
+// AssertNoMessage fails the test if the conn receives any message within wait
+func AssertNoMessage(t testing.TB, c *Conn, wait time.Duration) {
+	t.Helper()
+	if got, err := c.Next(wait); err == nil {
+		t.Fatalf("expected no message, got %q", got)
+	}
+}
+
+// AssertStops fails the test if the conn has not stopped within the timeout
+func AssertStops(t testing.TB, c *bufconn.Conn, timeout time.Duration) {
+	t.Helper()
+	select {
+	case <-c.Done():
+	case <-time.After(timeout):
+		t.Fatalf("expected connection to stop within %v", timeout)
+	}
+}
+
+// DrainMessages returns every message received so far which has not already been returned by Next or another assertion
+func DrainMessages(c *Conn) []string {
+	msgs := make([]string, 0)
+	for {
+		select {
+		case msg := <-c.msgs:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+// Server listens on a local TCP port for the duration of a test
+type Server struct {
+	t        testing.TB
+	listener net.Listener
+	delim    byte
+	handler  func(*bufconn.C)
+	accepted chan *Conn
+	lock     sync.Mutex
+	conns    []*Conn
+}
+
+// NewServer starts a server on a free local port, which is closed (along with every connection it accepted) when the test finishes.
+// If handler is nil, accepted connections record their messages so they can be asserted on, otherwise handler is used as their message handler
+func NewServer(t testing.TB, delim byte, handler func(*bufconn.C)) *Server {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start test server: %v", err)
+	}
+	s := &Server{
+		t:        t,
+		listener: l,
+		delim:    delim,
+		handler:  handler,
+		accepted: make(chan *Conn, 100),
+	}
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *Server) serve() {
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := wrapWith(nc, s.delim, s.handler)
+		s.lock.Lock()
+		s.conns = append(s.conns, c)
+		s.lock.Unlock()
+		s.accepted <- c
+	}
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Dial connects a new recording Conn to the server, which is stopped when the test finishes
+func (s *Server) Dial() *Conn {
+	s.t.Helper()
+	nc, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		s.t.Fatalf("could not dial test server: %v", err)
+	}
+	c := Wrap(nc, s.delim)
+	s.t.Cleanup(c.Stop)
+	return c
+}
+
+// Accept waits for the server to accept its next connection, failing the test if none arrives within the timeout
+func (s *Server) Accept(timeout time.Duration) *Conn {
+	s.t.Helper()
+	select {
+	case c := <-s.accepted:
+		return c
+	case <-time.After(timeout):
+		s.t.Fatalf("no connection accepted within %v", timeout)
+		return nil
+	}
+}
+
+// Close stops the server and every connection it has accepted
+func (s *Server) Close() {
+	s.listener.Close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.conns {
+		c.Stop()
+	}
+}
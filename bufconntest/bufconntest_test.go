@@ -0,0 +1,81 @@
+package bufconntest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/bufconn"
+)
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe(t, '\n')
+	a.Send("hello")
+	a.Send("world")
+	AssertReceives(t, b, "hello", time.Second)
+	AssertReceives(t, b, "world", time.Second)
+	AssertNoMessage(t, a, 50*time.Millisecond)
+}
+
+func TestServerRecords(t *testing.T) {
+	s := NewServer(t, '\n', nil)
+	client := s.Dial()
+	client.Send("hello")
+	AssertReceives(t, s.Accept(time.Second), "hello", time.Second)
+}
+
+func TestServerHandlerGetsFirstMessage(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		s := NewServer(t, '\n', func(c *bufconn.C) {
+			for {
+				msg, ok := c.TryReadMsg()
+				if !ok {
+					return
+				}
+				c.WriteMsg("echo " + msg)
+			}
+		})
+		client := s.Dial()
+		client.Send("first")
+		AssertReceives(t, client, "echo first", time.Second)
+	}
+}
+
+func TestAssertStops(t *testing.T) {
+	a, b := Pipe(t, '\n')
+	a.Stop()
+	AssertStops(t, a.Conn, time.Second)
+	DrainMessages(b)
+}
+
+func TestAssertStopsRemote(t *testing.T) {
+	// Stopping one end closes the pipe, so the other end's reader stops it while its own goroutines are still running
+	for i := 0; i < 20; i++ {
+		a, b := Pipe(t, '\n')
+		a.Send("bye")
+		AssertReceives(t, b, "bye", time.Second)
+		a.Stop()
+		AssertStops(t, b.Conn, time.Second)
+		if !b.IsStopped() {
+			t.Fatal("expected IsStopped once Done is closed")
+		}
+	}
+}
+
+func TestDrainMessages(t *testing.T) {
+	a, b := Pipe(t, '\n')
+	a.Send("one")
+	a.Send("two")
+	AssertReceives(t, b, "one", time.Second)
+	deadline := time.Now().Add(time.Second)
+	var got []string
+	for len(got) == 0 && time.Now().Before(deadline) {
+		got = DrainMessages(b)
+		time.Sleep(time.Millisecond)
+	}
+	if len(got) != 1 || got[0] != "two" {
+		t.Fatalf("expected [two], got %q", got)
+	}
+	if got := DrainMessages(b); len(got) != 0 {
+		t.Fatalf("expected nothing left, got %q", got)
+	}
+}